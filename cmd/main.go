@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -29,7 +30,10 @@ import (
 	membercfg "github.com/codeready-toolchain/toolchain-common/pkg/configuration/memberoperatorconfig"
 	"github.com/codeready-toolchain/toolchain-common/pkg/status"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
@@ -159,6 +163,12 @@ func main() {
 	}
 	crtConfig.Print()
 
+	// the idler settings are loaded before the Idler controller starts, so the Idlers are not reconciled with the defaults
+	if err := loadIdlerConfig(cfg, namespace); err != nil {
+		setupLog.Error(err, "failed to load the idler settings")
+		os.Exit(1)
+	}
+
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
 	if err != nil {
 		setupLog.Error(err, "failed to create discovery client")
@@ -191,6 +201,12 @@ func main() {
 	// resources (secrets, etc.).
 	allNamespacesCluster, err := runtimecluster.New(cfg, func(options *runtimecluster.Options) {
 		options.Scheme = scheme
+		// Pod metrics can't be watched, so read them directly from the API server (used by the activity-based idling).
+		options.Client = client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&kmetrics.PodMetrics{}}}}
+		// only the idler reports are cached, so the cache doesn't store all ConfigMaps of the cluster
+		options.Cache.ByObject = map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}: {Label: labels.SelectorFromSet(labels.Set{idler.IdlerReportManagedLabelKey: "true"})},
+		}
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start allNamespaceCluster")
@@ -258,6 +274,8 @@ func main() {
 		RestClient:          restClient,
 		GetHostCluster:      cluster.GetHostCluster,
 		Namespace:           namespace,
+		GetConfig:           idler.GetCachedConfig,
+		Recorder:            mgr.GetEventRecorderFor("idler"),
 	}).SetupWithManager(mgr, allNamespacesCluster); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Idler")
 		os.Exit(1)
@@ -370,3 +388,15 @@ func getCRTConfiguration(config *rest.Config) (membercfg.Configuration, error) {
 
 	return membercfg.GetConfiguration(cl)
 }
+
+func loadIdlerConfig(config *rest.Config, namespace string) error {
+	// create client that will be used for retrieving the idler section of the member operator config
+	cl, err := client.New(config, client.Options{
+		Scheme: scheme,
+	})
+	if err != nil {
+		return err
+	}
+
+	return membercfgctrl.LoadIdlerConfig(context.TODO(), cl, types.NamespacedName{Namespace: namespace, Name: "config"})
+}
@@ -0,0 +1,191 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	metrics "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// LastActiveAnnotationKey is the annotation set on the top known owner of the active pods (or on the standalone pod itself)
+// with the last time the workload was seen as active, so the activity is not lost when the operator is restarted
+const LastActiveAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idler-last-active"
+
+// activityTracker keeps the last time when a workload was seen as active. The workloads are identified by the top known
+// owner of the pods (or by the pod itself if it's a standalone pod), so the activity is preserved even when the pods
+// are recreated or the intermediate owners are replaced, eg. the ReplicaSets of a Deployment during a rollout.
+// The zero value is ready to use.
+type activityTracker struct {
+	mu         sync.RWMutex
+	lastActive map[string]activity
+}
+
+type activity struct {
+	namespace string
+	time      time.Time
+}
+
+// markActive records that the workload was active at the given time (if it's newer than the one already recorded)
+func (t *activityTracker) markActive(namespace, key string, activeAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastActive == nil {
+		t.lastActive = map[string]activity{}
+	}
+	if last, found := t.lastActive[key]; found && !last.time.Before(activeAt) {
+		return
+	}
+	t.lastActive[key] = activity{namespace: namespace, time: activeAt}
+}
+
+// lastActiveAt returns the last time the workload was seen as active, if ever
+func (t *activityTracker) lastActiveAt(key string) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	last, found := t.lastActive[key]
+	return last.time, found
+}
+
+// prune removes all records of the given namespace that don't belong to any of the given (still existing) workloads
+func (t *activityTracker) prune(namespace string, existing map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, last := range t.lastActive {
+		if last.namespace == namespace && !existing[key] {
+			delete(t.lastActive, key)
+		}
+	}
+}
+
+// idleSince returns the time since when the pod is considered idle - it's either the last time the given workload
+// was seen as active, or the start time of the pod if the workload has been never seen active since then.
+// The pod is expected to have the start time set.
+func (t *activityTracker) idleSince(key string, pod *corev1.Pod) time.Time {
+	startTime := pod.Status.StartTime.Time
+	if t == nil {
+		return startTime
+	}
+	if last, found := t.lastActiveAt(key); found && last.After(startTime) {
+		return last
+	}
+	return startTime
+}
+
+// idleSince returns the time since when the pod is considered idle. When the activity-based idling is disabled,
+// it's the start time of the pod.
+// The pod is expected to have the start time set.
+func (i *ownerIdler) idleSince(ctx context.Context, pod *corev1.Pod) time.Time {
+	if !i.config.ActivityBasedIdling() {
+		return pod.Status.StartTime.Time
+	}
+	key, _ := i.workloadOf(ctx, pod)
+	return i.activity.idleSince(key, pod)
+}
+
+// workloadOf returns the key identifying the workload of the pod in the activity tracker, and the top known owner
+// of the pod keeping the last activity of the workload. The owner is nil if the pod has no known owner,
+// in such a case the workload is the pod itself.
+func (i *ownerIdler) workloadOf(ctx context.Context, pod *corev1.Pod) (string, *objectWithGVR) {
+	if owner := i.topKnownOwner(ctx, pod); owner != nil {
+		return fmt.Sprintf("%s/%s/%s", pod.Namespace, owner.object.GetKind(), owner.object.GetName()), owner
+	}
+	return fmt.Sprintf("%s/Pod/%s", pod.Namespace, pod.Name), nil
+}
+
+// trackedWorkload is a workload whose activity is tracked together with the last activity persisted in its annotation
+type trackedWorkload struct {
+	// object is either the top known owner or the standalone pod
+	object    metav1.Object
+	owner     *objectWithGVR
+	persisted time.Time
+}
+
+// trackActivity reads the PodMetrics of the given pods and marks the workloads whose pods use more CPU than the configured
+// threshold as active. The last activity is persisted in the annotation of the workloads and restored from it,
+// so it survives the restarts of the operator.
+func (r *Reconciler) trackActivity(ctx context.Context, ownerIdler *ownerIdler, namespace string, pods []corev1.Pod) error {
+	workloads := make(map[string]*trackedWorkload, len(pods))
+	podKeys := make(map[string]string, len(pods))
+	for i := range pods {
+		key, owner := ownerIdler.workloadOf(ctx, &pods[i])
+		podKeys[pods[i].Name] = key
+		if _, found := workloads[key]; found {
+			continue
+		}
+		workload := &trackedWorkload{object: &pods[i], owner: owner}
+		if owner != nil {
+			workload.object = owner.object
+		}
+		if value, found := workload.object.GetAnnotations()[LastActiveAnnotationKey]; found {
+			if lastActive, err := time.Parse(time.RFC3339, value); err == nil {
+				workload.persisted = lastActive
+				r.activity.markActive(namespace, key, lastActive)
+			}
+		}
+		workloads[key] = workload
+	}
+	existing := make(map[string]bool, len(workloads))
+	for key := range workloads {
+		existing[key] = true
+	}
+	r.activity.prune(namespace, existing)
+
+	podMetricsList := &metrics.PodMetricsList{}
+	if err := r.AllNamespacesClient.List(ctx, podMetricsList, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	threshold := r.config().ActivityCPUThreshold()
+	active := map[string]bool{}
+	for _, podMetrics := range podMetricsList.Items {
+		key, found := podKeys[podMetrics.Name]
+		if !found {
+			continue
+		}
+		usage := resource.Quantity{}
+		for _, container := range podMetrics.Containers {
+			usage.Add(container.Usage[corev1.ResourceCPU])
+		}
+		if usage.Cmp(threshold) > 0 {
+			log.FromContext(ctx).Info("Pod is active", "pod_name", podMetrics.Name, "cpu_usage", usage.String())
+			r.activity.markActive(namespace, key, podMetrics.Timestamp.Time)
+			active[key] = true
+		}
+	}
+	if ownerIdler.dryRun {
+		// the workloads are not modified in the dry-run mode
+		return nil
+	}
+	for key := range active {
+		lastActive, _ := r.activity.lastActiveAt(key)
+		lastActive = lastActive.UTC().Truncate(time.Second)
+		if !lastActive.After(workloads[key].persisted) {
+			continue
+		}
+		if err := r.persistActivity(ctx, ownerIdler, workloads[key], lastActive); err != nil {
+			// not returning the error, the activity is still tracked in memory
+			log.FromContext(ctx).Error(err, "failed to persist the last activity", "workload", key)
+		}
+	}
+	return nil
+}
+
+// persistActivity sets the annotation with the last activity on the top known owner or on the standalone pod
+func (r *Reconciler) persistActivity(ctx context.Context, ownerIdler *ownerIdler, workload *trackedWorkload, lastActive time.Time) error {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, LastActiveAnnotationKey, lastActive.Format(time.RFC3339)))
+	if workload.owner == nil {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: workload.object.GetNamespace(), Name: workload.object.GetName()}}
+		return r.AllNamespacesClient.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch))
+	}
+	_, err := ownerIdler.dynamicClient.Resource(*workload.owner.gvr).Namespace(workload.object.GetNamespace()).
+		Patch(ctx, workload.object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,243 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	metrics "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestActivityTracker(t *testing.T) {
+	// given
+	startTime := time.Now().Add(-time.Hour)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "john-dev"},
+		Status:     corev1.PodStatus{StartTime: &metav1.Time{Time: startTime}},
+	}
+
+	t.Run("nil tracker returns start time", func(t *testing.T) {
+		var tracker *activityTracker
+		assert.Equal(t, startTime, tracker.idleSince("john-dev/Deployment/web", pod))
+	})
+
+	t.Run("no activity returns start time", func(t *testing.T) {
+		tracker := &activityTracker{}
+		assert.Equal(t, startTime, tracker.idleSince("john-dev/Deployment/web", pod))
+	})
+
+	t.Run("activity is tracked per workload", func(t *testing.T) {
+		// given
+		tracker := &activityTracker{}
+		activeAt := time.Now().Add(-time.Minute)
+
+		// when
+		tracker.markActive("john-dev", "john-dev/Deployment/web", activeAt)
+
+		// then
+		assert.Equal(t, activeAt, tracker.idleSince("john-dev/Deployment/web", pod))
+		assert.Equal(t, startTime, tracker.idleSince("john-dev/Pod/pod", pod))
+
+		t.Run("older activity is ignored", func(t *testing.T) {
+			// when
+			tracker.markActive("john-dev", "john-dev/Deployment/web", activeAt.Add(-time.Minute))
+
+			// then
+			assert.Equal(t, activeAt, tracker.idleSince("john-dev/Deployment/web", pod))
+		})
+
+		t.Run("activity before the start time is ignored", func(t *testing.T) {
+			// when
+			tracker.markActive("john-dev", "john-dev/Pod/pod", startTime.Add(-time.Minute))
+
+			// then
+			assert.Equal(t, startTime, tracker.idleSince("john-dev/Pod/pod", pod))
+		})
+
+		t.Run("prune removes only not existing workloads from the same namespace", func(t *testing.T) {
+			// given
+			tracker.markActive("john-stage", "john-stage/Deployment/web", activeAt)
+
+			// when
+			tracker.prune("john-dev", map[string]bool{"john-dev/Pod/pod": true})
+
+			// then
+			assert.Equal(t, startTime, tracker.idleSince("john-dev/Deployment/web", pod))
+			assert.Contains(t, tracker.lastActive, "john-stage/Deployment/web")
+			assert.Contains(t, tracker.lastActive, "john-dev/Pod/pod")
+		})
+	})
+}
+
+func TestEnsureIdlingWithActivity(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "john-dev",
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: TestIdlerTimeOutSeconds},
+	}
+	expiredStartTime := &metav1.Time{Time: time.Now().Add(-time.Duration(TestIdlerTimeOutSeconds+1) * time.Second)}
+
+	prepareWithCPUUsage := func(t *testing.T, cpuUsage string) (*Reconciler, reconcile.Request, *memberoperatortest.FakeClientSet, *appsv1.Deployment) {
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		reconciler.GetConfig = configFor(ConfigSpec{ActivityBasedIdling: ptr.To(true)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+		for _, pod := range pods {
+			podMetrics := &metrics.PodMetrics{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+				Timestamp:  metav1.Now(),
+				Containers: []metrics.ContainerMetrics{
+					{Name: "app", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuUsage)}},
+				},
+			}
+			require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), podMetrics))
+		}
+		return reconciler, req, fakeClients, deployment
+	}
+
+	t.Run("active workload is not idled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, deployment := prepareWithCPUUsage(t, "200m")
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		// the activity is checked again after the default check period
+		assert.Equal(t, 5*time.Minute, res.RequeueAfter)
+
+		t.Run("workload is still not idled when it becomes inactive", func(t *testing.T) {
+			// given
+			podMetricsList := &metrics.PodMetricsList{}
+			require.NoError(t, fakeClients.AllNamespacesClient.List(context.TODO(), podMetricsList))
+			for _, podMetrics := range podMetricsList.Items {
+				require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), &podMetrics))
+			}
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledUp(deployment)
+		})
+	})
+
+	t.Run("inactive workload is idled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, deployment := prepareWithCPUUsage(t, "1m")
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+
+	t.Run("workload is idled based on start time when activity-based idling is disabled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, deployment := prepareWithCPUUsage(t, "200m")
+		reconciler.GetConfig = configFor(ConfigSpec{})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+
+	t.Run("workload is idled based on start time when metrics are not available", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, deployment := prepareWithCPUUsage(t, "200m")
+		fakeClients.AllNamespacesClient.MockList = func(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*metrics.PodMetricsList); ok {
+				return fmt.Errorf("metrics not available")
+			}
+			return fakeClients.AllNamespacesClient.Client.List(ctx, list, opts...)
+		}
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+
+	t.Run("last activity is persisted on the top owner", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, deployment := prepareWithCPUUsage(t, "200m")
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		lastActive, err := time.Parse(time.RFC3339, getDeployment(t, fakeClients, deployment).GetAnnotations()[LastActiveAnnotationKey])
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), lastActive, time.Minute)
+
+		t.Run("activity is restored after restart and rollout", func(t *testing.T) {
+			// given
+			// the operator is restarted, so the activity kept in memory is lost
+			restarted := &Reconciler{
+				Client:              reconciler.Client,
+				AllNamespacesClient: reconciler.AllNamespacesClient,
+				DynamicClient:       reconciler.DynamicClient,
+				DiscoveryClient:     reconciler.DiscoveryClient,
+				RestClient:          reconciler.RestClient,
+				ScalesClient:        reconciler.ScalesClient,
+				Scheme:              reconciler.Scheme,
+				GetHostCluster:      reconciler.GetHostCluster,
+				Namespace:           reconciler.Namespace,
+				GetConfig:           reconciler.GetConfig,
+			}
+			// the deployment is rolled out, so the pods are owned by a new ReplicaSet
+			podList := &corev1.PodList{}
+			require.NoError(t, fakeClients.AllNamespacesClient.List(context.TODO(), podList, client.InNamespace(idler.Name)))
+			for _, pod := range podList.Items {
+				require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), &pod))
+			}
+			replicaSet := &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Name: deployment.Name + "-rolled-out", Namespace: idler.Name},
+				Spec:       appsv1.ReplicaSetSpec{Replicas: deployment.Spec.Replicas},
+			}
+			require.NoError(t, controllerutil.SetControllerReference(deployment, replicaSet, scheme.Scheme))
+			createObjectWithDynamicClient(t, fakeClients.DynamicClient, replicaSet)
+			createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+			// when
+			_, err := restarted.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledUp(deployment)
+		})
+	})
+}
@@ -0,0 +1,353 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// IdledHPAsAnnotationKey is set on the idled owners which were targeted by HorizontalPodAutoscalers and contains the removed
+	// HorizontalPodAutoscalers, so they can be created again when un-idling the namespace
+	IdledHPAsAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-hpas"
+)
+
+var hpaGVR = schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+
+// pauseScaledObjectStrategy pauses the KEDA ScaledObjects targeting the idled owners with zero replicas.
+// KEDA pauses also the HorizontalPodAutoscaler it manages for the ScaledObject.
+var pauseScaledObjectStrategy = IdlingStrategy{
+	Group:   "keda.sh",
+	Version: "v1alpha1",
+	Kind:    "ScaledObject",
+	Type:    PatchStrategy,
+	Patch:   `{"metadata":{"annotations":{"autoscaling.keda.sh/paused-replicas":"0"}}}`,
+}
+
+// pausedAutoscalers contains the autoscalers paused for the owner before idling it, so they can be resumed if the owner can't be idled
+type pausedAutoscalers struct {
+	// scaledObjects contains the ScaledObjects which were paused (and which were not paused before)
+	scaledObjects []*objectWithGVR
+	// removedHPAs contains the removed HorizontalPodAutoscalers
+	removedHPAs []json.RawMessage
+	// recordedHPAs is the value of the IdledHPAsAnnotationKey annotation of the owner before the HorizontalPodAutoscalers were removed
+	recordedHPAs string
+}
+
+// pauseAutoscalers makes sure that the autoscalers targeting the owner don't scale it up again once it's idled:
+// the KEDA ScaledObjects are paused and the HorizontalPodAutoscalers are removed and recorded in the owner.
+// Returns the paused autoscalers, so they can be resumed if the owner can't be idled.
+func (i *ownerIdler) pauseAutoscalers(ctx context.Context, owner *objectWithGVR, reason idleReason) (*pausedAutoscalers, error) {
+	paused := &pausedAutoscalers{}
+	return paused, errors.Join(
+		i.pauseScaledObjects(ctx, owner, reason, paused),
+		i.removeHPAs(ctx, owner, paused),
+	)
+}
+
+// resumePausedAutoscalers resumes the autoscalers paused for the owner which couldn't be idled: the paused ScaledObjects
+// are resumed, the removed HorizontalPodAutoscalers are created again and the annotation of the owner is set back.
+func (i *ownerIdler) resumePausedAutoscalers(ctx context.Context, owner *objectWithGVR, paused *pausedAutoscalers) error {
+	var resumeErrors []error
+	for _, scaledObject := range paused.scaledObjects {
+		// the listed ScaledObject doesn't contain the state recorded when it was paused
+		current, err := i.dynamicClient.Resource(*scaledObject.gvr).Namespace(scaledObject.object.GetNamespace()).Get(ctx, scaledObject.object.GetName(), metav1.GetOptions{})
+		if err == nil {
+			err = i.restore(ctx, &objectWithGVR{object: current, gvr: scaledObject.gvr}, pauseScaledObjectStrategy)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			resumeErrors = append(resumeErrors, fmt.Errorf("failed to resume ScaledObject %s: %w", scaledObject.object.GetName(), err))
+		}
+	}
+	if len(paused.removedHPAs) > 0 {
+		removed, err := json.Marshal(paused.removedHPAs)
+		if err != nil {
+			return err
+		}
+		if err := i.recreateHPAs(ctx, owner.object.GetNamespace(), string(removed)); err != nil {
+			resumeErrors = append(resumeErrors, err)
+		} else if err := i.setRecordedHPAs(ctx, owner, paused.recordedHPAs); err != nil {
+			resumeErrors = append(resumeErrors, err)
+		}
+	}
+	return errors.Join(resumeErrors...)
+}
+
+func (i *ownerIdler) pauseScaledObjects(ctx context.Context, owner *objectWithGVR, reason idleReason, paused *pausedAutoscalers) error {
+	gvr, err := findGVRForKind(pauseScaledObjectStrategy.Kind, pauseScaledObjectStrategy.groupVersionKind().GroupVersion().String(), i.ownerFetcher.resourceLists)
+	if err != nil || gvr == nil {
+		return err // KEDA is not installed in the cluster if there is no error
+	}
+	scaledObjects, err := i.dynamicClient.Resource(*gvr).Namespace(owner.object.GetNamespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var pauseErrors []error
+	for index := range scaledObjects.Items {
+		scaledObject := &objectWithGVR{object: &scaledObjects.Items[index], gvr: gvr}
+		// KEDA scales Deployments by default
+		if !targets(scaledObject.object, owner.object, "apps/v1", "Deployment") {
+			continue
+		}
+		_, pausedBefore := scaledObject.object.GetAnnotations()[IdledAtAnnotationKey]
+		if err := i.patch(ctx, scaledObject, pauseScaledObjectStrategy); err != nil {
+			pauseErrors = append(pauseErrors, fmt.Errorf("failed to pause ScaledObject %s: %w", scaledObject.object.GetName(), err))
+			continue
+		}
+		if !pausedBefore {
+			paused.scaledObjects = append(paused.scaledObjects, scaledObject)
+		}
+		if err := i.recordStateBeforeIdlingBy(ctx, scaledObject, pauseScaledObjectStrategy, reason); err != nil {
+			pauseErrors = append(pauseErrors, err)
+		}
+	}
+	return errors.Join(pauseErrors...)
+}
+
+// removeHPAs removes the HorizontalPodAutoscalers targeting the idled owner. The removed HorizontalPodAutoscalers
+// are recorded in the annotation of the owner first, so they are not lost if the removal fails.
+func (i *ownerIdler) removeHPAs(ctx context.Context, owner *objectWithGVR, paused *pausedAutoscalers) error {
+	namespace := owner.object.GetNamespace()
+	hpas, err := i.dynamicClient.Resource(hpaGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	recordedBefore := owner.object.GetAnnotations()[IdledHPAsAnnotationKey]
+	removed, err := recordedHPAs(recordedBefore)
+	if err != nil {
+		return err
+	}
+	removedBefore := len(removed)
+	var toRemove []string
+	for _, hpa := range hpas.Items {
+		// the HorizontalPodAutoscalers managed by KEDA are paused together with their ScaledObjects
+		if metav1.GetControllerOf(&hpa) != nil || !targets(&hpa, owner.object, "", "") {
+			continue
+		}
+		content, err := json.Marshal(map[string]any{
+			"apiVersion": hpa.GetAPIVersion(),
+			"kind":       hpa.GetKind(),
+			"metadata": map[string]any{
+				"name":        hpa.GetName(),
+				"labels":      hpa.GetLabels(),
+				"annotations": hpa.GetAnnotations(),
+			},
+			"spec": hpa.Object["spec"],
+		})
+		if err != nil {
+			return err
+		}
+		removed = append(removed, content)
+		toRemove = append(toRemove, hpa.GetName())
+	}
+	if len(toRemove) == 0 {
+		return nil
+	}
+	recorded, err := json.Marshal(removed)
+	if err != nil {
+		return err
+	}
+	if err := i.setRecordedHPAs(ctx, owner, string(recorded)); err != nil {
+		return err
+	}
+	paused.removedHPAs = removed[removedBefore:]
+	paused.recordedHPAs = recordedBefore
+	var removeErrors []error
+	for _, name := range toRemove {
+		log.FromContext(ctx).Info("Removing HorizontalPodAutoscaler of the idled owner", "name", name, "kind", owner.object.GetKind(), "owner_name", owner.object.GetName())
+		if err := i.dynamicClient.Resource(hpaGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			removeErrors = append(removeErrors, fmt.Errorf("failed to remove HorizontalPodAutoscaler %s: %w", name, err))
+		}
+	}
+	return errors.Join(removeErrors...)
+}
+
+// setRecordedHPAs sets the IdledHPAsAnnotationKey annotation of the owner to the given value, or removes it if the value is empty
+func (i *ownerIdler) setRecordedHPAs(ctx context.Context, owner *objectWithGVR, value string) error {
+	var annotation any
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]any{IdledHPAsAnnotationKey: annotation}}})
+	if err != nil {
+		return err
+	}
+	patched, err := i.dynamicClient.Resource(*owner.gvr).Namespace(owner.object.GetNamespace()).Patch(ctx, owner.object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	// the HorizontalPodAutoscalers recorded in the owner are needed when they are resumed in the same reconcile
+	owner.object.SetAnnotations(patched.GetAnnotations())
+	return nil
+}
+
+// recreateHPAs creates the HorizontalPodAutoscalers recorded in the given annotation value
+func (i *ownerIdler) recreateHPAs(ctx context.Context, namespace, value string) error {
+	hpas, err := recordedHPAs(value)
+	if err != nil {
+		return err
+	}
+	var createErrors []error
+	for _, content := range hpas {
+		hpa := &unstructured.Unstructured{}
+		if err := hpa.UnmarshalJSON(content); err != nil {
+			return fmt.Errorf("invalid recorded HorizontalPodAutoscaler: %w", err)
+		}
+		hpa.SetNamespace(namespace)
+		log.FromContext(ctx).Info("Creating HorizontalPodAutoscaler removed when idling", "name", hpa.GetName())
+		if _, err := i.dynamicClient.Resource(hpaGVR).Namespace(namespace).Create(ctx, hpa, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			createErrors = append(createErrors, fmt.Errorf("failed to create HorizontalPodAutoscaler %s: %w", hpa.GetName(), err))
+		}
+	}
+	return errors.Join(createErrors...)
+}
+
+// resumeScaledObjects resumes the ScaledObjects in the namespace which were paused by the idler
+func (i *ownerIdler) resumeScaledObjects(ctx context.Context, namespace string) error {
+	gvr, err := findGVRForKind(pauseScaledObjectStrategy.Kind, pauseScaledObjectStrategy.groupVersionKind().GroupVersion().String(), i.ownerFetcher.resourceLists)
+	if err != nil || gvr == nil {
+		return err // KEDA is not installed in the cluster if there is no error
+	}
+	return i.restoreIdledOwners(ctx, namespace, *gvr, pauseScaledObjectStrategy)
+}
+
+// restoreAutoscalersOfScaledUpOwners restores the autoscalers of the owners of the pod which were scaled down by the idler, but which
+// were scaled up again without un-idling the namespace (eg. by the user or by another controller fighting the idler).
+// The idler annotations are removed from such owners as they are not idled anymore - except the owners kept scaled down because of their
+// crash-looping pods, which are scaled down again instead.
+// Errors are only logged so the processing of the pod is not affected.
+func (i *ownerIdler) restoreAutoscalersOfScaledUpOwners(ctx context.Context, pod *corev1.Pod) {
+	logger := log.FromContext(ctx)
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
+	if err != nil {
+		logger.Error(err, "failed to find all owners, restore the autoscalers of the owners that are available")
+	}
+	for _, owner := range owners {
+		// the owners idled in this reconcile are not scaled down yet in the fetched version
+		if i.processed[ownerKey(owner)] || !scaledUpAfterIdling(owner.object) {
+			continue
+		}
+		if _, keptScaledDown := owner.object.GetAnnotations()[CrashLoopingAnnotationKey]; keptScaledDown {
+			continue
+		}
+		logger.Info("Idled owner was scaled up again, restoring its autoscalers", "kind", owner.object.GetKind(), "name", owner.object.GetName())
+		if err := i.restoreAutoscalers(ctx, owner); err != nil {
+			logger.Error(err, "failed to restore the autoscalers of the owner scaled up again", "kind", owner.object.GetKind(), "name", owner.object.GetName())
+		}
+	}
+}
+
+// scaledUpAfterIdling returns true if the owner was scaled down by the idler and has some replicas again
+func scaledUpAfterIdling(owner *unstructured.Unstructured) bool {
+	if _, scaledDown := owner.GetAnnotations()[IdledReplicasAnnotationKey]; !scaledDown {
+		return false
+	}
+	replicas, found, err := unstructured.NestedInt64(owner.Object, "spec", "replicas")
+	return err == nil && found && replicas > 0
+}
+
+// restoreAutoscalers creates the HorizontalPodAutoscalers removed from the owner, resumes the ScaledObjects paused for the owner
+// and removes the idler annotations from the owner, so it's not considered as idled anymore
+func (i *ownerIdler) restoreAutoscalers(ctx context.Context, owner *objectWithGVR) error {
+	namespace := owner.object.GetNamespace()
+	if value, found := owner.object.GetAnnotations()[IdledHPAsAnnotationKey]; found {
+		if err := i.recreateHPAs(ctx, namespace, value); err != nil {
+			return err
+		}
+	}
+	if err := i.resumeScaledObjectsOf(ctx, owner); err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				IdledAtAnnotationKey:          nil,
+				IdledReasonAnnotationKey:      nil,
+				IdledReplicasAnnotationKey:    nil,
+				IdledRunStateAnnotationKey:    nil,
+				IdledRevertPatchAnnotationKey: nil,
+				IdledHPAsAnnotationKey:        nil,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	patched, err := i.dynamicClient.Resource(*owner.gvr).Namespace(namespace).Patch(ctx, owner.object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	// the owner might be idled again in this reconcile, so the removed HorizontalPodAutoscalers must not be recorded twice
+	owner.object.SetAnnotations(patched.GetAnnotations())
+	return nil
+}
+
+// resumeScaledObjectsOf resumes the ScaledObjects targeting the given owner which were paused by the idler
+func (i *ownerIdler) resumeScaledObjectsOf(ctx context.Context, owner *objectWithGVR) error {
+	gvr, err := findGVRForKind(pauseScaledObjectStrategy.Kind, pauseScaledObjectStrategy.groupVersionKind().GroupVersion().String(), i.ownerFetcher.resourceLists)
+	if err != nil || gvr == nil {
+		return err // KEDA is not installed in the cluster if there is no error
+	}
+	scaledObjects, err := i.dynamicClient.Resource(*gvr).Namespace(owner.object.GetNamespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var resumeErrors []error
+	for index := range scaledObjects.Items {
+		scaledObject := &scaledObjects.Items[index]
+		if _, paused := scaledObject.GetAnnotations()[IdledAtAnnotationKey]; !paused || !targets(scaledObject, owner.object, "apps/v1", "Deployment") {
+			continue
+		}
+		if err := i.restore(ctx, &objectWithGVR{object: scaledObject, gvr: gvr}, pauseScaledObjectStrategy); err != nil {
+			resumeErrors = append(resumeErrors, fmt.Errorf("failed to resume ScaledObject %s: %w", scaledObject.GetName(), err))
+		}
+	}
+	return errors.Join(resumeErrors...)
+}
+
+func recordedHPAs(value string) ([]json.RawMessage, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var hpas []json.RawMessage
+	if err := json.Unmarshal([]byte(value), &hpas); err != nil {
+		return nil, fmt.Errorf("invalid recorded HorizontalPodAutoscalers '%s': %w", value, err)
+	}
+	return hpas, nil
+}
+
+// targets returns true if the scaleTargetRef of the given autoscaler refers to the given owner.
+// The default API version and kind are used when they are not set in the reference. The versions are not compared.
+func targets(autoscaler, owner *unstructured.Unstructured, defaultAPIVersion, defaultKind string) bool {
+	ref, found, err := unstructured.NestedMap(autoscaler.Object, "spec", "scaleTargetRef")
+	if err != nil || !found {
+		return false
+	}
+	name, _ := ref["name"].(string)
+	kind, _ := ref["kind"].(string)
+	apiVersion, _ := ref["apiVersion"].(string)
+	if kind == "" {
+		kind = defaultKind
+	}
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+	if name != owner.GetName() || kind != owner.GetKind() {
+		return false
+	}
+	if apiVersion == "" {
+		return true
+	}
+	groupVersion, err := schema.ParseGroupVersion(apiVersion)
+	return err == nil && groupVersion.Group == owner.GroupVersionKind().Group
+}
@@ -0,0 +1,302 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	fakescale "k8s.io/client-go/scale/fake"
+	clienttest "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+var (
+	deploymentGVR   = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	scaledObjectGVR = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+)
+
+// newAutoscaledDeployment returns the pod of the Deployment "web" targeted by the HorizontalPodAutoscaler "web-hpa"
+// and by the ScaledObject "web-so", and the function returning a new ownerIdler for the namespace with these objects
+func newAutoscaledDeployment(t *testing.T) (*corev1.Pod, func() *ownerIdler) {
+	newObject := func(apiVersion, kind, name string, spec map[string]any) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]any{"apiVersion": apiVersion, "kind": kind, "spec": spec}}
+		obj.SetName(name)
+		obj.SetNamespace("john-dev")
+		return obj
+	}
+	scaleTargetRef := func(apiVersion, kind, name string) map[string]any {
+		ref := map[string]any{"name": name}
+		if apiVersion != "" {
+			ref["apiVersion"] = apiVersion
+		}
+		if kind != "" {
+			ref["kind"] = kind
+		}
+		return map[string]any{"scaleTargetRef": ref, "minReplicas": int64(2), "maxReplicas": int64(5)}
+	}
+	deployment := newObject("apps/v1", "Deployment", "web", map[string]any{"replicas": int64(3)})
+	hpa := newObject("autoscaling/v2", "HorizontalPodAutoscaler", "web-hpa", scaleTargetRef("apps/v1", "Deployment", "web"))
+	hpa.SetLabels(map[string]string{"app": "web"})
+	otherHPA := newObject("autoscaling/v2", "HorizontalPodAutoscaler", "other-hpa", scaleTargetRef("apps/v1", "Deployment", "other"))
+	scaledObject := newObject("keda.sh/v1alpha1", "ScaledObject", "web-so", scaleTargetRef("", "", "web"))
+	kedaHPA := newObject("autoscaling/v2", "HorizontalPodAutoscaler", "keda-hpa-web-so", scaleTargetRef("apps/v1", "Deployment", "web"))
+	kedaHPA.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "keda.sh/v1alpha1", Kind: "ScaledObject", Name: "web-so", Controller: ptr.To(true)}})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-pod",
+			Namespace:       "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: ptr.To(true)}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-61 * time.Minute)}},
+	}
+	resources := append(allResourcesList(t), &metav1.APIResourceList{
+		GroupVersion: "keda.sh/v1alpha1",
+		APIResources: []metav1.APIResource{{Name: "scaledobjects", Namespaced: true, Kind: "ScaledObject"}},
+	})
+	listKinds := maps.Clone(customListKinds)
+	listKinds[scaledObjectGVR] = "ScaledObjectList"
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds,
+		deployment, hpa, otherHPA, scaledObject, kedaHPA)
+	return pod, func() *ownerIdler {
+		return &ownerIdler{
+			idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+			ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+			dynamicClient: dynamicClient,
+			scalesClient:  &fakescale.FakeScaleClient{},
+		}
+	}
+}
+
+func getFromJohnDev(t *testing.T, ownerIdler *ownerIdler, gvr schema.GroupVersionResource, name string) (*unstructured.Unstructured, error) {
+	t.Helper()
+	return ownerIdler.dynamicClient.Resource(gvr).Namespace("john-dev").Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func TestAutoscalersOfIdledOwners(t *testing.T) {
+	// given
+	pod, newIdler := newAutoscaledDeployment(t)
+	ownerIdler := newIdler()
+	get := func(gvr schema.GroupVersionResource, name string) (*unstructured.Unstructured, error) {
+		return getFromJohnDev(t, ownerIdler, gvr, name)
+	}
+	hpa, err := get(hpaGVR, "web-hpa")
+	require.NoError(t, err)
+
+	// when
+	_, _, err = ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	idledDeployment, err := get(deploymentGVR, "web")
+	require.NoError(t, err)
+	assertReplicas(t, idledDeployment, 0)
+	assert.Contains(t, idledDeployment.GetAnnotations()[IdledHPAsAnnotationKey], `"name":"web-hpa"`)
+	// the HPA of the idled Deployment is removed
+	_, err = get(hpaGVR, "web-hpa")
+	assert.True(t, apierrors.IsNotFound(err))
+	// the other HPAs are kept
+	_, err = get(hpaGVR, "other-hpa")
+	require.NoError(t, err)
+	_, err = get(hpaGVR, "keda-hpa-web-so")
+	require.NoError(t, err)
+	// the ScaledObject is paused
+	pausedScaledObject, err := get(scaledObjectGVR, "web-so")
+	require.NoError(t, err)
+	assert.Equal(t, "0", pausedScaledObject.GetAnnotations()["autoscaling.keda.sh/paused-replicas"])
+	assert.Equal(t, string(idleReasonTimeout), pausedScaledObject.GetAnnotations()[IdledReasonAnnotationKey])
+
+	t.Run("autoscalers are restored when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredDeployment, err := get(deploymentGVR, "web")
+		require.NoError(t, err)
+		assertReplicas(t, restoredDeployment, 3)
+		assertIdledAnnotationsRemoved(t, restoredDeployment)
+		assert.NotContains(t, restoredDeployment.GetAnnotations(), IdledHPAsAnnotationKey)
+		recreatedHPA, err := get(hpaGVR, "web-hpa")
+		require.NoError(t, err)
+		assert.Equal(t, hpa.Object["spec"], recreatedHPA.Object["spec"])
+		assert.Equal(t, map[string]string{"app": "web"}, recreatedHPA.GetLabels())
+		resumedScaledObject, err := get(scaledObjectGVR, "web-so")
+		require.NoError(t, err)
+		assert.NotContains(t, resumedScaledObject.GetAnnotations(), "autoscaling.keda.sh/paused-replicas")
+		assertIdledAnnotationsRemoved(t, resumedScaledObject)
+	})
+}
+
+func TestAutoscalersPausedBeforeIdling(t *testing.T) {
+	t.Run("autoscalers are paused before the owner is idled", func(t *testing.T) {
+		// given
+		pod, newIdler := newAutoscaledDeployment(t)
+		ownerIdler := newIdler()
+		dynamicClient := ownerIdler.dynamicClient.(*fakedynamic.FakeDynamicClient)
+
+		// when
+		_, _, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.NoError(t, err)
+		var order []string
+		for _, action := range dynamicClient.Actions() {
+			switch {
+			case action.Matches("patch", "scaledobjects") && !strings.Contains(string(action.(clienttest.PatchAction).GetPatch()), IdledAtAnnotationKey):
+				order = append(order, "pause ScaledObject")
+			case action.Matches("delete", "horizontalpodautoscalers"):
+				order = append(order, "remove HPA")
+			case action.Matches("patch", "deployments") && strings.Contains(string(action.(clienttest.PatchAction).GetPatch()), `"replicas":0`):
+				order = append(order, "idle Deployment")
+			}
+		}
+		assert.Equal(t, []string{"pause ScaledObject", "remove HPA", "idle Deployment"}, order)
+	})
+
+	t.Run("autoscalers are resumed when the owner can't be idled", func(t *testing.T) {
+		// given
+		pod, newIdler := newAutoscaledDeployment(t)
+		ownerIdler := newIdler()
+		hpa, err := getFromJohnDev(t, ownerIdler, hpaGVR, "web-hpa")
+		require.NoError(t, err)
+		ownerIdler.dynamicClient.(*fakedynamic.FakeDynamicClient).PrependReactor("patch", "deployments", func(action clienttest.Action) (bool, runtime.Object, error) {
+			if strings.Contains(string(action.(clienttest.PatchAction).GetPatch()), `"replicas":0`) {
+				return true, nil, fmt.Errorf("some error")
+			}
+			return false, nil, nil
+		})
+
+		// when
+		_, _, err = ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.ErrorContains(t, err, "some error")
+		deployment, err := getFromJohnDev(t, ownerIdler, deploymentGVR, "web")
+		require.NoError(t, err)
+		assertReplicas(t, deployment, 3)
+		assert.NotContains(t, deployment.GetAnnotations(), IdledHPAsAnnotationKey)
+		recreatedHPA, err := getFromJohnDev(t, ownerIdler, hpaGVR, "web-hpa")
+		require.NoError(t, err)
+		assert.Equal(t, hpa.Object["spec"], recreatedHPA.Object["spec"])
+		resumedScaledObject, err := getFromJohnDev(t, ownerIdler, scaledObjectGVR, "web-so")
+		require.NoError(t, err)
+		assert.NotContains(t, resumedScaledObject.GetAnnotations(), "autoscaling.keda.sh/paused-replicas")
+		assertIdledAnnotationsRemoved(t, resumedScaledObject)
+	})
+
+	t.Run("autoscalers paused by the previous idling are kept paused when the owner can't be idled again", func(t *testing.T) {
+		// given
+		pod, newIdler := newAutoscaledDeployment(t)
+		_, _, err := newIdler().scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+		require.NoError(t, err)
+		ownerIdler := newIdler()
+		// scaled up again without restoring the autoscalers
+		idledDeployment, err := ownerIdler.dynamicClient.Resource(deploymentGVR).Namespace("john-dev").
+			Patch(context.TODO(), "web", types.MergePatchType, []byte(`{"spec":{"replicas":2}}`), metav1.PatchOptions{})
+		require.NoError(t, err)
+		ownerIdler.dynamicClient.(*fakedynamic.FakeDynamicClient).PrependReactor("patch", "deployments", func(action clienttest.Action) (bool, runtime.Object, error) {
+			if strings.Contains(string(action.(clienttest.PatchAction).GetPatch()), `"replicas":0`) {
+				return true, nil, fmt.Errorf("some error")
+			}
+			return false, nil, nil
+		})
+
+		// when
+		_, _, err = ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.ErrorContains(t, err, "some error")
+		deployment, err := getFromJohnDev(t, ownerIdler, deploymentGVR, "web")
+		require.NoError(t, err)
+		assert.Equal(t, idledDeployment.GetAnnotations()[IdledHPAsAnnotationKey], deployment.GetAnnotations()[IdledHPAsAnnotationKey])
+		_, err = getFromJohnDev(t, ownerIdler, hpaGVR, "web-hpa")
+		assert.True(t, apierrors.IsNotFound(err))
+		pausedScaledObject, err := getFromJohnDev(t, ownerIdler, scaledObjectGVR, "web-so")
+		require.NoError(t, err)
+		assert.Equal(t, "0", pausedScaledObject.GetAnnotations()["autoscaling.keda.sh/paused-replicas"])
+	})
+}
+
+func TestAutoscalersOfOwnersScaledUpAgain(t *testing.T) {
+	t.Run("autoscalers are restored when the idled owner is scaled up again", func(t *testing.T) {
+		// given
+		pod, newIdler := newAutoscaledDeployment(t)
+		_, _, err := newIdler().scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+		require.NoError(t, err)
+		ownerIdler := newIdler()
+		_, err = ownerIdler.dynamicClient.Resource(deploymentGVR).Namespace("john-dev").
+			Patch(context.TODO(), "web", types.MergePatchType, []byte(`{"spec":{"replicas":2}}`), metav1.PatchOptions{})
+		require.NoError(t, err)
+
+		// when
+		ownerIdler.restoreAutoscalersOfScaledUpOwners(context.TODO(), pod)
+
+		// then
+		deployment, err := getFromJohnDev(t, ownerIdler, deploymentGVR, "web")
+		require.NoError(t, err)
+		assertReplicas(t, deployment, 2)
+		assertIdledAnnotationsRemoved(t, deployment)
+		assert.NotContains(t, deployment.GetAnnotations(), IdledHPAsAnnotationKey)
+		_, err = getFromJohnDev(t, ownerIdler, hpaGVR, "web-hpa")
+		require.NoError(t, err)
+		resumedScaledObject, err := getFromJohnDev(t, ownerIdler, scaledObjectGVR, "web-so")
+		require.NoError(t, err)
+		assert.NotContains(t, resumedScaledObject.GetAnnotations(), "autoscaling.keda.sh/paused-replicas")
+		assertIdledAnnotationsRemoved(t, resumedScaledObject)
+	})
+
+	t.Run("autoscalers are kept paused while the idled owner is scaled down", func(t *testing.T) {
+		// given
+		pod, newIdler := newAutoscaledDeployment(t)
+		_, _, err := newIdler().scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+		require.NoError(t, err)
+		ownerIdler := newIdler()
+
+		// when
+		ownerIdler.restoreAutoscalersOfScaledUpOwners(context.TODO(), pod)
+
+		// then
+		deployment, err := getFromJohnDev(t, ownerIdler, deploymentGVR, "web")
+		require.NoError(t, err)
+		assertReplicas(t, deployment, 0)
+		assert.Contains(t, deployment.GetAnnotations(), IdledHPAsAnnotationKey)
+		_, err = getFromJohnDev(t, ownerIdler, hpaGVR, "web-hpa")
+		assert.True(t, apierrors.IsNotFound(err))
+		pausedScaledObject, err := getFromJohnDev(t, ownerIdler, scaledObjectGVR, "web-so")
+		require.NoError(t, err)
+		assert.Equal(t, "0", pausedScaledObject.GetAnnotations()["autoscaling.keda.sh/paused-replicas"])
+	})
+}
+
+func TestTargets(t *testing.T) {
+	// given
+	owner := &unstructured.Unstructured{Object: map[string]any{"apiVersion": "apps/v1", "kind": "Deployment"}}
+	owner.SetName("web")
+	newAutoscaler := func(ref map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{"spec": map[string]any{"scaleTargetRef": ref}}}
+	}
+
+	// when & then
+	assert.True(t, targets(newAutoscaler(map[string]any{"apiVersion": "apps/v1", "kind": "Deployment", "name": "web"}), owner, "", ""))
+	assert.True(t, targets(newAutoscaler(map[string]any{"apiVersion": "apps/v1beta1", "kind": "Deployment", "name": "web"}), owner, "", ""))
+	assert.True(t, targets(newAutoscaler(map[string]any{"name": "web"}), owner, "apps/v1", "Deployment"))
+	assert.False(t, targets(newAutoscaler(map[string]any{"kind": "Deployment", "name": "other"}), owner, "", ""))
+	assert.False(t, targets(newAutoscaler(map[string]any{"kind": "StatefulSet", "name": "web"}), owner, "", ""))
+	assert.False(t, targets(newAutoscaler(map[string]any{"apiVersion": "example.com/v1", "kind": "Deployment", "name": "web"}), owner, "", ""))
+	assert.False(t, targets(&unstructured.Unstructured{Object: map[string]any{}}, owner, "", ""))
+}
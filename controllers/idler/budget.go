@@ -0,0 +1,163 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// deploymentConfigLabelKey is the label set by OpenShift on the pods of a DeploymentConfig
+const deploymentConfigLabelKey = "openshift.io/deployment-config.name"
+
+// spaceWorkload is a workload (the controller of the pods, or a standalone pod) running in one of the namespaces of a space
+type spaceWorkload struct {
+	namespace string
+	kind      string
+	name      string
+	// startedAt is the start time of the oldest pod of the workload
+	startedAt time.Time
+	// podHours is the total run time of the pods of the workload in hours
+	podHours float64
+	pods     []string
+}
+
+// workloadsOverSpaceBudget returns the workloads running in the namespaces of the space the Idler belongs to which have to be idled
+// to keep the space within the configured budget (the maximum number of running workloads and the maximum number of pod-hours).
+// The oldest workloads are selected first. The given pods are the pods running in the namespace of the Idler, the pods of the other
+// namespaces are read from the cache. The workloads are identified by the controller owner references of the pods, so no owner is fetched.
+// If the space is within the pod-hours budget, then it returns also the time after which the budget is exceeded by the running pods.
+// Returns nil if no budget is configured or if the Idler doesn't belong to any space.
+func (r *Reconciler) workloadsOverSpaceBudget(ctx context.Context, idler *toolchainv1alpha1.Idler, pods []corev1.Pod) ([]spaceWorkload, time.Duration, error) {
+	maxWorkloads := r.config().SpaceMaxRunningWorkloads()
+	maxPodHours := r.config().SpaceMaxPodHours()
+	spaceName, found := idler.GetLabels()[toolchainv1alpha1.SpaceLabelKey]
+	if (maxWorkloads == 0 && maxPodHours == 0) || !found {
+		return nil, 0, nil
+	}
+	idlers := &toolchainv1alpha1.IdlerList{}
+	if err := r.Client.List(ctx, idlers, client.MatchingLabels{toolchainv1alpha1.SpaceLabelKey: spaceName}); err != nil {
+		return nil, 0, err
+	}
+	var workloads []*spaceWorkload
+	byKey := map[string]*spaceWorkload{}
+	for _, spaceIdler := range idlers.Items {
+		// the workloads in the namespaces which are not idled don't count
+		if spaceIdler.Spec.TimeoutSeconds <= 0 || spaceIdler.DeletionTimestamp != nil {
+			continue
+		}
+		namespacePods := pods
+		if spaceIdler.Name != idler.Name {
+			podList := &corev1.PodList{}
+			if err := r.AllNamespacesClient.List(ctx, podList, client.InNamespace(spaceIdler.Name)); err != nil {
+				return nil, 0, err
+			}
+			namespacePods = podList.Items
+		}
+		for index := range namespacePods {
+			pod := &namespacePods[index]
+			// the terminating pods don't count, they are already on their way out
+			if pod.Status.StartTime == nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed || pod.DeletionTimestamp != nil {
+				continue
+			}
+			kind, name := podWorkload(pod)
+			key := fmt.Sprintf("%s/%s/%s", pod.Namespace, kind, name)
+			workload, exists := byKey[key]
+			if !exists {
+				workload = &spaceWorkload{namespace: pod.Namespace, kind: kind, name: name, startedAt: pod.Status.StartTime.Time}
+				byKey[key] = workload
+				workloads = append(workloads, workload)
+			}
+			if pod.Status.StartTime.Time.Before(workload.startedAt) {
+				workload.startedAt = pod.Status.StartTime.Time
+			}
+			workload.podHours += time.Since(pod.Status.StartTime.Time).Hours()
+			workload.pods = append(workload.pods, pod.Name)
+		}
+	}
+
+	sort.SliceStable(workloads, func(i, j int) bool {
+		return workloads[i].startedAt.Before(workloads[j].startedAt)
+	})
+	var totalPodHours float64
+	runningPods := 0
+	for _, workload := range workloads {
+		totalPodHours += workload.podHours
+		runningPods += len(workload.pods)
+	}
+	var overBudget []spaceWorkload
+	running := len(workloads)
+	for _, workload := range workloads {
+		if (maxWorkloads == 0 || running <= maxWorkloads) && (maxPodHours == 0 || totalPodHours <= float64(maxPodHours)) {
+			break
+		}
+		overBudget = append(overBudget, *workload)
+		running--
+		totalPodHours -= workload.podHours
+	}
+	if len(overBudget) > 0 {
+		log.FromContext(ctx).Info("Space is over the idling budget", "space", spaceName, "running_workloads", len(workloads),
+			"max_running_workloads", maxWorkloads, "max_pod_hours", maxPodHours, "workloads_to_idle", len(overBudget))
+		return overBudget, 0, nil
+	}
+	if maxPodHours == 0 || runningPods == 0 {
+		return nil, 0, nil
+	}
+	// all running pods consume the remaining pod-hours at the same time
+	remaining := time.Duration((float64(maxPodHours) - totalPodHours) / float64(runningPods) * float64(time.Hour))
+	return nil, remaining + time.Second, nil
+}
+
+// podWorkload returns the kind and the name of the workload the pod belongs to, based on its controller owner reference.
+// The pods of all ReplicaSets (or ReplicationControllers) of a Deployment (or DeploymentConfig) belong to the Deployment,
+// so a rollout doesn't count as another workload.
+func podWorkload(pod *corev1.Pod) (string, string) {
+	controller := metav1.GetControllerOfNoCopy(pod)
+	if controller == nil {
+		return "Pod", pod.Name
+	}
+	switch controller.Kind {
+	case "ReplicaSet":
+		if hash := pod.GetLabels()[appsv1.DefaultDeploymentUniqueLabelKey]; hash != "" {
+			if name, found := strings.CutSuffix(controller.Name, "-"+hash); found {
+				return "Deployment", name
+			}
+		}
+	case "ReplicationController":
+		if name := pod.GetLabels()[deploymentConfigLabelKey]; name != "" {
+			return "DeploymentConfig", name
+		}
+	}
+	return controller.Kind, controller.Name
+}
+
+// podsOverSpaceBudget returns the names of the pods in the namespace of the Idler which have to be idled to keep the space within
+// the configured budget, and the time after which the budget should be checked again (or 0 if it's not needed).
+// The Idlers of the other namespaces of the space which have to idle their workloads are enqueued.
+// Errors are only logged so the pods are still idled based on their own timeout.
+func (r *Reconciler) podsOverSpaceBudget(ctx context.Context, idler *toolchainv1alpha1.Idler, pods []corev1.Pod) (map[string]bool, time.Duration) {
+	workloads, nextCheck, err := r.workloadsOverSpaceBudget(ctx, idler, pods)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to check the idling budget of the space")
+		return nil, 0
+	}
+	podNames := map[string]bool{}
+	for _, workload := range workloads {
+		if workload.namespace != idler.Name {
+			r.scheduleAfter(workload.namespace, idlerDeadline, 0)
+			continue
+		}
+		for _, pod := range workload.pods {
+			podNames[pod] = true
+		}
+	}
+	return podNames, nextCheck
+}
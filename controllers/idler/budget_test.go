@@ -0,0 +1,271 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSpaceBudget(t *testing.T) {
+	// given
+	newIdler := func(name string, timeoutSeconds int32) *toolchainv1alpha1.Idler {
+		return &toolchainv1alpha1.Idler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{toolchainv1alpha1.SpaceLabelKey: "alex"},
+			},
+			Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: timeoutSeconds},
+		}
+	}
+	devIdler := newIdler("alex-dev", 3600)
+	stageIdler := newIdler("alex-stage", 3600)
+	otherSpaceIdler := &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	deploymentGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	startedAgo := func(duration time.Duration) *metav1.Time {
+		return &metav1.Time{Time: time.Now().Add(-duration)}
+	}
+	// prepare creates three Deployments with three pods each: the oldest one in the dev namespace and two newer ones in the stage namespace,
+	// the pods of the Deployments run for 2.5, 2 and 0.5 pod-hours
+	prepare := func(t *testing.T, spec ConfigSpec) (*Reconciler, *memberoperatortest.FakeClientSet) {
+		reconciler, _, fakeClients := prepareReconcile(t, stageIdler.Name, getHostCluster, devIdler, stageIdler, otherSpaceIdler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(spec)
+		_, devReplicaSet := createDeployment(t, fakeClients, devIdler.Name, "old-", "", nil)
+		createPods(t, fakeClients.AllNamespacesClient, devReplicaSet, startedAgo(50*time.Minute), nil, noRestart())
+		_, midReplicaSet := createDeployment(t, fakeClients, stageIdler.Name, "mid-", "", nil)
+		createPods(t, fakeClients.AllNamespacesClient, midReplicaSet, startedAgo(40*time.Minute), nil, noRestart())
+		_, newReplicaSet := createDeployment(t, fakeClients, stageIdler.Name, "new-", "", nil)
+		createPods(t, fakeClients.AllNamespacesClient, newReplicaSet, startedAgo(10*time.Minute), nil, noRestart())
+		// the workloads of the other spaces don't count
+		_, otherReplicaSet := createDeployment(t, fakeClients, otherSpaceIdler.Name, "other-", "", nil)
+		createPods(t, fakeClients.AllNamespacesClient, otherReplicaSet, startedAgo(55*time.Minute), nil, noRestart())
+		return reconciler, fakeClients
+	}
+	reconcileIdler := func(t *testing.T, reconciler *Reconciler, name string) reconcile.Result {
+		res, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		require.NoError(t, err)
+		return res
+	}
+	replicas := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, namespace, name string) (int64, string) {
+		deployment, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		value, _, err := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+		require.NoError(t, err)
+		return value, deployment.GetAnnotations()[IdledReasonAnnotationKey]
+	}
+
+	t.Run("oldest workload is idled when there are too many running workloads", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxRunningWorkloads: ptr.To(2)})
+		reconciler.scheduler = newIdlingScheduler()
+
+		// when
+		reconcileIdler(t, reconciler, stageIdler.Name)
+
+		// then
+		for _, name := range []string{"mid-alex-stage", "new-alex-stage"} {
+			value, _ := replicas(t, fakeClients, stageIdler.Name, name)
+			assert.Equal(t, int64(3), value)
+		}
+		// the Idler of the namespace with the oldest workload is enqueued
+		assert.Contains(t, reconciler.scheduler.deadlines[devIdler.Name], idlerDeadline)
+
+		t.Run("Idler of the oldest workload idles it", func(t *testing.T) {
+			// when
+			reconcileIdler(t, reconciler, devIdler.Name)
+
+			// then
+			value, reason := replicas(t, fakeClients, devIdler.Name, "old-alex-dev")
+			assert.Zero(t, value)
+			assert.Equal(t, string(idleReasonSpaceBudget), reason)
+			value, _ = replicas(t, fakeClients, otherSpaceIdler.Name, "other-john-dev")
+			assert.Equal(t, int64(3), value)
+		})
+	})
+
+	t.Run("oldest workloads are idled when the space is over the pod-hours budget", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxPodHours: ptr.To(2)})
+
+		// when
+		reconcileIdler(t, reconciler, stageIdler.Name)
+		reconcileIdler(t, reconciler, devIdler.Name)
+
+		// then
+		value, _ := replicas(t, fakeClients, devIdler.Name, "old-alex-dev")
+		assert.Zero(t, value)
+		value, reason := replicas(t, fakeClients, stageIdler.Name, "mid-alex-stage")
+		assert.Zero(t, value)
+		assert.Equal(t, string(idleReasonSpaceBudget), reason)
+		value, _ = replicas(t, fakeClients, stageIdler.Name, "new-alex-stage")
+		assert.Equal(t, int64(3), value)
+	})
+
+	t.Run("budget is checked again when the running pods would exceed it", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxPodHours: ptr.To(6), SpaceMaxRunningWorkloads: ptr.To(3)})
+
+		// when
+		res := reconcileIdler(t, reconciler, stageIdler.Name)
+
+		// then
+		for _, name := range []string{"mid-alex-stage", "new-alex-stage"} {
+			value, _ := replicas(t, fakeClients, stageIdler.Name, name)
+			assert.Equal(t, int64(3), value)
+		}
+		// the remaining pod-hour is consumed by 9 pods in 6m40s
+		assert.InDelta(t, (6*time.Minute + 40*time.Second).Seconds(), res.RequeueAfter.Seconds(), 3)
+	})
+
+	t.Run("terminating pods are not counted", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxRunningWorkloads: ptr.To(2)})
+		reconciler.scheduler = newIdlingScheduler()
+		podList := &corev1.PodList{}
+		require.NoError(t, fakeClients.AllNamespacesClient.List(context.TODO(), podList, client.InNamespace(devIdler.Name)))
+		for _, pod := range podList.Items {
+			// the finalizer keeps the deleted pod around as terminating
+			pod.Finalizers = []string{"example.com/finalizer"}
+			require.NoError(t, fakeClients.AllNamespacesClient.Update(context.TODO(), &pod))
+			require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), &pod))
+		}
+
+		// when
+		reconcileIdler(t, reconciler, stageIdler.Name)
+
+		// then
+		for _, name := range []string{"mid-alex-stage", "new-alex-stage"} {
+			value, _ := replicas(t, fakeClients, stageIdler.Name, name)
+			assert.Equal(t, int64(3), value)
+		}
+		assert.NotContains(t, reconciler.scheduler.deadlines[devIdler.Name], idlerDeadline)
+	})
+
+	t.Run("namespaces without idling are not counted", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxRunningWorkloads: ptr.To(2)})
+		noIdling := &toolchainv1alpha1.Idler{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: devIdler.Name}, noIdling))
+		noIdling.Spec.TimeoutSeconds = 0
+		require.NoError(t, fakeClients.DefaultClient.Update(context.TODO(), noIdling))
+
+		// when
+		reconcileIdler(t, reconciler, stageIdler.Name)
+		reconcileIdler(t, reconciler, devIdler.Name)
+
+		// then
+		value, _ := replicas(t, fakeClients, devIdler.Name, "old-alex-dev")
+		assert.Equal(t, int64(3), value)
+		for _, name := range []string{"mid-alex-stage", "new-alex-stage"} {
+			value, _ := replicas(t, fakeClients, stageIdler.Name, name)
+			assert.Equal(t, int64(3), value)
+		}
+	})
+
+	t.Run("rollout of a Deployment counts as one workload and its owners are not fetched", func(t *testing.T) {
+		// given
+		reconciler, fakeClients := prepare(t, ConfigSpec{SpaceMaxRunningWorkloads: ptr.To(4)})
+		reconciler.scheduler = newIdlingScheduler()
+		// the ReplicaSets of the old and the new revision don't exist in the dynamic client, so they would fail to be fetched
+		for _, hash := range []string{"5d4f8c7b9", "7c9b6d5f8"} {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-" + hash,
+					Namespace: devIdler.Name,
+					Labels:    map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       "ReplicaSet",
+						Name:       "web-" + hash,
+						UID:        types.UID("web-" + hash),
+						Controller: ptr.To(true),
+					}},
+				},
+				Status: corev1.PodStatus{StartTime: startedAgo(5 * time.Minute)},
+			}
+			require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+		}
+
+		// when
+		reconcileIdler(t, reconciler, stageIdler.Name)
+
+		// then
+		for _, name := range []string{"mid-alex-stage", "new-alex-stage"} {
+			value, _ := replicas(t, fakeClients, stageIdler.Name, name)
+			assert.Equal(t, int64(3), value)
+		}
+		assert.NotContains(t, reconciler.scheduler.deadlines[devIdler.Name], idlerDeadline)
+	})
+}
+
+func TestPodWorkload(t *testing.T) {
+	// given
+	newPod := func(labels map[string]string, controllerKind, controllerName string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-pod", Namespace: "alex-dev", Labels: labels}}
+		if controllerKind != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: controllerKind, Name: controllerName, Controller: ptr.To(true)}}
+		}
+		return pod
+	}
+
+	t.Run("standalone pod", func(t *testing.T) {
+		// when
+		kind, name := podWorkload(newPod(nil, "", ""))
+
+		// then
+		assert.Equal(t, "Pod", kind)
+		assert.Equal(t, "web-pod", name)
+	})
+
+	t.Run("pod of a Deployment", func(t *testing.T) {
+		// when
+		kind, name := podWorkload(newPod(map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "5d4f8c7b9"}, "ReplicaSet", "web-5d4f8c7b9"))
+
+		// then
+		assert.Equal(t, "Deployment", kind)
+		assert.Equal(t, "web", name)
+	})
+
+	t.Run("pod of a DeploymentConfig", func(t *testing.T) {
+		// when
+		kind, name := podWorkload(newPod(map[string]string{deploymentConfigLabelKey: "web"}, "ReplicationController", "web-3"))
+
+		// then
+		assert.Equal(t, "DeploymentConfig", kind)
+		assert.Equal(t, "web", name)
+	})
+
+	t.Run("pod of a standalone ReplicaSet", func(t *testing.T) {
+		// when
+		kind, name := podWorkload(newPod(nil, "ReplicaSet", "web"))
+
+		// then
+		assert.Equal(t, "ReplicaSet", kind)
+		assert.Equal(t, "web", name)
+	})
+
+	t.Run("pod of a StatefulSet", func(t *testing.T) {
+		// when
+		kind, name := podWorkload(newPod(map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "5d4f8c7b9"}, "StatefulSet", "web"))
+
+		// then
+		assert.Equal(t, "StatefulSet", kind)
+		assert.Equal(t, "web", name)
+	})
+}
@@ -0,0 +1,333 @@
+package idler
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	commonconfig "github.com/codeready-toolchain/toolchain-common/pkg/configuration"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ConfigSpec contains the idler settings which are shared by all Idlers in the cluster and which are not part of the Idler spec.
+// The settings are set in the idler section of the MemberOperatorConfig (spec.idler).
+// All fields are optional, the Config getters return the default values for the fields that are not set.
+type ConfigSpec struct {
+	// ActivityBasedIdling enables idling based on the activity of the workloads (CPU usage reported by metrics.k8s.io)
+	// instead of the pure start time of the pods.
+	ActivityBasedIdling *bool `json:"activityBasedIdling,omitempty"`
+
+	// ActivityCPUThreshold is the CPU usage (eg. "10m") a pod needs to exceed to be considered as active.
+	ActivityCPUThreshold *string `json:"activityCPUThreshold,omitempty"`
+
+	// ActivityCheckPeriod is the maximum period (eg. "5m") between two checks of the activity of the workloads.
+	ActivityCheckPeriod *string `json:"activityCheckPeriod,omitempty"`
+
+	// PreIdleWarningPercentage is the percentage of the idler timeout (eg. 90) after which the users are warned
+	// that their workloads are about to be idled. The warning is disabled when not set (or set outside the range 1-99).
+	PreIdleWarningPercentage *int `json:"preIdleWarningPercentage,omitempty"`
+
+	// IdlingStrategies declares how to idle the owners of additional kinds (eg. new CRDs) or overrides the built-in strategies.
+	// Note that the operator needs to be granted the permissions to get and idle the resources of the additional kinds.
+	IdlingStrategies []IdlingStrategy `json:"idlingStrategies,omitempty"`
+
+	// DryRun enables the dry-run mode for all Idlers in the cluster. In the dry-run mode the idler only logs and counts
+	// the actions it would take, but it doesn't idle any workload nor does it send any notification.
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// RestartThreshold is the number of restarts of a container after which its pod is considered as crash-looping and is idled
+	// regardless of how long it has been running. Defaults to 50, set to 0 to disable the rule.
+	RestartThreshold *int `json:"restartThreshold,omitempty"`
+
+	// RestartThresholds contains the restart thresholds per kind of the pod owners, which override the RestartThreshold for the pods
+	// of the given kinds. The first owner of the pod (walking up from the pod) with a threshold configured is used, set to 0 to disable
+	// the rule for the kind. The entries override the default ones - the threshold of AnsibleAutomationPlatforms is one restart lower
+	// than the RestartThreshold by default, so their pods are idled by idling the platform before they reach the general threshold.
+	RestartThresholds map[string]int `json:"restartThresholds,omitempty"`
+
+	// CrashLoopKillLimit is the number of times in a row an owner can be idled because its pods were crash-looping, after which
+	// it's marked as crash-looping and kept scaled down when the namespace is un-idled. Defaults to 3, set to 0 to disable the rule.
+	CrashLoopKillLimit *int `json:"crashLoopKillLimit,omitempty"`
+
+	// RestartRateThreshold is the number of restarts of a container within the RestartRateWindow after which its pod
+	// is considered as crash-looping and is idled. The rule is disabled when not set (or set to 0).
+	RestartRateThreshold *int `json:"restartRateThreshold,omitempty"`
+
+	// RestartRateWindow is the time window (eg. "10m") used by the RestartRateThreshold rule.
+	RestartRateWindow *string `json:"restartRateWindow,omitempty"`
+
+	// PendingTimeout is the time (eg. "30m") after which the pods that never started (eg. unschedulable pods, or the pods failing
+	// to pull the image or to create the container) are idled, counted from the creation of the pod. Disabled when not set.
+	PendingTimeout *string `json:"pendingTimeout,omitempty"`
+
+	// TimeoutMultipliers contains the multipliers (eg. "0.5") of the idler timeout per kind of the pod owners, so the expensive
+	// workloads can be idled sooner (or the cheap ones later). The first owner of the pod (walking up from the pod) with a multiplier
+	// configured is used. The entries override the default ones - the timeout of VirtualMachineInstances is divided by 12 by default.
+	TimeoutMultipliers map[string]string `json:"timeoutMultipliers,omitempty"`
+
+	// EscalationPercentage is the percentage of the idler timeout (eg. 105) after which the next known owner of a pod is idled
+	// as well, in case the first owner failed to idle the workload. Values lower than 100 are ignored.
+	EscalationPercentage *int `json:"escalationPercentage,omitempty"`
+
+	// FightingThreshold is the number of times an idled owner is scaled up again within the FightingWindow after which the idler
+	// considers another controller (eg. GitOps auto-sync or a custom operator) to be fighting it and idles the next known owner as well.
+	// Defaults to 3, set to 0 to disable the detection.
+	FightingThreshold *int `json:"fightingThreshold,omitempty"`
+
+	// FightingWindow is the time window (eg. "24h") used by the FightingThreshold rule. Defaults to FightingThreshold times
+	// the timeout of the Idler (but at least 1 hour), since an owner scaled up again is idled again only after the timeout.
+	FightingWindow *string `json:"fightingWindow,omitempty"`
+
+	// SpaceMaxRunningWorkloads is the maximum number of workloads running concurrently across all namespaces of a space
+	// (the namespaces of the Idlers with the same toolchain.dev.openshift.com/space label). When the space goes over the budget,
+	// the oldest workloads are idled first. The budget is disabled when not set (or set to 0).
+	SpaceMaxRunningWorkloads *int `json:"spaceMaxRunningWorkloads,omitempty"`
+
+	// SpaceMaxPodHours is the maximum total run time of the pods (in pod-hours) running across all namespaces of a space.
+	// When the space goes over the budget, the oldest workloads are idled first. The budget is disabled when not set (or set to 0).
+	SpaceMaxPodHours *int `json:"spaceMaxPodHours,omitempty"`
+
+	// NotificationCooldown is the minimum time (eg. "24h") between two notifications about the idled workloads sent to the users
+	// of a namespace. All workloads idled in the same reconcile are listed in one notification.
+	NotificationCooldown *string `json:"notificationCooldown,omitempty"`
+
+	// IdlingSchedules contains the recurring windows in which all workloads in the namespaces are idled, keyed by the tier
+	// of the Idlers (the value of the toolchain.dev.openshift.com/tier label). The schedule set via the annotation
+	// on the Idler takes precedence.
+	IdlingSchedules map[string]IdlingSchedule `json:"idlingSchedules,omitempty"`
+
+	// WebhookSinks contains the HTTP endpoints the idler events (eg. the idled workloads) are posted to, in addition to the
+	// notifications sent to the users of the spaces via the host cluster.
+	WebhookSinks []WebhookSink `json:"webhookSinks,omitempty"`
+}
+
+// Config provides the idler settings with the defaults applied
+type Config struct {
+	spec ConfigSpec
+}
+
+// NewConfig returns a new Config for the given spec
+func NewConfig(spec ConfigSpec) Config {
+	return Config{spec: spec}
+}
+
+// cachedConfig contains the idler settings loaded from the MemberOperatorConfig
+var cachedConfig = struct {
+	sync.RWMutex
+	config Config
+}{}
+
+// LoadConfig caches the idler settings from the idler section of the given MemberOperatorConfig. The section is read from
+// the unstructured object since it's not part of the MemberOperatorConfig type of the API module.
+// The settings are reset to the defaults when the MemberOperatorConfig is nil or has no idler section.
+func LoadConfig(memberOperatorConfig *unstructured.Unstructured) error {
+	spec := ConfigSpec{}
+	if memberOperatorConfig != nil {
+		section, found, err := unstructured.NestedMap(memberOperatorConfig.Object, "spec", "idler")
+		if err != nil {
+			return fmt.Errorf("invalid idler section of the MemberOperatorConfig: %w", err)
+		}
+		if found {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(section, &spec); err != nil {
+				return fmt.Errorf("invalid idler section of the MemberOperatorConfig: %w", err)
+			}
+		}
+	}
+	cachedConfig.Lock()
+	defer cachedConfig.Unlock()
+	cachedConfig.config = NewConfig(spec)
+	return nil
+}
+
+// GetCachedConfig returns the idler settings loaded from the MemberOperatorConfig, or the defaults if they were not loaded yet
+func GetCachedConfig() Config {
+	cachedConfig.RLock()
+	defer cachedConfig.RUnlock()
+	return cachedConfig.config
+}
+
+// configOrDefault returns the settings provided by the given function, or the defaults if the function is not set
+func configOrDefault(getConfig func() Config) Config {
+	if getConfig == nil {
+		return Config{}
+	}
+	return getConfig()
+}
+
+// config returns the current idler settings
+func (r *Reconciler) config() Config {
+	return configOrDefault(r.GetConfig)
+}
+
+func (c Config) ActivityBasedIdling() bool {
+	return commonconfig.GetBool(c.spec.ActivityBasedIdling, false)
+}
+
+func (c Config) ActivityCPUThreshold() resource.Quantity {
+	defaultThreshold := "10m"
+	threshold, err := resource.ParseQuantity(commonconfig.GetString(c.spec.ActivityCPUThreshold, defaultThreshold))
+	if err != nil {
+		return resource.MustParse(defaultThreshold)
+	}
+	return threshold
+}
+
+func (c Config) ActivityCheckPeriod() time.Duration {
+	return commonconfig.GetDuration(c.spec.ActivityCheckPeriod, 5*time.Minute)
+}
+
+func (c Config) PreIdleWarningPercentage() int {
+	percentage := commonconfig.GetInt(c.spec.PreIdleWarningPercentage, 0)
+	if percentage < 1 || percentage > 99 {
+		return 0
+	}
+	return percentage
+}
+
+func (c Config) DryRun() bool {
+	return commonconfig.GetBool(c.spec.DryRun, false)
+}
+
+// RestartThreshold returns the maximum number of restarts of a container, or 0 if the rule is disabled
+func (c Config) RestartThreshold() int {
+	defaultThreshold := 50
+	threshold := commonconfig.GetInt(c.spec.RestartThreshold, defaultThreshold)
+	if threshold < 0 {
+		return defaultThreshold
+	}
+	return threshold
+}
+
+// RestartThresholds returns the valid restart thresholds per owner kind, including the default ones which are not overridden
+func (c Config) RestartThresholds() map[string]int {
+	thresholds := map[string]int{}
+	if threshold := c.RestartThreshold(); threshold > 1 {
+		// keep the AAP pod restart threshold lower than the general one, so the whole platform is idled before its pods are
+		thresholds["AnsibleAutomationPlatform"] = threshold - 1
+	}
+	for kind, threshold := range c.spec.RestartThresholds {
+		if threshold < 0 {
+			continue
+		}
+		thresholds[kind] = threshold
+	}
+	return thresholds
+}
+
+// lowestRestartThreshold returns the lowest restart threshold of all kinds, or 0 if all rules are disabled
+func (c Config) lowestRestartThreshold() int {
+	lowest := c.RestartThreshold()
+	for _, threshold := range c.RestartThresholds() {
+		if threshold > 0 && (lowest == 0 || threshold < lowest) {
+			lowest = threshold
+		}
+	}
+	return lowest
+}
+
+// CrashLoopKillLimit returns the number of times in a row an owner can be idled because of crash-looping pods before it's kept
+// scaled down, or 0 if the rule is disabled
+func (c Config) CrashLoopKillLimit() int {
+	defaultLimit := 3
+	limit := commonconfig.GetInt(c.spec.CrashLoopKillLimit, defaultLimit)
+	if limit < 0 {
+		return defaultLimit
+	}
+	return limit
+}
+
+// RestartRateThreshold returns the maximum number of restarts of a container within the RestartRateWindow, or 0 if the rule is disabled
+func (c Config) RestartRateThreshold() int {
+	return max(commonconfig.GetInt(c.spec.RestartRateThreshold, 0), 0)
+}
+
+func (c Config) RestartRateWindow() time.Duration {
+	return commonconfig.GetDuration(c.spec.RestartRateWindow, 10*time.Minute)
+}
+
+// PendingTimeout returns the time after which the pods that never started are idled, or 0 if the rule is disabled
+func (c Config) PendingTimeout() time.Duration {
+	return max(commonconfig.GetDuration(c.spec.PendingTimeout, 0), 0)
+}
+
+// TimeoutMultipliers returns the valid timeout multipliers per owner kind, including the default ones which are not overridden
+func (c Config) TimeoutMultipliers() map[string]float64 {
+	multipliers := map[string]float64{
+		// use 1/12th of the timeout for VMs to have more aggressive idling to decrease
+		// the infra costs because VMs consume much more resources
+		"VirtualMachineInstance": 1.0 / 12,
+	}
+	for kind, value := range c.spec.TimeoutMultipliers {
+		multiplier, err := strconv.ParseFloat(value, 64)
+		if err != nil || multiplier <= 0 {
+			continue
+		}
+		multipliers[kind] = multiplier
+	}
+	return multipliers
+}
+
+func (c Config) EscalationPercentage() int {
+	defaultPercentage := 105
+	percentage := commonconfig.GetInt(c.spec.EscalationPercentage, defaultPercentage)
+	if percentage < 100 {
+		return defaultPercentage
+	}
+	return percentage
+}
+
+// FightingThreshold returns the number of times an idled owner can be scaled up again within the FightingWindow,
+// or 0 if the detection is disabled
+func (c Config) FightingThreshold() int {
+	defaultThreshold := 3
+	threshold := commonconfig.GetInt(c.spec.FightingThreshold, defaultThreshold)
+	if threshold < 0 {
+		return defaultThreshold
+	}
+	return threshold
+}
+
+// FightingWindow returns the time window used by the FightingThreshold rule for the Idler with the given timeout
+func (c Config) FightingWindow(idlerTimeoutSeconds int32) time.Duration {
+	defaultWindow := max(time.Duration(c.FightingThreshold())*time.Duration(idlerTimeoutSeconds)*time.Second, time.Hour)
+	return commonconfig.GetDuration(c.spec.FightingWindow, defaultWindow)
+}
+
+// SpaceMaxRunningWorkloads returns the maximum number of running workloads per space, or 0 if the budget is disabled
+func (c Config) SpaceMaxRunningWorkloads() int {
+	return max(commonconfig.GetInt(c.spec.SpaceMaxRunningWorkloads, 0), 0)
+}
+
+// SpaceMaxPodHours returns the maximum number of pod-hours per space, or 0 if the budget is disabled
+func (c Config) SpaceMaxPodHours() int {
+	return max(commonconfig.GetInt(c.spec.SpaceMaxPodHours, 0), 0)
+}
+
+func (c Config) NotificationCooldown() time.Duration {
+	return commonconfig.GetDuration(c.spec.NotificationCooldown, 24*time.Hour)
+}
+
+// WebhookSinks returns the valid webhook sinks declared in the config, the invalid ones are ignored
+func (c Config) WebhookSinks() []WebhookSink {
+	var sinks []WebhookSink
+	for _, sink := range c.spec.WebhookSinks {
+		if sink.validate() == nil {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// IdlingStrategies returns the valid idling strategies declared in the config, the invalid ones are ignored
+func (c Config) IdlingStrategies() idlingStrategies {
+	var strategies []IdlingStrategy
+	for _, strategy := range c.spec.IdlingStrategies {
+		if strategy.validate() == nil {
+			strategies = append(strategies, strategy)
+		}
+	}
+	return newIdlingStrategies(strategies...)
+}
@@ -0,0 +1,235 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("default values", func(t *testing.T) {
+		// given
+		cfg := NewConfig(ConfigSpec{})
+
+		// then
+		assert.False(t, cfg.ActivityBasedIdling())
+		assert.True(t, resource.MustParse("10m").Equal(cfg.ActivityCPUThreshold()))
+		assert.Equal(t, 5*time.Minute, cfg.ActivityCheckPeriod())
+		assert.Zero(t, cfg.PreIdleWarningPercentage())
+		assert.Equal(t, 50, cfg.RestartThreshold())
+		assert.Equal(t, map[string]int{"AnsibleAutomationPlatform": 49}, cfg.RestartThresholds())
+		assert.Zero(t, cfg.RestartRateThreshold())
+		assert.Equal(t, 10*time.Minute, cfg.RestartRateWindow())
+		assert.Zero(t, cfg.PendingTimeout())
+		assert.Zero(t, cfg.SpaceMaxRunningWorkloads())
+		assert.Zero(t, cfg.SpaceMaxPodHours())
+		assert.Equal(t, 24*time.Hour, cfg.NotificationCooldown())
+		assert.Equal(t, map[string]float64{"VirtualMachineInstance": 1.0 / 12}, cfg.TimeoutMultipliers())
+		assert.Equal(t, 105, cfg.EscalationPercentage())
+		assert.Equal(t, 3, cfg.FightingThreshold())
+		assert.Equal(t, 3, cfg.CrashLoopKillLimit())
+		assert.Equal(t, time.Hour, cfg.FightingWindow(600))
+		assert.Equal(t, 24*time.Hour, cfg.FightingWindow(8*3600))
+	})
+
+	t.Run("custom values", func(t *testing.T) {
+		// given
+		cfg := NewConfig(ConfigSpec{
+			ActivityBasedIdling:      ptr.To(true),
+			ActivityCPUThreshold:     ptr.To("50m"),
+			ActivityCheckPeriod:      ptr.To("1m"),
+			PreIdleWarningPercentage: ptr.To(90),
+			RestartThreshold:         ptr.To(0),
+			RestartThresholds:        map[string]int{"AnsibleAutomationPlatform": 20, "StatefulSet": 10},
+			RestartRateThreshold:     ptr.To(5),
+			RestartRateWindow:        ptr.To("30m"),
+			PendingTimeout:           ptr.To("15m"),
+			SpaceMaxRunningWorkloads: ptr.To(10),
+			SpaceMaxPodHours:         ptr.To(100),
+			NotificationCooldown:     ptr.To("1h"),
+			TimeoutMultipliers:       map[string]string{"VirtualMachineInstance": "0.25", "InferenceService": "0.5"},
+			EscalationPercentage:     ptr.To(120),
+			FightingThreshold:        ptr.To(5),
+			CrashLoopKillLimit:       ptr.To(5),
+			FightingWindow:           ptr.To("2h"),
+		})
+
+		// then
+		assert.True(t, cfg.ActivityBasedIdling())
+		assert.True(t, resource.MustParse("50m").Equal(cfg.ActivityCPUThreshold()))
+		assert.Equal(t, time.Minute, cfg.ActivityCheckPeriod())
+		assert.Equal(t, 90, cfg.PreIdleWarningPercentage())
+		assert.Zero(t, cfg.RestartThreshold())
+		assert.Equal(t, map[string]int{"AnsibleAutomationPlatform": 20, "StatefulSet": 10}, cfg.RestartThresholds())
+		assert.Equal(t, 5, cfg.RestartRateThreshold())
+		assert.Equal(t, 30*time.Minute, cfg.RestartRateWindow())
+		assert.Equal(t, 15*time.Minute, cfg.PendingTimeout())
+		assert.Equal(t, 10, cfg.SpaceMaxRunningWorkloads())
+		assert.Equal(t, 100, cfg.SpaceMaxPodHours())
+		assert.Equal(t, time.Hour, cfg.NotificationCooldown())
+		assert.Equal(t, map[string]float64{"VirtualMachineInstance": 0.25, "InferenceService": 0.5}, cfg.TimeoutMultipliers())
+		assert.Equal(t, 120, cfg.EscalationPercentage())
+		assert.Equal(t, 5, cfg.FightingThreshold())
+		assert.Equal(t, 5, cfg.CrashLoopKillLimit())
+		assert.Equal(t, 2*time.Hour, cfg.FightingWindow(8*3600))
+	})
+
+	t.Run("invalid values fall back to defaults", func(t *testing.T) {
+		// given
+		cfg := NewConfig(ConfigSpec{
+			ActivityCPUThreshold:     ptr.To("a lot"),
+			ActivityCheckPeriod:      ptr.To("often"),
+			PreIdleWarningPercentage: ptr.To(100),
+			RestartThreshold:         ptr.To(-1),
+			RestartThresholds:        map[string]int{"StatefulSet": -1},
+			RestartRateThreshold:     ptr.To(-1),
+			RestartRateWindow:        ptr.To("a while"),
+			PendingTimeout:           ptr.To("-5m"),
+			SpaceMaxRunningWorkloads: ptr.To(-1),
+			SpaceMaxPodHours:         ptr.To(-1),
+			NotificationCooldown:     ptr.To("never"),
+			TimeoutMultipliers:       map[string]string{"VirtualMachineInstance": "-1", "InferenceService": "half"},
+			EscalationPercentage:     ptr.To(99),
+			FightingThreshold:        ptr.To(-1),
+			CrashLoopKillLimit:       ptr.To(-1),
+			FightingWindow:           ptr.To("a while"),
+		})
+
+		// then
+		assert.True(t, resource.MustParse("10m").Equal(cfg.ActivityCPUThreshold()))
+		assert.Equal(t, 5*time.Minute, cfg.ActivityCheckPeriod())
+		assert.Zero(t, cfg.PreIdleWarningPercentage())
+		assert.Equal(t, 50, cfg.RestartThreshold())
+		assert.Equal(t, map[string]int{"AnsibleAutomationPlatform": 49}, cfg.RestartThresholds())
+		assert.Zero(t, cfg.RestartRateThreshold())
+		assert.Equal(t, 10*time.Minute, cfg.RestartRateWindow())
+		assert.Zero(t, cfg.PendingTimeout())
+		assert.Zero(t, cfg.SpaceMaxRunningWorkloads())
+		assert.Zero(t, cfg.SpaceMaxPodHours())
+		assert.Equal(t, 24*time.Hour, cfg.NotificationCooldown())
+		assert.Equal(t, map[string]float64{"VirtualMachineInstance": 1.0 / 12}, cfg.TimeoutMultipliers())
+		assert.Equal(t, 105, cfg.EscalationPercentage())
+		assert.Equal(t, 3, cfg.FightingThreshold())
+		assert.Equal(t, 3, cfg.CrashLoopKillLimit())
+		assert.Equal(t, time.Hour, cfg.FightingWindow(600))
+		assert.Equal(t, 24*time.Hour, cfg.FightingWindow(8*3600))
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, LoadConfig(nil))
+	})
+
+	t.Run("idler section is loaded", func(t *testing.T) {
+		// given
+		memberOperatorConfig := newMemberOperatorConfig(map[string]any{
+			"dryRun":               true,
+			"restartThreshold":     int64(20),
+			"notificationCooldown": "1h",
+			"timeoutMultipliers":   map[string]any{"InferenceService": "0.5"},
+			"webhookSinks":         []any{map[string]any{"name": "collector", "url": "https://events.example.com/idler"}},
+		})
+
+		// when
+		err := LoadConfig(memberOperatorConfig)
+
+		// then
+		require.NoError(t, err)
+		cfg := GetCachedConfig()
+		assert.True(t, cfg.DryRun())
+		assert.Equal(t, 20, cfg.RestartThreshold())
+		assert.Equal(t, time.Hour, cfg.NotificationCooldown())
+		assert.InEpsilon(t, 0.5, cfg.TimeoutMultipliers()["InferenceService"], 0.001)
+		require.Len(t, cfg.WebhookSinks(), 1)
+		assert.Equal(t, "collector", cfg.WebhookSinks()[0].Name)
+	})
+
+	t.Run("defaults without the idler section", func(t *testing.T) {
+		// when
+		err := LoadConfig(newMemberOperatorConfig(nil))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, NewConfig(ConfigSpec{}), GetCachedConfig())
+	})
+
+	t.Run("invalid idler section", func(t *testing.T) {
+		// given
+		require.NoError(t, LoadConfig(newMemberOperatorConfig(map[string]any{"dryRun": true})))
+
+		// when
+		err := LoadConfig(newMemberOperatorConfig(map[string]any{"dryRun": "yes"}))
+
+		// then
+		require.ErrorContains(t, err, "invalid idler section of the MemberOperatorConfig")
+		assert.True(t, GetCachedConfig().DryRun(), "the previous settings should be kept")
+	})
+}
+
+func TestConfigChangeIsAppliedByReconcile(t *testing.T) {
+	// given
+	t.Cleanup(func() {
+		require.NoError(t, LoadConfig(nil))
+	})
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{Name: "alex-stage"},
+		Spec:       toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+	}
+	reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+	reconciler.GetConfig = GetCachedConfig
+	deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+	createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: expiredStartTimes(idler.Spec.TimeoutSeconds).defaultStartTime}, nil, noRestart())
+	require.NoError(t, LoadConfig(newMemberOperatorConfig(map[string]any{"dryRun": true})))
+
+	// when
+	_, err := reconciler.Reconcile(context.TODO(), req)
+
+	// then
+	require.NoError(t, err)
+	memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+		DeploymentScaledUp(deployment)
+
+	t.Run("workload is idled when the dry-run mode is disabled", func(t *testing.T) {
+		// given
+		require.NoError(t, LoadConfig(newMemberOperatorConfig(map[string]any{"dryRun": false})))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+}
+
+// configFor returns the function providing the idler settings of the given spec
+func configFor(spec ConfigSpec) func() Config {
+	return func() Config {
+		return NewConfig(spec)
+	}
+}
+
+// newMemberOperatorConfig returns the MemberOperatorConfig with the given idler section (if not nil)
+func newMemberOperatorConfig(idlerSection map[string]any) *unstructured.Unstructured {
+	spec := map[string]any{}
+	if idlerSection != nil {
+		spec["idler"] = idlerSection
+	}
+	memberOperatorConfig := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	memberOperatorConfig.SetGroupVersionKind(toolchainv1alpha1.GroupVersion.WithKind("MemberOperatorConfig"))
+	memberOperatorConfig.SetName("config")
+	memberOperatorConfig.SetNamespace(test.MemberOperatorNs)
+	return memberOperatorConfig
+}
@@ -0,0 +1,149 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// CrashLoopKillsAnnotationKey is set on the owners idled because their pods were crash-looping and contains
+	// the number of times in a row the owner was idled for this reason
+	CrashLoopKillsAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-crash-loop-kills"
+	// CrashLoopingAnnotationKey marks the owners which were idled too many times in a row because their pods were crash-looping.
+	// Such owners stay scaled down when the namespace is un-idled - the annotation has to be removed to restore them.
+	CrashLoopingAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-crash-looping"
+
+	notificationTypeIdlerCrashLoop = "idlercrashloop"
+	// crashLoopTemplate is the template of the notification with the diagnostic details of the crashing container
+	crashLoopTemplate = "idlercrashloop"
+)
+
+// crashLoopWorkload is a workload idled in the current reconcile because its pod was crash-looping
+type crashLoopWorkload struct {
+	kind   string
+	name   string
+	reason idleReason
+	pod    string
+	// container is the container of the pod with the highest number of restarts
+	container         string
+	restartCount      int32
+	terminationReason string
+	exitCode          int32
+}
+
+func isCrashLoopReason(reason idleReason) bool {
+	return reason == idleReasonRestartThreshold || reason == idleReasonRestartRate || reason == idleReasonKeptScaledDown
+}
+
+// keptScaledDownOwnerScaledUp returns true if any owner of the pod is marked to be kept scaled down because of its crash-looping pods,
+// but was scaled up again (eg. by the user or by another controller) while the marker is still set
+func (i *ownerIdler) keptScaledDownOwnerScaledUp(ctx context.Context, pod *corev1.Pod) bool {
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to find all owners, check the owners that are available")
+	}
+	for _, owner := range owners {
+		if _, keptScaledDown := owner.object.GetAnnotations()[CrashLoopingAnnotationKey]; keptScaledDown && !i.processed[ownerKey(owner)] && scaledUpAfterIdling(owner.object) {
+			return true
+		}
+	}
+	return false
+}
+
+// newCrashLoopWorkload returns the crash-looping workload with the diagnostic details of the container of the pod
+// which restarted the most: its last termination reason (eg. OOMKilled or Error) and exit code
+func newCrashLoopWorkload(kind, name string, reason idleReason, pod *corev1.Pod) crashLoopWorkload {
+	workload := crashLoopWorkload{kind: kind, name: name, reason: reason, pod: pod.Name}
+	var crashing *corev1.ContainerStatus
+	for index := range pod.Status.ContainerStatuses {
+		status := &pod.Status.ContainerStatuses[index]
+		if crashing == nil || status.RestartCount > crashing.RestartCount {
+			crashing = status
+		}
+	}
+	if crashing == nil {
+		return workload
+	}
+	workload.container = crashing.Name
+	workload.restartCount = crashing.RestartCount
+	terminated := crashing.LastTerminationState.Terminated
+	if terminated == nil {
+		terminated = crashing.State.Terminated
+	}
+	if terminated != nil {
+		workload.terminationReason = terminated.Reason
+		workload.exitCode = terminated.ExitCode
+	}
+	return workload
+}
+
+// recordCrashLoop adds the workload to the crash-looping workloads idled in this reconcile, unless it's already there
+func (i *ownerIdler) recordCrashLoop(workload crashLoopWorkload) {
+	for _, recorded := range i.crashLoops {
+		if recorded.kind == workload.kind && recorded.name == workload.name {
+			return
+		}
+	}
+	i.crashLoops = append(i.crashLoops, workload)
+}
+
+// crashLoopAnnotations returns the annotations to be set on the owner idled for the given reason: the number of times in a row
+// the owner was idled because its pods were crash-looping, and the marker keeping it scaled down once the configured limit is reached.
+// Both are removed when the owner is idled for any other reason, since it was running fine until then.
+func (i *ownerIdler) crashLoopAnnotations(ctx context.Context, owner *unstructured.Unstructured, reason idleReason) map[string]any {
+	if !isCrashLoopReason(reason) {
+		return map[string]any{
+			CrashLoopKillsAnnotationKey: nil,
+			CrashLoopingAnnotationKey:   nil,
+		}
+	}
+	kills, _ := strconv.Atoi(owner.GetAnnotations()[CrashLoopKillsAnnotationKey])
+	kills++
+	annotations := map[string]any{CrashLoopKillsAnnotationKey: strconv.Itoa(kills)}
+	if limit := i.config.CrashLoopKillLimit(); limit > 0 && kills >= limit {
+		log.FromContext(ctx).Info("Owner was idled too many times in a row because of crash-looping pods, it's kept scaled down", "kind", owner.GetKind(), "name", owner.GetName(), "kills", kills)
+		annotations[CrashLoopingAnnotationKey] = "true"
+		i.recordEvent(owner, corev1.EventTypeWarning, KeptScaledDownEventReason,
+			"%s %s was idled %d times in a row because of crash-looping pods, it's kept scaled down until the %s annotation is removed", owner.GetKind(), owner.GetName(), kills, CrashLoopingAnnotationKey)
+		if i.keptScaledDown == nil {
+			i.keptScaledDown = map[string]bool{}
+		}
+		i.keptScaledDown[fmt.Sprintf("%s/%s", owner.GetKind(), owner.GetName())] = true
+	}
+	return annotations
+}
+
+// notifyCrashLoops sends one notification per crash-looping workload idled in this reconcile with the details of the crashing container.
+// The name of the notification contains the name of the pod, so the notification is not sent again for the same pod.
+// Errors are only logged so the processing of the pods is not affected.
+func (r *Reconciler) notifyCrashLoops(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler) {
+	logger := log.FromContext(ctx)
+	for _, workload := range ownerIdler.crashLoops {
+		keptScaledDown := ownerIdler.keptScaledDown[fmt.Sprintf("%s/%s", workload.kind, workload.name)]
+		keysAndVals := map[string]string{
+			"Namespace":         idler.Name,
+			"AppName":           workload.name,
+			"AppType":           workload.kind,
+			"PodName":           workload.pod,
+			"Reason":            string(workload.reason),
+			"ContainerName":     workload.container,
+			"RestartCount":      strconv.Itoa(int(workload.restartCount)),
+			"TerminationReason": workload.terminationReason,
+			"ExitCode":          strconv.Itoa(int(workload.exitCode)),
+			"KeptScaledDown":    strconv.FormatBool(keptScaledDown),
+		}
+		logger.Info("Creating crash-loop Notification", "kind", workload.kind, "name", workload.name, "container", workload.container)
+		notificationName := fmt.Sprintf("%s-%s-%s", idler.Name, notificationTypeIdlerCrashLoop, workload.pod)
+		if _, err := r.sendNotification(ctx, idler, notificationName, notificationTypeIdlerCrashLoop, crashLoopTemplate, keysAndVals); err != nil {
+			logger.Error(err, "failed to create crash-loop Notification")
+			metrics.IdlerNotificationFailuresCounterVec.WithLabelValues(notificationTypeIdlerCrashLoop).Inc()
+		}
+	}
+}
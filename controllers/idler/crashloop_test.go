@@ -0,0 +1,256 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestNewCrashLoopWorkload(t *testing.T) {
+	for name, tc := range map[string]struct {
+		statuses          []corev1.ContainerStatus
+		expectedContainer string
+		expectedRestarts  int32
+		expectedReason    string
+		expectedExitCode  int32
+	}{
+		"last termination of the container restarting the most": {
+			statuses: []corev1.ContainerStatus{
+				{Name: "sidecar", RestartCount: 2, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}},
+				{Name: "app", RestartCount: 51, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}}},
+			},
+			expectedContainer: "app",
+			expectedRestarts:  51,
+			expectedReason:    "OOMKilled",
+			expectedExitCode:  137,
+		},
+		"current termination when the last one is not known": {
+			statuses: []corev1.ContainerStatus{
+				{Name: "app", RestartCount: 51, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 2}}},
+			},
+			expectedContainer: "app",
+			expectedRestarts:  51,
+			expectedReason:    "Error",
+			expectedExitCode:  2,
+		},
+		"no termination": {
+			statuses:          []corev1.ContainerStatus{{Name: "app", RestartCount: 51}},
+			expectedContainer: "app",
+			expectedRestarts:  51,
+		},
+		"no container status": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// given
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-pod"}, Status: corev1.PodStatus{ContainerStatuses: tc.statuses}}
+
+			// when
+			workload := newCrashLoopWorkload("Deployment", "web", idleReasonRestartThreshold, pod)
+
+			// then
+			assert.Equal(t, crashLoopWorkload{
+				kind:              "Deployment",
+				name:              "web",
+				reason:            idleReasonRestartThreshold,
+				pod:               "web-pod",
+				container:         tc.expectedContainer,
+				restartCount:      tc.expectedRestarts,
+				terminationReason: tc.expectedReason,
+				exitCode:          tc.expectedExitCode,
+			}, workload)
+		})
+	}
+}
+
+func TestCrashLoopingWorkloads(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	deploymentGVR := appsv1.SchemeGroupVersion.WithResource("deployments")
+	notifications := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, notificationType string) []toolchainv1alpha1.Notification {
+		list := &toolchainv1alpha1.NotificationList{}
+		require.NoError(t, fakeClients.DefaultClient.List(context.TODO(), list,
+			client.MatchingLabels{toolchainv1alpha1.NotificationTypeLabelKey: notificationType}))
+		return list.Items
+	}
+	// simulates the deployment scaled up again (eg. by the user) with a new pod which is crash-looping
+	crashAgain := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, deployment *appsv1.Deployment, replicaSet *appsv1.ReplicaSet, round int) *corev1.Pod {
+		_, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(idler.Name).
+			Patch(context.TODO(), deployment.Name, types.MergePatchType, []byte(`{"spec":{"replicas":3}}`), metav1.PatchOptions{})
+		require.NoError(t, err)
+		require.NoError(t, fakeClients.AllNamespacesClient.DeleteAllOf(context.TODO(), &corev1.Pod{}, client.InNamespace(idler.Name)))
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-pod-%d", deployment.Name, round), Namespace: idler.Name},
+			Status: corev1.PodStatus{
+				StartTime: &metav1.Time{Time: time.Now()},
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "app",
+					RestartCount:         51,
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}},
+			},
+		}
+		require.NoError(t, controllerutil.SetControllerReference(replicaSet, pod, scheme.Scheme))
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+		return pod
+	}
+
+	t.Run("crash-loop notification with the details of the container", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pod := crashAgain(t, fakeClients, deployment, replicaSet, 0)
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		annotations := getDeployment(t, fakeClients, deployment).GetAnnotations()
+		assert.Equal(t, "1", annotations[CrashLoopKillsAnnotationKey])
+		assert.NotContains(t, annotations, CrashLoopingAnnotationKey)
+		assert.Empty(t, notifications(t, fakeClients, toolchainv1alpha1.NotificationTypeIdled))
+		crashLoops := notifications(t, fakeClients, notificationTypeIdlerCrashLoop)
+		require.Len(t, crashLoops, 1)
+		assert.Equal(t, fmt.Sprintf("alex-stage-idlercrashloop-%s", pod.Name), crashLoops[0].Name)
+		assert.Equal(t, crashLoopTemplate, crashLoops[0].Spec.Template)
+		assert.Equal(t, map[string]string{
+			"Namespace":         idler.Name,
+			"AppName":           deployment.Name,
+			"AppType":           "Deployment",
+			"PodName":           pod.Name,
+			"Reason":            string(idleReasonRestartThreshold),
+			"ContainerName":     "app",
+			"RestartCount":      "51",
+			"TerminationReason": "OOMKilled",
+			"ExitCode":          "137",
+			"KeptScaledDown":    "false",
+		}, crashLoops[0].Spec.Context)
+
+		t.Run("no other notification for the same pod", func(t *testing.T) {
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			assert.Len(t, notifications(t, fakeClients, notificationTypeIdlerCrashLoop), 1)
+			assert.Equal(t, "1", getDeployment(t, fakeClients, deployment).GetAnnotations()[CrashLoopKillsAnnotationKey])
+		})
+	})
+
+	t.Run("owner crash-killed repeatedly is kept scaled down", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{CrashLoopKillLimit: ptr.To(2), FightingThreshold: ptr.To(0)})
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		crashAgain(t, fakeClients, deployment, replicaSet, 0)
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		pod := crashAgain(t, fakeClients, deployment, replicaSet, 1)
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		annotations := getDeployment(t, fakeClients, deployment).GetAnnotations()
+		assert.Equal(t, "2", annotations[CrashLoopKillsAnnotationKey])
+		assert.Equal(t, "true", annotations[CrashLoopingAnnotationKey])
+		notification := &toolchainv1alpha1.Notification{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Namespace: test.HostOperatorNs, Name: fmt.Sprintf("alex-stage-idlercrashloop-%s", pod.Name)}, notification))
+		assert.Equal(t, "true", notification.Spec.Context["KeptScaledDown"])
+		assert.Contains(t, recordedEvents(recorder), fmt.Sprintf("Warning KeptScaledDown Deployment %s was idled 2 times in a row because of crash-looping pods, it's kept scaled down until the %s annotation is removed", deployment.Name, CrashLoopingAnnotationKey))
+
+		t.Run("owner is not restored when un-idled", func(t *testing.T) {
+			// given
+			require.NoError(t, fakeClients.AllNamespacesClient.DeleteAllOf(context.TODO(), &corev1.Pod{}, client.InNamespace(idler.Name)))
+			requestUnidle(t, fakeClients.DefaultClient, types.NamespacedName{Name: idler.Name}, &toolchainv1alpha1.Idler{})
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledDown(deployment)
+			assert.Contains(t, getDeployment(t, fakeClients, deployment).GetAnnotations(), CrashLoopingAnnotationKey)
+		})
+
+		t.Run("owner scaled up again is scaled down without another notification", func(t *testing.T) {
+			// given
+			_, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(idler.Name).
+				Patch(context.TODO(), deployment.Name, types.MergePatchType, []byte(`{"spec":{"replicas":3}}`), metav1.PatchOptions{})
+			require.NoError(t, err)
+			require.NoError(t, fakeClients.AllNamespacesClient.DeleteAllOf(context.TODO(), &corev1.Pod{}, client.InNamespace(idler.Name)))
+			createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: time.Now()}, nil, noRestart())
+			crashLoops := len(notifications(t, fakeClients, notificationTypeIdlerCrashLoop))
+
+			// when
+			_, err = reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledDown(deployment)
+			annotations := getDeployment(t, fakeClients, deployment).GetAnnotations()
+			assert.Equal(t, "true", annotations[CrashLoopingAnnotationKey])
+			assert.Equal(t, "3", annotations[IdledReplicasAnnotationKey])
+			assert.Len(t, notifications(t, fakeClients, notificationTypeIdlerCrashLoop), crashLoops)
+			assert.Empty(t, notifications(t, fakeClients, toolchainv1alpha1.NotificationTypeIdled))
+		})
+	})
+
+	t.Run("crash-loop marker is removed when the owner is idled for another reason", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		_, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(idler.Name).
+			Patch(context.TODO(), deployment.Name, types.MergePatchType,
+				[]byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"3","%s":"true"}}}`, CrashLoopKillsAnnotationKey, CrashLoopingAnnotationKey)), metav1.PatchOptions{})
+		require.NoError(t, err)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: expiredStartTimes(idler.Spec.TimeoutSeconds).defaultStartTime}, nil, noRestart())
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		annotations := getDeployment(t, fakeClients, deployment).GetAnnotations()
+		assert.Equal(t, string(idleReasonTimeout), annotations[IdledReasonAnnotationKey])
+		assert.NotContains(t, annotations, CrashLoopKillsAnnotationKey)
+		assert.NotContains(t, annotations, CrashLoopingAnnotationKey)
+		assert.Empty(t, notifications(t, fakeClients, notificationTypeIdlerCrashLoop))
+		assert.Len(t, notifications(t, fakeClients, toolchainv1alpha1.NotificationTypeIdled), 1)
+	})
+}
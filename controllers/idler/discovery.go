@@ -0,0 +1,99 @@
+package idler
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	// discoveryRefreshPeriod is the maximum age of the cached API resources
+	discoveryRefreshPeriod = 10 * time.Minute
+	// discoveryMinRefreshInterval limits how often the API resources can be refreshed when a kind can't be found,
+	// so the API server isn't flooded with the discovery requests when there are many owners of an unknown kind
+	discoveryMinRefreshInterval = 30 * time.Second
+)
+
+// discoveryCache keeps the API resources available in the cluster, so they don't have to be discovered in every reconcile.
+// The resources are refreshed periodically, or on demand when a kind can't be found. The zero value is ready to use.
+type discoveryCache struct {
+	mu            sync.Mutex
+	resourceLists []*metav1.APIResourceList
+	fetchedAt     time.Time
+	// subresources contains the names of the subresources per group version. The preferred resources don't contain
+	// the subresources, so they are discovered on demand for every group version and kept until the resources are refreshed,
+	// or until the refresh period elapses. The group versions which can't be discovered are kept as well.
+	subresources map[string]discoveredSubresources
+}
+
+type discoveredSubresources struct {
+	names     map[string]bool
+	err       error
+	fetchedAt time.Time
+}
+
+// get returns the cached API resources, or discovers them using the given client if they are not cached yet or if they are too old
+func (c *discoveryCache) get(discoveryClient discovery.ServerResourcesInterface) ([]*metav1.APIResourceList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceLists != nil && time.Since(c.fetchedAt) < discoveryRefreshPeriod {
+		return c.resourceLists, nil
+	}
+	return c.fetch(discoveryClient)
+}
+
+// refresh discovers the API resources again using the given client, unless they were discovered very recently
+func (c *discoveryCache) refresh(discoveryClient discovery.ServerResourcesInterface) ([]*metav1.APIResourceList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceLists != nil && time.Since(c.fetchedAt) < discoveryMinRefreshInterval {
+		return c.resourceLists, nil
+	}
+	return c.fetch(discoveryClient)
+}
+
+func (c *discoveryCache) fetch(discoveryClient discovery.ServerResourcesInterface) ([]*metav1.APIResourceList, error) {
+	resourceLists, err := discoveryClient.ServerPreferredResources()
+	if err != nil {
+		return nil, err
+	}
+	c.resourceLists = resourceLists
+	c.fetchedAt = time.Now()
+	c.subresources = nil
+	return resourceLists, nil
+}
+
+// hasSubresource returns true if the given resource has the given subresource (eg. "scale").
+// The resources of the group version are discovered using the given client if they are not cached yet.
+func (c *discoveryCache) hasSubresource(discoveryClient discovery.ServerResourcesInterface, gvr schema.GroupVersionResource, subresource string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	groupVersion := gvr.GroupVersion().String()
+	discovered, found := c.subresources[groupVersion]
+	if !found || time.Since(discovered.fetchedAt) >= discoveryRefreshPeriod {
+		discovered = discoveredSubresources{names: map[string]bool{}, fetchedAt: time.Now()}
+		resourceList, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			// the failure is cached as well, so the group version is not discovered again for every owner
+			discovered.err = err
+		} else {
+			for _, apiResource := range resourceList.APIResources {
+				if strings.Contains(apiResource.Name, "/") {
+					discovered.names[apiResource.Name] = true
+				}
+			}
+		}
+		if c.subresources == nil {
+			c.subresources = map[string]discoveredSubresources{}
+		}
+		c.subresources[groupVersion] = discovered
+	}
+	if discovered.err != nil {
+		return false, discovered.err
+	}
+	return discovered.names[gvr.Resource+"/"+subresource], nil
+}
@@ -0,0 +1,249 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Run("resources are cached", func(t *testing.T) {
+		// given
+		cache := &discoveryCache{}
+		fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
+
+		// when
+		_, err := cache.get(fakeDiscovery)
+		require.NoError(t, err)
+		resourceLists, err := cache.get(fakeDiscovery)
+
+		// then
+		require.NoError(t, err)
+		assert.NotEmpty(t, resourceLists)
+		assert.Equal(t, 1, fakeDiscovery.serverPreferredResourcesCalls)
+	})
+
+	t.Run("outdated resources are discovered again", func(t *testing.T) {
+		// given
+		cache := &discoveryCache{}
+		fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
+		_, err := cache.get(fakeDiscovery)
+		require.NoError(t, err)
+		cache.fetchedAt = time.Now().Add(-discoveryRefreshPeriod)
+
+		// when
+		_, err = cache.get(fakeDiscovery)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 2, fakeDiscovery.serverPreferredResourcesCalls)
+	})
+
+	t.Run("refresh is limited", func(t *testing.T) {
+		// given
+		cache := &discoveryCache{}
+		fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
+		_, err := cache.get(fakeDiscovery)
+		require.NoError(t, err)
+
+		// when
+		_, err = cache.refresh(fakeDiscovery)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, fakeDiscovery.serverPreferredResourcesCalls)
+
+		t.Run("refreshed after the minimal interval", func(t *testing.T) {
+			// given
+			cache.fetchedAt = time.Now().Add(-discoveryMinRefreshInterval)
+
+			// when
+			_, err = cache.refresh(fakeDiscovery)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, 2, fakeDiscovery.serverPreferredResourcesCalls)
+		})
+	})
+
+	t.Run("error is not cached", func(t *testing.T) {
+		// given
+		cache := &discoveryCache{}
+		fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
+		fakeDiscovery.ServerPreferredResourcesError = fmt.Errorf("some error")
+		_, err := cache.get(fakeDiscovery)
+		require.EqualError(t, err, "some error")
+		fakeDiscovery.ServerPreferredResourcesError = nil
+
+		// when
+		resourceLists, err := cache.get(fakeDiscovery)
+
+		// then
+		require.NoError(t, err)
+		assert.NotEmpty(t, resourceLists)
+	})
+
+	t.Run("subresources are discovered per group version", func(t *testing.T) {
+		// given
+		cache := &discoveryCache{}
+		runnersGVR := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "runners"}
+		fakeDiscovery := newFakeDiscoveryClient(&metav1.APIResourceList{
+			GroupVersion: runnersGVR.GroupVersion().String(),
+			APIResources: []metav1.APIResource{
+				{Name: "runners", Namespaced: true, Kind: "Runner"},
+				{Name: "runners/scale", Namespaced: true, Kind: "Scale", Group: "autoscaling", Version: "v1"},
+				{Name: "builders", Namespaced: true, Kind: "Builder"},
+			},
+		})
+		resourceLists, err := cache.get(fakeDiscovery)
+		require.NoError(t, err)
+		require.Len(t, resourceLists, 1)
+		// the preferred resources don't contain the subresources
+		assert.Len(t, resourceLists[0].APIResources, 2)
+
+		// when
+		runnerHasScale, err := cache.hasSubresource(fakeDiscovery, runnersGVR, "scale")
+		require.NoError(t, err)
+		builderHasScale, err := cache.hasSubresource(fakeDiscovery, runnersGVR.GroupVersion().WithResource("builders"), "scale")
+		require.NoError(t, err)
+
+		// then
+		assert.True(t, runnerHasScale)
+		assert.False(t, builderHasScale)
+		assert.Contains(t, cache.subresources, "example.com/v1")
+
+		t.Run("failure is cached when the group version can't be discovered", func(t *testing.T) {
+			// given
+			unknownGVR := schema.GroupVersionResource{Group: "unknown.com", Version: "v1", Resource: "runners"}
+			_, err := cache.hasSubresource(fakeDiscovery, unknownGVR, "scale")
+			require.Error(t, err)
+			fakeDiscovery.Resources = append(fakeDiscovery.Resources, &metav1.APIResourceList{
+				GroupVersion: unknownGVR.GroupVersion().String(),
+				APIResources: []metav1.APIResource{
+					{Name: "runners", Namespaced: true, Kind: "Runner"},
+					{Name: "runners/scale", Namespaced: true, Kind: "Scale", Group: "autoscaling", Version: "v1"},
+				},
+			})
+
+			// when
+			hasScale, err := cache.hasSubresource(fakeDiscovery, unknownGVR, "scale")
+
+			// then
+			require.Error(t, err)
+			assert.False(t, hasScale)
+			assert.Contains(t, cache.subresources, "unknown.com/v1")
+
+			t.Run("discovered again when the refresh period elapses", func(t *testing.T) {
+				// given
+				discovered := cache.subresources["unknown.com/v1"]
+				discovered.fetchedAt = time.Now().Add(-discoveryRefreshPeriod)
+				cache.subresources["unknown.com/v1"] = discovered
+
+				// when
+				hasScale, err := cache.hasSubresource(fakeDiscovery, unknownGVR, "scale")
+
+				// then
+				require.NoError(t, err)
+				assert.True(t, hasScale)
+			})
+		})
+
+		t.Run("dropped when the resources are refreshed", func(t *testing.T) {
+			// given
+			cache.fetchedAt = time.Now().Add(-discoveryMinRefreshInterval)
+
+			// when
+			_, err := cache.refresh(fakeDiscovery)
+
+			// then
+			require.NoError(t, err)
+			assert.Empty(t, cache.subresources)
+		})
+	})
+}
+
+func TestOwnerFetcherCaching(t *testing.T) {
+	t.Run("unknown kind refreshes the discovered resources", func(t *testing.T) {
+		// given
+		gvr := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "runners"}
+		runner := &unstructured.Unstructured{}
+		runner.SetGroupVersionKind(gvr.GroupVersion().WithKind("Runner"))
+		runner.SetName("runner")
+		runner.SetNamespace("john-dev")
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "john-dev", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "example.com/v1", Kind: "Runner", Name: "runner", Controller: ptr.To(true)},
+		}}}
+		dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, customListKinds, runner)
+		fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
+		fetcher := newOwnerFetcher(fakeDiscovery, dynamicClient)
+		require.NoError(t, fetcher.loadResourceLists())
+		// the CRD is installed after the resources were discovered
+		fakeDiscovery.Resources = append(allResourcesList(t), &metav1.APIResourceList{
+			GroupVersion: gvr.GroupVersion().String(),
+			APIResources: []metav1.APIResource{{Name: "runners", Namespaced: true, Kind: "Runner"}},
+		})
+		fetcher.discoveryCache.fetchedAt = time.Now().Add(-discoveryMinRefreshInterval)
+
+		// when
+		owners, err := fetcher.getOwners(context.TODO(), pod)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, owners, 1)
+		assert.Equal(t, "runner", owners[0].object.GetName())
+		assert.Equal(t, 2, fakeDiscovery.serverPreferredResourcesCalls)
+	})
+
+	t.Run("owners are fetched only once", func(t *testing.T) {
+		// given
+		idler := &toolchainv1alpha1.Idler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "alex-stage",
+				Labels: map[string]string{toolchainv1alpha1.SpaceLabelKey: "alex"},
+			},
+			Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+		}
+		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, newMUR("alex"))
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		expired := &metav1.Time{Time: time.Now().Add(-61 * time.Second)}
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expired, nil, noRestart())
+		fakeDiscovery := reconciler.DiscoveryClient.(*fakeDiscoveryClient)
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		// one GET of the ReplicaSet and of the Deployment per reconcile for all three pods
+		assert.Equal(t, 2, countGetActions(fakeClients.DynamicClient, "replicasets"))
+		assert.Equal(t, 2, countGetActions(fakeClients.DynamicClient, "deployments"))
+		// the resources are discovered only once for both reconciles
+		assert.Equal(t, 1, fakeDiscovery.serverPreferredResourcesCalls)
+	})
+}
+
+func countGetActions(dynamicClient *fakedynamic.FakeDynamicClient, resource string) int {
+	count := 0
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
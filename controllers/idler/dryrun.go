@@ -0,0 +1,86 @@
+package idler
+
+import (
+	"context"
+	"sync"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DryRunAnnotationKey can be set to "true" on the Idler to only report the actions the idler would take without taking them.
+	// The un-idling explicitly requested by the users is not affected.
+	DryRunAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idler-dry-run"
+
+	dryRunActionDeletePod = "DeletePod"
+)
+
+// isDryRun returns true if the idler should only report the actions instead of taking them
+func (r *Reconciler) isDryRun(idler *toolchainv1alpha1.Idler) bool {
+	return r.config().DryRun() || idler.GetAnnotations()[DryRunAnnotationKey] == "true"
+}
+
+// dryRunTracker keeps the actions reported in the dry-run mode together with the UIDs of the pods they were reported for,
+// so every action is reported only once for as long as the pod exists, rather than every time the pod is processed.
+// The zero value is ready to use.
+type dryRunTracker struct {
+	mu      sync.Mutex
+	actions map[dryRunActionKey]types.UID
+}
+
+type dryRunActionKey struct {
+	namespace string
+	kind      string
+	name      string
+	action    string
+}
+
+// firstReport records the action for the given pod and returns true if the action was not reported yet
+// for any of the existing pods
+func (t *dryRunTracker) firstReport(key dryRunActionKey, pod types.UID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, reported := t.actions[key]; reported {
+		return false
+	}
+	if t.actions == nil {
+		t.actions = map[dryRunActionKey]types.UID{}
+	}
+	t.actions[key] = pod
+	return true
+}
+
+// prune removes the actions reported for the pods which don't exist in the given namespace anymore
+func (t *dryRunTracker) prune(namespace string, existing map[types.UID]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, pod := range t.actions {
+		if key.namespace == namespace && !existing[pod] {
+			delete(t.actions, key)
+		}
+	}
+}
+
+// reportDryRunAction logs and counts the action the idler would have taken for the given pod when not running in the dry-run mode.
+// Every action is counted only once for as long as the pod exists. The name of the owner is only logged to keep the cardinality of the metric low.
+func (i *ownerIdler) reportDryRunAction(ctx context.Context, pod *corev1.Pod, ownerKind, ownerName, action string, reason idleReason) {
+	key := dryRunActionKey{namespace: pod.Namespace, kind: ownerKind, name: ownerName, action: action}
+	if i.dryRunActions != nil && !i.dryRunActions.firstReport(key, pod.UID) {
+		return
+	}
+	log.FromContext(ctx).Info("Dry run: the idler would idle the workload", "kind", ownerKind, "name", ownerName, "action", action, "reason", reason)
+	metrics.IdlerDryRunActionsCounterVec.WithLabelValues(ownerKind, action, string(reason)).Inc()
+}
+
+// pruneDryRunActions removes the dry-run actions reported for the pods which don't exist in the namespace anymore
+func (r *Reconciler) pruneDryRunActions(namespace string, pods []corev1.Pod) {
+	existing := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		existing[pod.UID] = true
+	}
+	r.dryRunActions.prune(namespace, existing)
+}
@@ -0,0 +1,142 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestDryRun(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: TestIdlerTimeOutSeconds},
+	}
+	dryRunIdler := idler.DeepCopy()
+	dryRunIdler.Annotations = map[string]string{DryRunAnnotationKey: "true"}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	expiredStartTime := &metav1.Time{Time: time.Now().Add(-time.Duration(TestIdlerTimeOutSeconds+1) * time.Second)}
+
+	t.Run("owner is not idled when dry-run is enabled on the Idler", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, dryRunIdler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{PreIdleWarningPercentage: ptr.To(90)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment).
+			PodsExist(pods)
+		assert.NotContains(t, getDeployment(t, fakeClients, deployment).GetAnnotations(), IdledAtAnnotationKey)
+		assertNoNotifications(t, fakeClients)
+		// reported only once even though there are three pods
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerDryRunActionsCounterVec.WithLabelValues("Deployment", string(PatchStrategy), string(idleReasonTimeout))), 0.01)
+	})
+
+	t.Run("action is reported only once while the pod exists", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, dryRunIdler, nsTmplSet, mur)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: idler.Name, UID: "standalone-uid"},
+			Status:     corev1.PodStatus{StartTime: expiredStartTime},
+		}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+		counter := metrics.IdlerDryRunActionsCounterVec.WithLabelValues("Pod", dryRunActionDeletePod, string(idleReasonTimeout))
+
+		// when
+		for range 3 {
+			_, err := reconciler.Reconcile(context.TODO(), req)
+			require.NoError(t, err)
+		}
+
+		// then
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(counter), 0.01)
+
+		t.Run("action is reported again for the new pod", func(t *testing.T) {
+			// given
+			require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), pod))
+			_, err := reconciler.Reconcile(context.TODO(), req)
+			require.NoError(t, err)
+			newPod := pod.DeepCopy()
+			newPod.ResourceVersion = ""
+			newPod.UID = "new-standalone-uid"
+			require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), newPod))
+
+			// when
+			_, err = reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			assert.InDelta(t, float64(2), promtestutil.ToFloat64(counter), 0.01)
+		})
+	})
+
+	t.Run("standalone pod is not deleted when dry-run is enabled in the config", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{DryRun: ptr.To(true)})
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: idler.Name},
+			Status:     corev1.PodStatus{StartTime: expiredStartTime, ContainerStatuses: restartingOverThreshold()},
+		}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			PodsExist([]*corev1.Pod{pod})
+		assertNoNotifications(t, fakeClients)
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerDryRunActionsCounterVec.WithLabelValues("Pod", dryRunActionDeletePod, string(idleReasonRestartThreshold))), 0.01)
+	})
+
+	t.Run("owner is idled when dry-run is not enabled", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		assert.Zero(t, promtestutil.CollectAndCount(metrics.IdlerDryRunActionsCounterVec))
+	})
+}
+
+func assertNoNotifications(t *testing.T, fakeClients *memberoperatortest.FakeClientSet) {
+	notifications := &toolchainv1alpha1.NotificationList{}
+	require.NoError(t, fakeClients.DefaultClient.List(context.TODO(), notifications))
+	assert.Empty(t, notifications.Items)
+}
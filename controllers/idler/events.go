@@ -0,0 +1,23 @@
+package idler
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// IdledEventReason is the reason of the Event recorded on the idled owners and the deleted standalone pods
+	IdledEventReason = "Idled"
+	// UnidledEventReason is the reason of the Event recorded on the owners restored when the namespace was un-idled
+	UnidledEventReason = "Unidled"
+	// KeptScaledDownEventReason is the reason of the Event recorded on the owners kept scaled down because of their crash-looping pods
+	KeptScaledDownEventReason = "KeptScaledDown"
+)
+
+// recordEvent records the Event on the given object, so the users can see what the idler did to their workloads.
+// Nothing is recorded when the Reconciler has no event recorder.
+func (i *ownerIdler) recordEvent(object runtime.Object, eventType, reason, messageFmt string, args ...any) {
+	if i.recorder == nil {
+		return
+	}
+	i.recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,266 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	"github.com/codeready-toolchain/toolchain-common/pkg/condition"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// IdlerControllerFightingDetected is used to track the owners which are scaled up again by another controller right after being idled
+	IdlerControllerFightingDetected toolchainv1alpha1.ConditionType = "IdlerControllerFightingDetected"
+
+	IdlerControllerFightingDetectedReason                   = "ControllerFightingDetected"
+	IdlerNoControllerFightingDetectedReason                 = "NoControllerFightingDetected"
+	IdlerControllerFightingNotificationCreationFailedReason = "UnableToCreateControllerFightingNotification"
+
+	notificationTypeIdlerControllerFighting = "idlercontrollerfighting"
+)
+
+// idleActionTracker keeps the scale-ups of the owners idled by the idler, so the owners which are scaled up again by another
+// controller (eg. GitOps auto-sync, custom operators or unknown autoscalers) right after being idled can be detected.
+// The owners are identified by their UID. The zero value is ready to use.
+type idleActionTracker struct {
+	mu     sync.Mutex
+	owners map[types.UID]*ownerIdleActions
+}
+
+type ownerIdleActions struct {
+	namespace string
+	// scaleUps contains the times when the owner was found scaled up again after being idled, by the idling they followed
+	// (the value of the IdledAtAnnotationKey), so every idling is followed by one scale-up at most
+	scaleUps map[string]time.Time
+}
+
+// recordScaleUp records that the owner idled at the given time (the value of the IdledAtAnnotationKey) was found scaled up again
+// and returns the number of times the owner was scaled up again within the given window
+func (t *idleActionTracker) recordScaleUp(namespace string, owner types.UID, idledAt string, window time.Duration, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.owners == nil {
+		t.owners = map[types.UID]*ownerIdleActions{}
+	}
+	actions, found := t.owners[owner]
+	if !found {
+		actions = &ownerIdleActions{namespace: namespace, scaleUps: map[string]time.Time{}}
+		t.owners[owner] = actions
+	}
+	if _, counted := actions.scaleUps[idledAt]; !counted {
+		actions.scaleUps[idledAt] = now
+	}
+	return actions.recentScaleUps(window, now)
+}
+
+// ownerFighting returns true if the owner was scaled up again at least the given number of times within the window
+func (t *idleActionTracker) ownerFighting(owner types.UID, threshold int, window time.Duration, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	actions, found := t.owners[owner]
+	return found && actions.recentScaleUps(window, now) >= threshold
+}
+
+// fighting returns true if any owner in the given namespace was scaled up again at least the given number of times within the window
+func (t *idleActionTracker) fighting(namespace string, threshold int, window time.Duration, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, actions := range t.owners {
+		if actions.namespace == namespace && actions.recentScaleUps(window, now) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the scale-ups of the owners in the given namespace which happened before the given time,
+// and the owners which have no scale-up left
+func (t *idleActionTracker) prune(namespace string, since time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for uid, actions := range t.owners {
+		if actions.namespace != namespace {
+			continue
+		}
+		for idledAt, at := range actions.scaleUps {
+			if at.Before(since) {
+				delete(actions.scaleUps, idledAt)
+			}
+		}
+		if len(actions.scaleUps) == 0 {
+			delete(t.owners, uid)
+		}
+	}
+}
+
+func (a *ownerIdleActions) recentScaleUps(window time.Duration, now time.Time) int {
+	recent := 0
+	for _, at := range a.scaleUps {
+		if now.Sub(at) <= window {
+			recent++
+		}
+	}
+	return recent
+}
+
+// recordScaleUps records the scale-ups of the owners of the pod which were idled within the fighting window and are scaled up again,
+// ie. the pod was created after the owner had been idled. The owners scaled up again too many times within the window are fighting the idler:
+// the next known owner is idled as well when they are idled again. The scale-ups are counted when the new pods appear rather than when
+// the owners are idled again, since the owners are idled again only after the timeout of the Idler.
+func (i *ownerIdler) recordScaleUps(ctx context.Context, pod *corev1.Pod) {
+	threshold := i.config.FightingThreshold()
+	if threshold == 0 || i.idleActions == nil {
+		return
+	}
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to find all owners, check the owners that are available")
+	}
+	now := time.Now()
+	window := i.config.FightingWindow(i.idler.Spec.TimeoutSeconds)
+	for _, owner := range owners {
+		value, idled := owner.object.GetAnnotations()[IdledAtAnnotationKey]
+		if !idled || i.processed[ownerKey(owner)] || !scaledUpAfterIdling(owner.object) {
+			continue
+		}
+		idledAt, err := time.Parse(time.RFC3339, value)
+		// the pods created before the idling are still terminating, they don't mean the owner was scaled up again
+		if err != nil || now.Sub(idledAt) > window || pod.CreationTimestamp.Time.Before(idledAt) {
+			continue
+		}
+		scaleUps := i.idleActions.recordScaleUp(i.idler.Name, owner.object.GetUID(), value, window, now)
+		if scaleUps < threshold {
+			continue
+		}
+		workload := fmt.Sprintf("%s/%s", owner.object.GetKind(), owner.object.GetName())
+		if !i.fightingOwners[workload] {
+			log.FromContext(ctx).Info("Owner was scaled up again too many times after being idled, another controller is fighting the idler", "kind", owner.object.GetKind(), "name", owner.object.GetName(), "scale_ups", scaleUps)
+			if i.fightingOwners == nil {
+				i.fightingOwners = map[string]bool{}
+			}
+			i.fightingOwners[workload] = true
+		}
+	}
+}
+
+// fightingIdler returns true if the owner is fighting the idler, ie. if it was scaled up again too many times within the configured window
+func (i *ownerIdler) fightingIdler(owner *objectWithGVR) bool {
+	threshold := i.config.FightingThreshold()
+	if threshold == 0 || i.idleActions == nil {
+		return false
+	}
+	if i.fightingOwners[fmt.Sprintf("%s/%s", owner.object.GetKind(), owner.object.GetName())] {
+		return true
+	}
+	return i.idleActions.ownerFighting(owner.object.GetUID(), threshold, i.config.FightingWindow(i.idler.Spec.TimeoutSeconds), time.Now())
+}
+
+// watchScaleUpsOfIdledOwners makes the new pods of the Idler evaluated right away within the fighting window
+// if some owners were idled, so their scale-ups are counted when they happen
+func (r *Reconciler) watchScaleUpsOfIdledOwners(idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler) {
+	if len(ownerIdler.processed) == 0 || r.config().FightingThreshold() == 0 {
+		return
+	}
+	r.watchScaleUps(idler.Name, time.Now().Add(r.config().FightingWindow(idler.Spec.TimeoutSeconds)))
+}
+
+// reportFightingControllers sets the IdlerControllerFightingDetected condition and sends a notification listing the owners
+// which are fighting the idler. Only one notification is sent until no owner of the namespace is fighting the idler anymore.
+// Errors are only logged and stored in the Idler status so the processing of the pods is not affected.
+func (r *Reconciler) reportFightingControllers(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler) {
+	logger := log.FromContext(ctx)
+	threshold := r.config().FightingThreshold()
+	if threshold == 0 {
+		return
+	}
+	now := time.Now()
+	window := r.config().FightingWindow(idler.Spec.TimeoutSeconds)
+	r.idleActions.prune(idler.Name, now.Add(-window))
+	cond, found := condition.FindConditionByType(idler.Status.Conditions, IdlerControllerFightingDetected)
+	if len(ownerIdler.fightingOwners) == 0 {
+		// reset the condition when no owner is fighting the idler anymore, so the users are notified again next time
+		if found && cond.Reason != IdlerNoControllerFightingDetectedReason && !r.idleActions.fighting(idler.Name, threshold, window, now) {
+			if err := r.setStatusNoControllerFightingDetected(ctx, idler); err != nil {
+				logger.Error(err, "failed to reset status IdlerControllerFightingDetected")
+			}
+		}
+		return
+	}
+	if found && cond.Reason == IdlerControllerFightingDetectedReason {
+		// the users were already notified
+		return
+	}
+
+	workloads := make([]string, 0, len(ownerIdler.fightingOwners))
+	for workload := range ownerIdler.fightingOwners {
+		workloads = append(workloads, workload)
+	}
+	slices.Sort(workloads)
+	logger.Info("Creating controller fighting Notification", "workloads", workloads)
+	if err := r.createControllerFightingNotification(ctx, idler, workloads); err != nil {
+		logger.Error(err, "failed to create controller fighting Notification")
+		metrics.IdlerNotificationFailuresCounterVec.WithLabelValues(notificationTypeIdlerControllerFighting).Inc()
+		if err := r.setStatusControllerFightingNotificationCreationFailed(ctx, idler, err.Error()); err != nil {
+			logger.Error(err, "failed to set status IdlerControllerFightingDetected")
+		}
+		return
+	}
+	if err := r.setStatusControllerFightingDetected(ctx, idler, workloads); err != nil {
+		logger.Error(err, "failed to set status IdlerControllerFightingDetected")
+	}
+}
+
+func (r *Reconciler) createControllerFightingNotification(ctx context.Context, idler *toolchainv1alpha1.Idler, workloads []string) error {
+	// the name contains the timestamp, so a new notification is created every time the fighting is detected again
+	notificationName := fmt.Sprintf("%s-%s-%d", idler.Name, notificationTypeIdlerControllerFighting, time.Now().Unix())
+	keysAndVals := map[string]string{
+		"Namespace": idler.Name,
+		"Workloads": strings.Join(workloads, ", "),
+	}
+	// the queued notification is delivered by the notification outbox, the fighting is reported by the condition in the meantime
+	_, err := r.sendNotification(ctx, idler, notificationName, notificationTypeIdlerControllerFighting, "idlercontrollerfighting", keysAndVals)
+	return err
+}
+
+func (r *Reconciler) setStatusControllerFightingDetected(ctx context.Context, idler *toolchainv1alpha1.Idler, workloads []string) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:    IdlerControllerFightingDetected,
+			Status:  corev1.ConditionTrue,
+			Reason:  IdlerControllerFightingDetectedReason,
+			Message: fmt.Sprintf("the following workloads are scaled up again right after being idled: %s", strings.Join(workloads, ", ")),
+		})
+}
+
+func (r *Reconciler) setStatusControllerFightingNotificationCreationFailed(ctx context.Context, idler *toolchainv1alpha1.Idler, message string) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:    IdlerControllerFightingDetected,
+			Status:  corev1.ConditionTrue,
+			Reason:  IdlerControllerFightingNotificationCreationFailedReason,
+			Message: message,
+		})
+}
+
+func (r *Reconciler) setStatusNoControllerFightingDetected(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:   IdlerControllerFightingDetected,
+			Status: corev1.ConditionFalse,
+			Reason: IdlerNoControllerFightingDetectedReason,
+		})
+}
@@ -0,0 +1,240 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestIdleActionTracker(t *testing.T) {
+	// given
+	now := time.Now()
+	idledAt := func(at time.Time) string {
+		return at.UTC().Format(time.RFC3339)
+	}
+
+	t.Run("scale-ups after idling are counted", func(t *testing.T) {
+		// given
+		tracker := &idleActionTracker{}
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-40*time.Minute)), time.Hour, now.Add(-30*time.Minute))
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-25*time.Minute)), time.Hour, now.Add(-20*time.Minute))
+
+		// when
+		scaleUps := tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-10*time.Minute)), time.Hour, now)
+
+		// then
+		assert.Equal(t, 3, scaleUps)
+		assert.True(t, tracker.fighting("john-dev", 3, time.Hour, now))
+		assert.False(t, tracker.fighting("john-dev", 4, time.Hour, now))
+		assert.False(t, tracker.fighting("john-stage", 1, time.Hour, now))
+		assert.True(t, tracker.ownerFighting("web-uid", 3, time.Hour, now))
+		assert.False(t, tracker.ownerFighting("other-uid", 1, time.Hour, now))
+	})
+
+	t.Run("scale-up after the same idling is counted once", func(t *testing.T) {
+		// given
+		tracker := &idleActionTracker{}
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-10*time.Minute)), time.Hour, now.Add(-time.Minute))
+
+		// when
+		scaleUps := tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-10*time.Minute)), time.Hour, now)
+
+		// then
+		assert.Equal(t, 1, scaleUps)
+	})
+
+	t.Run("scale-ups outside of the window are not counted", func(t *testing.T) {
+		// given
+		tracker := &idleActionTracker{}
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-4*time.Hour)), time.Hour, now.Add(-3*time.Hour))
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-150*time.Minute)), time.Hour, now.Add(-2*time.Hour))
+
+		// when
+		scaleUps := tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-5*time.Minute)), time.Hour, now)
+
+		// then
+		assert.Equal(t, 1, scaleUps)
+	})
+
+	t.Run("scale-ups following the idlings after a realistic timeout are counted with the default window", func(t *testing.T) {
+		// given
+		timeoutSeconds := int32(8 * 3600)
+		window := NewConfig(ConfigSpec{}).FightingWindow(timeoutSeconds)
+		tracker := &idleActionTracker{}
+		// the owner is scaled up right after being idled, and idled again only after the timeout
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-16*time.Hour-time.Minute)), window, now.Add(-16*time.Hour))
+		tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-8*time.Hour-time.Minute)), window, now.Add(-8*time.Hour))
+
+		// when
+		scaleUps := tracker.recordScaleUp("john-dev", "web-uid", idledAt(now.Add(-time.Minute)), window, now)
+
+		// then
+		assert.Equal(t, 3, scaleUps)
+		assert.True(t, tracker.ownerFighting("web-uid", NewConfig(ConfigSpec{}).FightingThreshold(), window, now))
+	})
+
+	t.Run("prune removes the records of the owners not scaled up recently", func(t *testing.T) {
+		// given
+		tracker := &idleActionTracker{}
+		tracker.recordScaleUp("john-dev", "old-uid", idledAt(now.Add(-3*time.Hour)), time.Hour, now.Add(-2*time.Hour))
+		tracker.recordScaleUp("john-dev", "recent-uid", idledAt(now.Add(-time.Hour)), time.Hour, now)
+		tracker.recordScaleUp("john-stage", "other-uid", idledAt(now.Add(-3*time.Hour)), time.Hour, now.Add(-2*time.Hour))
+
+		// when
+		tracker.prune("john-dev", now.Add(-time.Hour))
+
+		// then
+		assert.Len(t, tracker.owners, 2)
+		assert.Contains(t, tracker.owners, types.UID("recent-uid"))
+		assert.Contains(t, tracker.owners, types.UID("other-uid"))
+	})
+}
+
+func TestControllerFightingTheIdler(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 8 * 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	deploymentGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: idler.Name, UID: "web-uid"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To[int32](3)},
+	}
+	createObjectWithDynamicClient(t, fakeClients.DynamicClient, deployment)
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-replicaset", Namespace: idler.Name, UID: "web-replicaset-uid"},
+		Spec:       appsv1.ReplicaSetSpec{Replicas: ptr.To[int32](3)},
+	}
+	require.NoError(t, controllerutil.SetControllerReference(deployment, replicaSet, scheme.Scheme))
+	createObjectWithDynamicClient(t, fakeClients.DynamicClient, replicaSet)
+	// simulates the GitOps controller which scales the Deployment up again, so new pods are created after the Deployment was idled.
+	// The rounds run within the same second, so the time of the previous idling is moved back by the timeout of the Idler,
+	// as if the Deployment was idled again only after the timeout.
+	scaleUp := func(t *testing.T, round int) {
+		patch := `{"spec":{"replicas":3}}`
+		if round > 0 {
+			idledAt := time.Now().Add(-time.Duration(max(3-round, 0)*8)*time.Hour - time.Minute).UTC().Format(time.RFC3339)
+			patch = fmt.Sprintf(`{"spec":{"replicas":3},"metadata":{"annotations":{%q:%q}}}`, IdledAtAnnotationKey, idledAt)
+		}
+		_, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(idler.Name).
+			Patch(context.TODO(), deployment.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+		require.NoError(t, err)
+		require.NoError(t, fakeClients.AllNamespacesClient.DeleteAllOf(context.TODO(), &corev1.Pod{}, client.InNamespace(idler.Name)))
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("web-pod-%d", round),
+				Namespace:         idler.Name,
+				CreationTimestamp: metav1.Time{Time: time.Now().Add(time.Second)},
+			},
+			Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-8*time.Hour - time.Minute)}},
+		}
+		require.NoError(t, controllerutil.SetControllerReference(replicaSet, pod, scheme.Scheme))
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+	}
+	fightingNotifications := func(t *testing.T) []toolchainv1alpha1.Notification {
+		notifications := &toolchainv1alpha1.NotificationList{}
+		require.NoError(t, fakeClients.DefaultClient.List(context.TODO(), notifications,
+			client.MatchingLabels{toolchainv1alpha1.NotificationTypeLabelKey: notificationTypeIdlerControllerFighting}))
+		return notifications.Items
+	}
+
+	t.Run("only the Deployment is idled while it's scaled up again less than the threshold", func(t *testing.T) {
+		for round := 0; round < 3; round++ {
+			// given
+			scaleUp(t, round)
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledDown(deployment).
+				ReplicaSetScaledUp(replicaSet)
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				HasConditions(memberoperatortest.Running(), memberoperatortest.IdlerNotificationCreated())
+			assert.Empty(t, fightingNotifications(t))
+		}
+	})
+
+	t.Run("fighting is detected and the next owner is idled", func(t *testing.T) {
+		// given
+		scaleUp(t, 3)
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment).
+			ReplicaSetScaledDown(replicaSet)
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			HasConditions(memberoperatortest.Running(), memberoperatortest.IdlerNotificationCreated(), toolchainv1alpha1.Condition{
+				Type:    IdlerControllerFightingDetected,
+				Status:  corev1.ConditionTrue,
+				Reason:  IdlerControllerFightingDetectedReason,
+				Message: "the following workloads are scaled up again right after being idled: Deployment/web",
+			})
+		notifications := fightingNotifications(t)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "alex@test.com", notifications[0].Spec.Recipient)
+		assert.Equal(t, "idlercontrollerfighting", notifications[0].Spec.Template)
+		assert.Equal(t, "Deployment/web", notifications[0].Spec.Context["Workloads"])
+
+		t.Run("no other notification is sent while the fighting continues", func(t *testing.T) {
+			// given
+			scaleUp(t, 4)
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			assert.Len(t, fightingNotifications(t), 1)
+		})
+
+		t.Run("condition is reset when the fighting is over", func(t *testing.T) {
+			// given
+			reconciler.GetConfig = configFor(ConfigSpec{FightingWindow: ptr.To("1ms")})
+			time.Sleep(time.Millisecond)
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				ContainsCondition(toolchainv1alpha1.Condition{
+					Type:   IdlerControllerFightingDetected,
+					Status: corev1.ConditionFalse,
+					Reason: IdlerNoControllerFightingDetectedReason,
+				})
+		})
+	})
+}
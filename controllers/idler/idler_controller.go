@@ -4,19 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"k8s.io/client-go/discovery"
 
 	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
 	"github.com/codeready-toolchain/toolchain-common/pkg/cluster"
 	"github.com/codeready-toolchain/toolchain-common/pkg/condition"
-	notify "github.com/codeready-toolchain/toolchain-common/pkg/notification"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	runtimeCluster "sigs.k8s.io/controller-runtime/pkg/cluster"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -27,7 +30,6 @@ import (
 	"github.com/redhat-cop/operator-utils/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,20 +38,44 @@ import (
 )
 
 const (
-	restartThreshold = 50
-	// Keep the AAP pod restart threshold lower than the default so the AAP idler kicks in before the main idler.
-	aapRestartThreshold = restartThreshold - 1
-	vmSubresourceURLFmt = "/apis/subresources.kubevirt.io/%s"
+	subresourcesURLFmt = "/apis/subresources.%s/%s"
+
+	// idlerTriggeredTemplate is the template of the notification sent when the workloads were idled
+	idlerTriggeredTemplate = "idlertriggered"
+	// pipelineRunCancelledTemplate is the template of the notification sent when a pipeline run was cancelled
+	pipelineRunCancelledTemplate = "idlerpipelineruncancelled"
+	// appNotStartedTemplate is the template of the notification sent when the workloads were idled because they could not start
+	appNotStartedTemplate = "idlerappnotstarted"
+
+	// IdlerNotificationCooldownElapsedReason is set when the notification cooldown elapsed, so the next idled workloads are notified again
+	IdlerNotificationCooldownElapsedReason = "NotificationCooldownElapsed"
+	// IdlerNotificationQueuedReason is set when the notification couldn't be delivered yet and is queued in the notification outbox,
+	// the IdlerTriggeredNotificationCreated condition is set once the outbox delivers it
+	IdlerNotificationQueuedReason = "NotificationQueued"
 )
 
 var vmGVR = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}
 
 // SetupWithManager sets up the controller with the Manager.
+// The Idlers are not requeued periodically - the central scheduler enqueues them when any of their deadlines is due.
+// The queued notifications are delivered by the notification outbox running along with the scheduler.
 func (r *Reconciler) SetupWithManager(mgr manager.Manager, allNamespaceCluster runtimeCluster.Cluster) error {
+	r.scheduler = newIdlingScheduler()
+	if err := mgr.Add(r.scheduler); err != nil {
+		return err
+	}
+	if err := mgr.Add(r.notificationOutbox()); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&toolchainv1alpha1.Idler{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		For(&toolchainv1alpha1.Idler{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		WatchesRawSource(source.Kind(allNamespaceCluster.GetCache(), &corev1.Pod{},
+			handler.TypedEnqueueRequestsFromMapFunc(r.scheduler.mapDuePodToIdler), PodIdlerPredicate{GetConfig: r.GetConfig, DeadlinesScheduled: true})).
 		WatchesRawSource(source.Kind(allNamespaceCluster.GetCache(), &corev1.Pod{},
-			handler.TypedEnqueueRequestsFromMapFunc(MapPodToIdler), PodIdlerPredicate{})).
+			&podDeadlineHandler{scheduler: r.scheduler, getConfig: r.GetConfig})).
+		WatchesRawSource(source.Channel(r.scheduler.events, &handler.TypedEnqueueRequestForObject[*toolchainv1alpha1.Idler]{})).
+		WatchesRawSource(source.Kind(allNamespaceCluster.GetCache(), &corev1.Namespace{},
+			handler.TypedEnqueueRequestsFromMapFunc(MapNamespaceToIdler), UnidleRequestedPredicate())).
 		Complete(r)
 }
 
@@ -64,6 +90,19 @@ type Reconciler struct {
 	DiscoveryClient     discovery.ServerResourcesInterface
 	GetHostCluster      cluster.GetHostClusterFunc
 	Namespace           string
+	// GetConfig returns the idler settings. It's called on every reconcile, so the changes of the settings in the MemberOperatorConfig
+	// are applied right away. The defaults are used when not set.
+	GetConfig func() Config
+	// Recorder records the Events on the idled owners in the namespaces of the users. No Events are recorded if it's not set.
+	Recorder record.EventRecorder
+
+	activity       activityTracker
+	restarts       restartTracker
+	idleActions    idleActionTracker
+	dryRunActions  dryRunTracker
+	discoveryCache discoveryCache
+	// scheduler enqueues the Idlers when their deadlines are due. If it's not set, then the Idlers are requeued instead.
+	scheduler *idlingScheduler
 }
 
 //+kubebuilder:rbac:groups=toolchain.dev.openshift.com,resources=idlers,verbs=get;list;watch;create;update;patch;delete
@@ -73,13 +112,36 @@ type Reconciler struct {
 //+kubebuilder:rbac:groups="",resources=pods;replicationcontrollers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments;daemonsets;replicasets;statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.openshift.io,resources=deploymentconfigs,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachines;virtualmachineinstances,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=serving.kserve.io,resources=inferenceservices;servingruntimes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=workspace.devfile.io,resources=devworkspaces,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=kubeflow.org,resources=notebooks,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns;taskruns,verbs=get;list;watch;update;patch
+
+// needed to keep the autoscalers from scaling the idled owners up again
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;update;patch
+
+// needed to track the activity of the workloads
+//+kubebuilder:rbac:groups=metrics.k8s.io,resources=pods,verbs=get;list
 
 // needed to stop the VMs - we need to make a PUT request for the "stop" subresource. Kubernetes internally classifies these as either create or update
 // based on the state of the existing object.
-//+kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachines/stop,verbs=create;update
+// The "start" subresource is needed to start the VMs again when un-idling the namespace.
+//+kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachines/stop;virtualmachines/start,verbs=create;update
+
+// needed to idle the owners of the kinds which are not known by the idler, but which have the scale subresource
+//+kubebuilder:rbac:groups=*,resources=*/scale,verbs=get;patch;update
+
+// needed to queue the notifications which couldn't be delivered to the host cluster and to maintain the idler report in the namespaces of the users
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+
+// needed to record the Events on the idled owners
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// needed to detect the un-idling requests set on the namespaces
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;patch
 
 //+kubebuilder:rbac:groups=aap.ansible.com,resources=ansibleautomationplatforms,verbs=get;list;watch;create;update;patch;delete
 // There are other AAP resource kinds which are involved in the Pod -> ... -> AnsibleAutomationPlatform ownership chain. We need to be able to get/list them.
@@ -98,18 +160,26 @@ func (r *Reconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.
 	if err := r.Client.Get(ctx, types.NamespacedName{Name: request.Name}, idler); err != nil {
 		if apierrors.IsNotFound(err) {
 			logger.Info("no Idler found for namespace", "name", request.Name)
+			r.unscheduleIdler(request.Name)
 			return reconcile.Result{}, nil
 		}
 		logger.Error(err, "failed to get Idler")
 		return reconcile.Result{}, err
 	}
 	if util.IsBeingDeleted(idler) {
+		r.unscheduleIdler(idler.Name)
 		return reconcile.Result{}, nil
 	}
 
+	if err := r.unidleIfRequested(ctx, idler); err != nil {
+		return reconcile.Result{}, r.wrapErrorWithStatusUpdate(ctx, idler, r.setStatusFailed, err,
+			"failed to un-idle '%s'", idler.Name)
+	}
+
 	logger.Info("ensuring idling")
 	if idler.Spec.TimeoutSeconds == 0 {
 		logger.Info("no idling when timeout is 0")
+		r.unscheduleIdler(idler.Name)
 		return reconcile.Result{}, r.setStatusNoDeactivation(ctx, idler)
 	}
 	if idler.Spec.TimeoutSeconds < 0 {
@@ -118,11 +188,15 @@ func (r *Reconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.
 		logger.Error(err, "failed to ensure idling")
 		return reconcile.Result{}, r.setStatusFailed(ctx, idler, err.Error())
 	}
-	requeueAfter, err := r.ensureIdling(ctx, idler)
+	requeueAfter, err := r.ensureIdling(ctx, idler, r.takeDuePods(idler))
 	if err != nil {
 		return reconcile.Result{}, r.wrapErrorWithStatusUpdate(ctx, idler, r.setStatusFailed, err,
 			"failed to ensure idling '%s'", idler.Name)
 	}
+	if r.scheduler != nil {
+		// the deadlines of the pods were scheduled by ensureIdling
+		return reconcile.Result{}, r.setStatusReady(ctx, idler)
+	}
 	logger.Info("requeueing for next pod to check", "after_seconds", requeueAfter.Seconds())
 	result := reconcile.Result{
 		Requeue:      true,
@@ -131,50 +205,121 @@ func (r *Reconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.
 	return result, r.setStatusReady(ctx, idler)
 }
 
-func getTimeout(idler *toolchainv1alpha1.Idler, pod corev1.Pod) int32 {
-	timeoutSeconds := idler.Spec.TimeoutSeconds
-	if isOwnedByVM(pod.ObjectMeta) {
-		// use 1/12th of the timeout for VMs to have more aggressive idling to decrease
-		// the infra costs because VMs consume much more resources
-		timeoutSeconds = timeoutSeconds / 12
-	}
-	return timeoutSeconds
-}
-
-func (r *Reconciler) ensureIdling(ctx context.Context, idler *toolchainv1alpha1.Idler) (time.Duration, error) {
+// ensureIdling idles the pods of the Idler which are due. All pods are evaluated when duePods is nil.
+func (r *Reconciler) ensureIdling(ctx context.Context, idler *toolchainv1alpha1.Idler, duePods map[string]bool) (time.Duration, error) {
 	// Get all pods running in the namespace
 	podList := &corev1.PodList{}
 	if err := r.AllNamespacesClient.List(ctx, podList, client.InNamespace(idler.Name)); err != nil {
 		return 0, err
 	}
-	ownerIdler := newOwnerIdler(idler, r)
 	requeueAfter := time.Duration(idler.Spec.TimeoutSeconds) * time.Second
+	ownerIdler := newOwnerIdler(idler, r)
+	if r.config().ActivityBasedIdling() {
+		if err := r.trackActivity(ctx, ownerIdler, idler.Name, podList.Items); err != nil {
+			// not returning the error, the pods are idled based on their start time and the last known activity
+			log.FromContext(ctx).Error(err, "failed to track the activity of the pods")
+		}
+		// check the activity again even if there is no pod to be idled before that
+		requeueAfter = shorterDuration(requeueAfter, r.config().ActivityCheckPeriod())
+	}
+	windowEnd, untilNextWindow := r.checkIdlingWindow(ctx, idler)
+	windowActive := !windowEnd.IsZero()
+	// the pods created while the window is active are idled right away
+	r.setIdlingWindowEnd(idler.Name, windowEnd)
+	if untilNextWindow > 0 {
+		requeueAfter = shorterDuration(requeueAfter, untilNextWindow)
+	}
+	r.scheduleAfter(idler.Name, idlerDeadline, requeueAfter)
+	r.resetNotificationAfterCooldown(ctx, idler)
+	podsOverBudget, checkBudgetAfter := r.podsOverSpaceBudget(ctx, idler, podList.Items)
+	if checkBudgetAfter > 0 {
+		requeueAfter = shorterDuration(requeueAfter, checkBudgetAfter)
+		r.scheduleAfter(idler.Name, idlerDeadline, checkBudgetAfter)
+	}
+	warningPercentage := r.config().PreIdleWarningPercentage()
 	var idleErrors []error
+	var podsToWarnAbout []corev1.Pod
+	// warnIdleAt is the earliest projected idle time of the pods to warn about
+	var warnIdleAt time.Time
 	for _, pod := range podList.Items {
+		if duePods != nil && !duePods[pod.Name] && !windowActive && !podsOverBudget[pod.Name] {
+			// the deadline of the pod is not due yet and is kept in the scheduler
+			continue
+		}
 		podLogger := log.FromContext(ctx).WithValues("pod_name", pod.Name, "pod_phase", pod.Status.Phase)
 		podCtx := log.IntoContext(ctx, podLogger)
+		if !ownerIdler.dryRun && pod.DeletionTimestamp == nil {
+			ownerIdler.recordScaleUps(podCtx, &pod)
+		}
 
-		timeoutSeconds := getTimeout(idler, pod)
+		if windowActive {
+			podLogger.Info("Idling window is active. Killing the pod")
+			if err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, idleReasonScheduled); err != nil {
+				idleErrors = append(idleErrors, err)
+				podLogger.Error(err, "failed to kill the pod")
+			}
+			continue
+		}
+		if podsOverBudget[pod.Name] {
+			podLogger.Info("Space is over the idling budget. Killing the pod")
+			err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, idleReasonSpaceBudget)
+			if err == nil {
+				continue
+			}
+			idleErrors = append(idleErrors, err)
+			podLogger.Error(err, "failed to kill the pod")
+		}
+		if untilTimeout, pending := untilPendingTimeout(&pod, r.config().PendingTimeout()); pending {
+			if untilTimeout <= 0 {
+				podLogger.Info("Pod has been pending for too long. Killing the pod", "creation_time", pod.CreationTimestamp.Format("2006-01-02T15:04:05Z"), "pending_timeout", r.config().PendingTimeout().String())
+				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, idleReasonPending)
+				if err == nil {
+					continue
+				}
+				idleErrors = append(idleErrors, err)
+				podLogger.Error(err, "failed to kill the pod")
+			} else {
+				requeueAfter = shorterDuration(requeueAfter, untilTimeout)
+				r.scheduleAfter(idler.Name, pod.Name, untilTimeout)
+			}
+		}
+		timeoutSeconds := ownerIdler.timeout(podCtx, &pod)
 		if pod.Status.StartTime != nil {
+			if ownerIdler.keptScaledDownOwnerScaledUp(podCtx, &pod) {
+				podLogger.Info("Owner kept scaled down because of crash-looping pods was scaled up again. Killing the pod")
+				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, idleReasonKeptScaledDown)
+				if err == nil {
+					continue
+				}
+				idleErrors = append(idleErrors, err)
+				podLogger.Error(err, "failed to kill the pod")
+			}
+			if !ownerIdler.dryRun && pod.DeletionTimestamp == nil {
+				ownerIdler.restoreAutoscalersOfScaledUpOwners(podCtx, &pod)
+			}
+			idleSince := ownerIdler.idleSince(podCtx, &pod)
 			// check the restart count for the pod
 			restartCount := getHighestRestartCount(pod.Status)
-			if restartCount > restartThreshold {
-				podLogger.Info("Pod is restarting too often. Killing the pod", "restart_count", restartCount)
+			if reason := r.crashLoopReason(podCtx, ownerIdler, idler.Name, &pod); reason != "" {
+				podLogger.Info("Pod is restarting too often. Killing the pod", "restart_count", restartCount, "reason", reason)
 				// Check if it belongs to a controller (Deployment, DeploymentConfig, etc) and scale it down to zero.
-				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler)
+				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, reason)
 				if err == nil {
 					continue
 				}
 				idleErrors = append(idleErrors, err)
 				podLogger.Error(err, "failed to kill the pod")
 			}
-			// Check the start time
-			if time.Now().After(pod.Status.StartTime.Add(time.Duration(timeoutSeconds) * time.Second)) {
-				podLogger.Info("Pod running for too long. Killing the pod.", "start_time", pod.Status.StartTime.Format("2006-01-02T15:04:05Z"), "timeout_seconds", timeoutSeconds)
+			// Check the start time (or the last time the workload was active)
+			if time.Now().After(idleSince.Add(time.Duration(timeoutSeconds) * time.Second)) {
+				podLogger.Info("Pod running for too long. Killing the pod.", "start_time", pod.Status.StartTime.Format("2006-01-02T15:04:05Z"), "idle_since", idleSince.Format("2006-01-02T15:04:05Z"), "timeout_seconds", timeoutSeconds)
 				// Check if it belongs to a controller (Deployment, DeploymentConfig, etc) and scale it down to zero.
-				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler)
+				err := r.deletePodsAndCreateNotification(podCtx, pod, idler, ownerIdler, idleReasonTimeout)
 				if err == nil {
-					requeueAfter = shorterDuration(requeueAfter, time.Duration(float32(timeoutSeconds)*0.05)*time.Second)
+					// requeue to idle the next known owner if the first one doesn't idle the workload
+					escalateAfter := ownerIdler.escalationTimeout(timeoutSeconds) - time.Duration(timeoutSeconds)*time.Second
+					requeueAfter = shorterDuration(requeueAfter, escalateAfter)
+					r.scheduleAfter(idler.Name, pod.Name, escalateAfter)
 					continue
 				}
 				idleErrors = append(idleErrors, err)
@@ -183,12 +328,52 @@ func (r *Reconciler) ensureIdling(ctx context.Context, idler *toolchainv1alpha1.
 		}
 		// calculate the next reconcile
 		if pod.Status.StartTime != nil {
-			killAfter := time.Until(pod.Status.StartTime.Add(time.Duration(timeoutSeconds+1) * time.Second))
-			requeueAfter = shorterDuration(requeueAfter, killAfter)
+			idleSince := ownerIdler.idleSince(podCtx, &pod)
+			nextCheck := time.Until(idleSince.Add(time.Duration(timeoutSeconds+1) * time.Second))
+			if warningPercentage > 0 {
+				warnAfter := time.Until(idleSince.Add(time.Duration(timeoutSeconds) * time.Second * time.Duration(warningPercentage) / 100))
+				if warnAfter > 0 {
+					nextCheck = shorterDuration(nextCheck, warnAfter)
+				} else {
+					podsToWarnAbout = append(podsToWarnAbout, pod)
+					if idleAt := idleSince.Add(time.Duration(timeoutSeconds) * time.Second); warnIdleAt.IsZero() || idleAt.Before(warnIdleAt) {
+						warnIdleAt = idleAt
+					}
+				}
+			}
+			requeueAfter = shorterDuration(requeueAfter, nextCheck)
+			r.scheduleAfter(idler.Name, pod.Name, nextCheck)
+			if !ownerIdler.dryRun {
+				ownerIdler.recordRunning(&pod, idleSince.Add(time.Duration(timeoutSeconds)*time.Second))
+			}
 		} else {
 			// if the pod doesn't contain startTime, then schedule the next reconcile to the timeout
 			// if not already scheduled to an earlier time
 			requeueAfter = shorterDuration(requeueAfter, time.Duration(timeoutSeconds)*time.Second)
+			r.scheduleAfter(idler.Name, pod.Name, time.Duration(timeoutSeconds)*time.Second)
+		}
+	}
+	if r.config().RestartRateThreshold() > 0 {
+		r.pruneRestarts(idler.Name, podList.Items)
+	}
+	r.pruneDryRunActions(idler.Name, podList.Items)
+	if !ownerIdler.dryRun {
+		if err := r.recordIdledResources(ctx, idler, ownerIdler); err != nil {
+			log.FromContext(ctx).Error(err, "failed to record the idled resources on the Idler")
+		}
+		r.notify(ctx, idler, ownerIdler.idledWorkloads)
+		r.notifyCrashLoops(ctx, idler, ownerIdler)
+	}
+	// when only the due pods were evaluated, then the absence of the pods to warn about doesn't end the idling cycle
+	if warningPercentage > 0 && !ownerIdler.dryRun && (duePods == nil || len(podsToWarnAbout) > 0) {
+		r.warnAboutIdling(ctx, idler, ownerIdler, podsToWarnAbout, warnIdleAt)
+	}
+	if !ownerIdler.dryRun {
+		r.watchScaleUpsOfIdledOwners(idler, ownerIdler)
+		r.reportFightingControllers(ctx, idler, ownerIdler)
+		// the report lists all running workloads, so it's updated only when all pods were evaluated
+		if duePods == nil {
+			r.updateReport(ctx, idler, ownerIdler, time.Now())
 		}
 	}
 	return requeueAfter, errors.Join(idleErrors...)
@@ -208,8 +393,9 @@ func shorterDuration(first, second time.Duration) time.Duration {
 
 // Check if the pod belongs to a controller (Deployment, DeploymentConfig, etc) and scale it down to zero.
 // if it is a standalone pod, delete it.
-// Send notification if the deleted pod was managed by a controller, was a standalone pod that was not completed or was crashlooping
-func (r *Reconciler) deletePodsAndCreateNotification(podCtx context.Context, pod corev1.Pod, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler) error {
+// Record the workload to be listed in the notification if the deleted pod was managed by a controller, was a standalone pod that was not completed or was crashlooping.
+// The crashlooping workloads are recorded to be notified separately.
+func (r *Reconciler) deletePodsAndCreateNotification(podCtx context.Context, pod corev1.Pod, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler, reason idleReason) error {
 	logger := log.FromContext(podCtx)
 	isCompleted := false
 	for _, podCond := range pod.Status.Conditions {
@@ -218,7 +404,14 @@ func (r *Reconciler) deletePodsAndCreateNotification(podCtx context.Context, pod
 			break
 		}
 	}
-	appType, appName, err := ownerIdler.scaleOwnerToZero(podCtx, &pod)
+	isEvicted := pod.Status.Reason == "Evicted"
+	switch {
+	case isEvicted:
+		reason = idleReasonEvicted
+	case isCompleted:
+		reason = idleReasonCompleted
+	}
+	appType, appName, err := ownerIdler.scaleOwnerToZero(podCtx, &pod, reason)
 	if err != nil {
 		if apierrors.IsNotFound(err) { // Ignore not found errors. Can happen if the parent controller has been deleted. The Garbage Collector should delete the pods shortly.
 			return nil
@@ -227,24 +420,55 @@ func (r *Reconciler) deletePodsAndCreateNotification(podCtx context.Context, pod
 	}
 	// when appType is empty, then it no known controller was found
 	deletedByController := appType != ""
-	isEvicted := pod.Status.Reason == "Evicted"
 	if !deletedByController || isCompleted || isEvicted { // Pod not managed by a controller, or completed or evicted pod. We can just delete the pod.
+		if ownerIdler.dryRun {
+			ownerIdler.reportDryRunAction(podCtx, &pod, "Pod", pod.Name, dryRunActionDeletePod, reason)
+			return nil
+		}
 		logger.Info("Deleting pod", "managed-by-controller", deletedByController, "completed", isCompleted, "evicted", isEvicted)
 		if err := r.AllNamespacesClient.Delete(podCtx, &pod); err != nil {
+			metrics.IdlerIdlingFailuresCounterVec.WithLabelValues("Pod").Inc()
 			return err
 		}
 		logger.Info("Pod deleted")
+		if !deletedByController {
+			metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues("Pod", string(reason)).Inc()
+			ownerIdler.recordEvent(&pod, corev1.EventTypeNormal, IdledEventReason, "Pod %s was deleted (reason: %s)", pod.Name, reason)
+		}
+	}
+	if ownerIdler.dryRun {
+		return nil // no notification in the dry-run mode
+	}
+	if pod.Status.StartTime != nil {
+		metrics.IdlerPodRunTimeHistogramVec.WithLabelValues(string(reason)).Observe(time.Since(pod.Status.StartTime.Time).Seconds())
 	}
 	if appName == "" {
 		appName = pod.Name
 		appType = "Pod"
 	}
 
+	if reason == idleReasonKeptScaledDown {
+		// the users were already notified when the owner was marked to be kept scaled down
+		return nil
+	}
+	if isCrashLoopReason(reason) {
+		// the crash-looping workloads are notified separately with the details of the crashing container
+		ownerIdler.recordCrashLoop(newCrashLoopWorkload(appType, appName, reason, &pod))
+		return nil
+	}
 	// If the pod was in the completed state (it wasn't running) and there was no controller scaled down,
 	// then  there's no reason to send an idler notification
 	if !isCompleted || deletedByController {
-		// By now either a pod has been deleted or scaled to zero by controller, idler Triggered notification should be sent
-		r.notify(podCtx, idler, appName, appType)
+		// By now either a pod has been deleted or scaled to zero by controller, the workload should be listed in the idler Triggered notification
+		workload := idledWorkload{kind: appType, name: appName, reason: reason, template: idlerTriggeredTemplate}
+		switch {
+		case reason == idleReasonPending:
+			workload.template = appNotStartedTemplate
+			workload.pendingReason = pendingReason(&pod)
+		case ownerIdler.strategies.cancels(appType):
+			workload.template = pipelineRunCancelledTemplate
+		}
+		ownerIdler.recordIdled(workload)
 	}
 	return nil
 }
@@ -259,99 +483,161 @@ func getHighestRestartCount(podstatus corev1.PodStatus) int32 {
 	return restartCount
 }
 
-func (r *Reconciler) notify(ctx context.Context, idler *toolchainv1alpha1.Idler, appName string, appType string) {
+// idledWorkload is a workload idled in the current reconcile, which is listed in the notification sent to the users
+type idledWorkload struct {
+	kind   string
+	name   string
+	reason idleReason
+	// template is the template of the notification specific for the way the workload was idled
+	template string
+	// pendingReason is the reason why the pod of the workload idled because it couldn't start was pending
+	pendingReason string
+}
+
+// recordIdled adds the workload to the workloads idled in this reconcile, unless it's already there
+func (i *ownerIdler) recordIdled(workload idledWorkload) {
+	for _, idled := range i.idledWorkloads {
+		if idled.kind == workload.kind && idled.name == workload.name {
+			return
+		}
+	}
+	i.idledWorkloads = append(i.idledWorkloads, workload)
+}
+
+// notify sends one notification listing all the given workloads idled in this reconcile
+func (r *Reconciler) notify(ctx context.Context, idler *toolchainv1alpha1.Idler, workloads []idledWorkload) {
+	if len(workloads) == 0 {
+		return
+	}
 	logger := log.FromContext(ctx)
-	logger.Info("Creating Notification")
-	if err := r.createNotification(ctx, idler, appName, appType); err != nil {
+	logger.Info("Creating Notification", "workloads", len(workloads))
+	if err := r.createNotification(ctx, idler, workloads); err != nil {
 		logger.Error(err, "failed to create Notification")
+		metrics.IdlerNotificationFailuresCounterVec.WithLabelValues(toolchainv1alpha1.NotificationTypeIdled).Inc()
 		if err = r.setStatusIdlerNotificationCreationFailed(ctx, idler, err.Error()); err != nil {
 			logger.Error(err, "failed to set status IdlerNotificationCreationFailed")
 		} // not returning error to continue processing remaining pods
 	}
 }
 
-func (r *Reconciler) createNotification(ctx context.Context, idler *toolchainv1alpha1.Idler, appName string, appType string) error {
-	//Get the HostClient
-	hostCluster, ok := r.GetHostCluster()
-	if !ok {
-		return fmt.Errorf("unable to get the host cluster")
-	}
+// createNotification creates the notification listing the idled (or cancelled) workloads. Only one notification is sent per
+// idling cycle - the cycle ends when the notification cooldown elapses.
+func (r *Reconciler) createNotification(ctx context.Context, idler *toolchainv1alpha1.Idler, workloads []idledWorkload) error {
 	//check the condition on Idler if notification already sent, only create a notification if not created before
 	if condition.IsTrue(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated) {
 		// notification already created
 		return nil
 	}
+	if cond, found := condition.FindConditionByType(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated); found && cond.Reason == IdlerNotificationQueuedReason {
+		// notification already queued, it's delivered by the notification outbox
+		return nil
+	}
 
 	notificationName := fmt.Sprintf("%s-%s", idler.Name, toolchainv1alpha1.NotificationTypeIdled)
-	notification := &toolchainv1alpha1.Notification{}
-	// Check if notification already exists in host
-	if err := hostCluster.Client.Get(ctx, types.NamespacedName{Name: notificationName, Namespace: hostCluster.OperatorNamespace}, notification); err != nil {
-		if !apierrors.IsNotFound(err) {
-			return err
-		}
-
-		userEmails, err := r.getUserEmailsFromMURs(ctx, hostCluster, idler)
-		if err != nil {
-			return err
-		}
-		if len(userEmails) == 0 {
-			// no email found, thus no email sent
-			return fmt.Errorf("no email found for the user in MURs")
-		}
-
-		keysAndVals := map[string]string{
-			"Namespace": idler.Name,
-			"AppName":   appName,
-			"AppType":   appType,
-		}
-
-		for _, userEmail := range userEmails {
-			_, err := notify.NewNotificationBuilder(hostCluster.Client, hostCluster.OperatorNamespace).
-				WithName(notificationName).
-				WithNotificationType(toolchainv1alpha1.NotificationTypeIdled).
-				WithTemplate("idlertriggered").
-				WithKeysAndValues(keysAndVals).
-				Create(ctx, userEmail)
-			if err != nil {
-				return fmt.Errorf("unable to create Notification CR from Idler: %w", err)
-			}
+	// the name of the notifications of the next cycles contains the time when the previous cycle ended,
+	// so the name is the same when the creation is retried within the same cycle
+	if cond, found := condition.FindConditionByType(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated); found && !cond.LastTransitionTime.IsZero() {
+		notificationName = fmt.Sprintf("%s-%d", notificationName, cond.LastTransitionTime.Unix())
+	}
+	listed := make([]string, 0, len(workloads))
+	template := workloads[0].template
+	for _, workload := range workloads {
+		listed = append(listed, fmt.Sprintf("%s/%s (%s)", workload.kind, workload.name, workload.reason))
+		if workload.template != template {
+			// the specific templates are used only if all workloads were idled the same way
+			template = idlerTriggeredTemplate
 		}
 	}
+	keysAndVals := map[string]string{
+		"Namespace": idler.Name,
+		// the first workload is kept in the context for the templates which show only one workload
+		"AppName":   workloads[0].name,
+		"AppType":   workloads[0].kind,
+		"Workloads": strings.Join(listed, ", "),
+	}
+	if workloads[0].pendingReason != "" {
+		keysAndVals["PendingReason"] = workloads[0].pendingReason
+	}
+	// the notifications which already exist in the host cluster are not created again
+	delivery, err := r.sendNotification(ctx, idler, notificationName, toolchainv1alpha1.NotificationTypeIdled, template, keysAndVals)
+	if err != nil {
+		return err
+	}
+	if delivery == notificationQueued {
+		// the cooldown starts only once the notification is delivered
+		return r.setStatusIdlerNotificationQueued(ctx, idler)
+	}
 	// set notification created condition
 	return r.setStatusIdlerNotificationCreated(ctx, idler)
 }
 
-func (r *Reconciler) getUserEmailsFromMURs(ctx context.Context, hostCluster *cluster.CachedToolchainCluster, idler *toolchainv1alpha1.Idler) ([]string, error) {
-	var emails []string
+// resetNotificationAfterCooldown resets the IdlerTriggeredNotificationCreated condition when the notification cooldown elapsed
+// since the last notification was sent, so the users are notified about the next idled workloads as well
+func (r *Reconciler) resetNotificationAfterCooldown(ctx context.Context, idler *toolchainv1alpha1.Idler) {
+	cond, found := condition.FindConditionByType(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated)
+	if !found || cond.Status != corev1.ConditionTrue || time.Since(cond.LastTransitionTime.Time) < r.config().NotificationCooldown() {
+		return
+	}
+	if err := r.setStatusIdlerNotificationCooldownElapsed(ctx, idler); err != nil {
+		log.FromContext(ctx).Error(err, "failed to reset status IdlerTriggeredNotificationCreated")
+	}
+}
+
+// sendNotification sends the notification with the given name, type, template and context to all notification sinks.
+// The users of the space the Idler belongs to are notified via the Notification CRs created in the host cluster. Once they are,
+// the event is queued in the notification outbox for each configured webhook, so the webhooks are not called during the reconcile
+// and the event is posted only once even if the notification is sent again. Only the error of the host sink is returned, so
+// the notification is retried (and the status of the Idler updated) as before - the failures to queue the events are only logged.
+// Returns notificationQueued if the notification couldn't be delivered to some users yet and is delivered by the notification outbox.
+func (r *Reconciler) sendNotification(ctx context.Context, idler *toolchainv1alpha1.Idler, notificationName, notificationType, template string, keysAndVals map[string]string) (notificationDelivery, error) {
+	event := notificationEvent{
+		Type:      notificationType,
+		Name:      notificationName,
+		Namespace: idler.Name,
+		Space:     idler.GetLabels()[toolchainv1alpha1.SpaceLabelKey],
+		Template:  template,
+		Context:   keysAndVals,
+		Time:      time.Now(),
+	}
+	delivery, err := (&hostNotificationSink{reconciler: r}).send(ctx, idler, event)
+	if err != nil {
+		return delivery, err
+	}
+	sinks := r.config().WebhookSinks()
+	entries := make([]outboxEntry, 0, len(sinks))
+	for _, sink := range sinks {
+		entries = append(entries, outboxEntry{Name: event.Name, Type: event.Type, Sink: sink.Name, Event: &event, NextAttempt: event.Time})
+	}
+	if err := r.notificationOutbox().enqueue(ctx, entries); err != nil {
+		log.FromContext(ctx).Error(err, "failed to queue the notification for the webhook sinks", "notification", notificationName)
+	}
+	return delivery, nil
+}
+
+// getSpaceUsernames returns the names of the users of the space the Idler belongs to, as they are set in the space roles of the NSTemplateSet
+func (r *Reconciler) getSpaceUsernames(ctx context.Context, idler *toolchainv1alpha1.Idler) ([]string, error) {
+	var usernames []string
 	//get NSTemplateSet from idler
 	logger := log.FromContext(ctx)
-	if spacename, found := idler.GetLabels()[toolchainv1alpha1.SpaceLabelKey]; found {
-		nsTemplateSet := &toolchainv1alpha1.NSTemplateSet{}
-		err := r.Client.Get(ctx, types.NamespacedName{Name: spacename, Namespace: r.Namespace}, nsTemplateSet)
-		if err != nil {
-			logger.Error(err, "could not get the NSTemplateSet with name", "spacename", spacename)
-			return emails, err
-		}
-		// iterate on space roles from NSTemplateSet
-		var murs []string
-		for _, spaceRole := range nsTemplateSet.Spec.SpaceRoles {
-			murs = append(murs, spaceRole.Usernames...)
-		}
-		// get MUR from host and use user email from annotations
-		for _, mur := range murs {
-			getMUR := &toolchainv1alpha1.MasterUserRecord{}
-			err := hostCluster.Client.Get(ctx, types.NamespacedName{Name: mur, Namespace: hostCluster.OperatorNamespace}, getMUR)
-			if err != nil {
-				return emails, fmt.Errorf("could not get the MUR: %w", err)
-			}
-			if email := getMUR.Spec.PropagatedClaims.Email; email != "" {
-				emails = append(emails, getMUR.Spec.PropagatedClaims.Email)
+	spacename, found := idler.GetLabels()[toolchainv1alpha1.SpaceLabelKey]
+	if !found {
+		logger.Info("Idler does not have any owner label", "idler_name", idler.Name)
+		return usernames, nil
+	}
+	nsTemplateSet := &toolchainv1alpha1.NSTemplateSet{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: spacename, Namespace: r.Namespace}, nsTemplateSet); err != nil {
+		logger.Error(err, "could not get the NSTemplateSet with name", "spacename", spacename)
+		return usernames, err
+	}
+	// iterate on space roles from NSTemplateSet
+	for _, spaceRole := range nsTemplateSet.Spec.SpaceRoles {
+		for _, username := range spaceRole.Usernames {
+			if !slices.Contains(usernames, username) {
+				usernames = append(usernames, username)
 			}
 		}
-	} else {
-		logger.Info("Idler does not have any owner label", "idler_name", idler.Name)
 	}
-	return emails, nil
+	return usernames, nil
 }
 
 type statusUpdater func(ctx context.Context, idler *toolchainv1alpha1.Idler, message string) error
@@ -411,6 +697,18 @@ func (r *Reconciler) setStatusIdlerNotificationCreated(ctx context.Context, idle
 		})
 }
 
+func (r *Reconciler) setStatusIdlerNotificationQueued(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:    toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+			Status:  corev1.ConditionFalse,
+			Reason:  IdlerNotificationQueuedReason,
+			Message: "the notification is queued until it can be delivered",
+		})
+}
+
 func (r *Reconciler) setStatusIdlerNotificationCreationFailed(ctx context.Context, idler *toolchainv1alpha1.Idler, message string) error {
 	return r.updateStatusConditions(
 		ctx,
@@ -423,6 +721,51 @@ func (r *Reconciler) setStatusIdlerNotificationCreationFailed(ctx context.Contex
 		})
 }
 
+func (r *Reconciler) setStatusIdlerNotificationCooldownElapsed(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:   toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+			Status: corev1.ConditionFalse,
+			Reason: IdlerNotificationCooldownElapsedReason,
+		})
+}
+
+func (r *Reconciler) setStatusIdlerWarningNotificationCreated(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:   IdlerWarningNotificationCreated,
+			Status: corev1.ConditionTrue,
+			Reason: IdlerWorkloadsAboutToBeIdledReason,
+		})
+}
+
+func (r *Reconciler) setStatusIdlerWarningNotificationCreationFailed(ctx context.Context, idler *toolchainv1alpha1.Idler, message string) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:    IdlerWarningNotificationCreated,
+			Status:  corev1.ConditionFalse,
+			Reason:  IdlerWarningNotificationCreationFailedReason,
+			Message: message,
+		})
+}
+
+func (r *Reconciler) setStatusNoWorkloadsAboutToBeIdled(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	return r.updateStatusConditions(
+		ctx,
+		idler,
+		toolchainv1alpha1.Condition{
+			Type:   IdlerWarningNotificationCreated,
+			Status: corev1.ConditionFalse,
+			Reason: IdlerNoWorkloadsAboutToBeIdledReason,
+		})
+}
+
 // wrapErrorWithStatusUpdate wraps the error and update the idler status. If the update failed then logs the error.
 func (r *Reconciler) wrapErrorWithStatusUpdate(ctx context.Context, idler *toolchainv1alpha1.Idler, statusUpdater statusUpdater, err error, format string, args ...interface{}) error {
 	if err == nil {
@@ -433,15 +776,3 @@ func (r *Reconciler) wrapErrorWithStatusUpdate(ctx context.Context, idler *toolc
 	}
 	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)
 }
-
-func isOwnedByVM(meta metav1.ObjectMeta) bool {
-	owners := meta.GetOwnerReferences()
-	for _, owner := range owners {
-		if owner.Controller != nil && *owner.Controller {
-			if owner.Kind == "VirtualMachineInstance" {
-				return true
-			}
-		}
-	}
-	return false
-}
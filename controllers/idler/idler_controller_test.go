@@ -14,6 +14,7 @@ import (
 
 	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
 	"github.com/codeready-toolchain/member-operator/pkg/apis"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
 	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
 	"github.com/codeready-toolchain/toolchain-common/pkg/cluster"
 	"github.com/codeready-toolchain/toolchain-common/pkg/condition"
@@ -25,6 +26,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	openshiftappsv1 "github.com/openshift/api/apps/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
@@ -38,6 +42,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	fakescale "k8s.io/client-go/scale/fake"
 	clienttest "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -557,6 +562,121 @@ func TestEnsureIdlingFailed(t *testing.T) {
 	})
 }
 
+func TestIdlerMetrics(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	expiredStartTime := &metav1.Time{Time: time.Now().Add(-time.Duration(idler.Spec.TimeoutSeconds+1) * time.Second)}
+
+	t.Run("idled owner is counted once", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues("Deployment", string(idleReasonTimeout))), 0.01)
+		assert.Equal(t, 1, promtestutil.CollectAndCount(metrics.IdlerWorkloadsIdledCounterVec))
+		assert.Zero(t, promtestutil.CollectAndCount(metrics.IdlerIdlingFailuresCounterVec))
+		assert.Zero(t, promtestutil.CollectAndCount(metrics.IdlerNotificationFailuresCounterVec))
+		// the run time is observed for each pod
+		assertObservedRunTimes(t, idleReasonTimeout, 3, time.Duration(idler.Spec.TimeoutSeconds)*time.Second)
+	})
+
+	t.Run("deleted standalone pod is counted with the restart threshold reason", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: idler.Name},
+			Status:     corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now()}, ContainerStatuses: restartingOverThreshold()},
+		}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues("Pod", string(idleReasonRestartThreshold))), 0.01)
+		assertObservedRunTimes(t, idleReasonRestartThreshold, 1, 0)
+	})
+
+	t.Run("owner of evicted pod is counted with the evicted reason", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPodsWithSuffix(t, "-evicted", fakeClients.AllNamespacesClient, replicaSet, nil, corev1.PodStatus{StartTime: expiredStartTime, Reason: "Evicted"})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues("Deployment", string(idleReasonEvicted))), 0.01)
+		assert.Equal(t, 1, promtestutil.CollectAndCount(metrics.IdlerWorkloadsIdledCounterVec))
+	})
+
+	t.Run("failed idling attempts are counted", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+		fakeClients.DynamicClient.PrependReactor("patch", "deployments", func(action clienttest.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("some error")
+		})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.Error(t, err)
+		// the deployment failed to be idled for every pod, the replicaset was idled when processing the first pod
+		assert.InDelta(t, float64(3), promtestutil.ToFloat64(metrics.IdlerIdlingFailuresCounterVec.WithLabelValues("Deployment")), 0.01)
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues("ReplicaSet", string(idleReasonTimeout))), 0.01)
+		assert.Equal(t, 1, promtestutil.CollectAndCount(metrics.IdlerWorkloadsIdledCounterVec))
+	})
+
+	t.Run("notification failures are counted", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		// the notification can't be queued without the users of the space
+		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler.DeepCopy(), mur)
+
+		// when
+		reconciler.notify(context.TODO(), idler.DeepCopy(), []idledWorkload{{kind: "Deployment", name: "test-app", reason: idleReasonTimeout, template: idlerTriggeredTemplate}})
+
+		// then
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerNotificationFailuresCounterVec.WithLabelValues(toolchainv1alpha1.NotificationTypeIdled)), 0.01)
+	})
+}
+
+// assertObservedRunTimes checks that the expected number of pod run times was observed for the given reason and that none of them was shorter than the given minimum
+func assertObservedRunTimes(t *testing.T, reason idleReason, expectedCount uint64, minimum time.Duration) {
+	metric := &dto.Metric{}
+	require.NoError(t, metrics.IdlerPodRunTimeHistogramVec.WithLabelValues(string(reason)).(prometheus.Histogram).Write(metric))
+	assert.Equal(t, expectedCount, metric.GetHistogram().GetSampleCount())
+	assert.GreaterOrEqual(t, metric.GetHistogram().GetSampleSum(), float64(expectedCount)*minimum.Seconds())
+}
+
 func TestNotificationAppNameTypeForPods(t *testing.T) {
 	//given
 	idler := &toolchainv1alpha1.Idler{
@@ -618,7 +738,9 @@ func TestNotificationAppNameTypeForPods(t *testing.T) {
 			pod, appName := tcs.preparePayload(fakeClients)
 
 			// when
-			err := reconciler.deletePodsAndCreateNotification(context.TODO(), *pod, idler.DeepCopy(), ownerIdler)
+			idlerCopy := idler.DeepCopy()
+			err := reconciler.deletePodsAndCreateNotification(context.TODO(), *pod, idlerCopy, ownerIdler, idleReasonTimeout)
+			reconciler.notify(context.TODO(), idlerCopy, ownerIdler.idledWorkloads)
 
 			//then
 			require.NoError(t, err)
@@ -649,6 +771,107 @@ func TestNotificationAppNameTypeForPods(t *testing.T) {
 	}
 
 }
+func TestAggregatedIdlerNotification(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	idledNotifications := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet) []toolchainv1alpha1.Notification {
+		notifications := &toolchainv1alpha1.NotificationList{}
+		require.NoError(t, fakeClients.DefaultClient.List(context.TODO(), notifications,
+			client.MatchingLabels{toolchainv1alpha1.NotificationTypeLabelKey: toolchainv1alpha1.NotificationTypeIdled}))
+		return notifications.Items
+	}
+
+	t.Run("one notification lists all workloads idled in the reconcile", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: expiredStartTimes(idler.Spec.TimeoutSeconds).defaultStartTime}, nil, noRestart())
+		pod := newPod(t, fakeClients, idler.Name, expiredStartTimes(idler.Spec.TimeoutSeconds))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		notifications := idledNotifications(t, fakeClients)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "alex-stage-idled", notifications[0].Name)
+		assert.Equal(t, idlerTriggeredTemplate, notifications[0].Spec.Template)
+		assert.Equal(t, fmt.Sprintf("Deployment/%s (timeout), Pod/%s (timeout)", deployment.Name, pod.Name), notifications[0].Spec.Context["Workloads"])
+		assert.Equal(t, "Deployment", notifications[0].Spec.Context["AppType"])
+		assert.Equal(t, deployment.Name, notifications[0].Spec.Context["AppName"])
+	})
+
+	t.Run("users are notified again after the cooldown", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: expiredStartTimes(idler.Spec.TimeoutSeconds).defaultStartTime}, nil, noRestart())
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		require.Len(t, idledNotifications(t, fakeClients), 1)
+
+		t.Run("no other notification within the cooldown", func(t *testing.T) {
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			assert.Len(t, idledNotifications(t, fakeClients), 1)
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				HasConditions(memberoperatortest.Running(), memberoperatortest.IdlerNotificationCreated())
+		})
+
+		t.Run("new notification when the cooldown elapsed", func(t *testing.T) {
+			// given
+			reconciler.GetConfig = configFor(ConfigSpec{NotificationCooldown: ptr.To("1ms")})
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			notifications := idledNotifications(t, fakeClients)
+			require.Len(t, notifications, 2)
+			for _, notification := range notifications {
+				assert.Regexp(t, `^alex-stage-idled(-\d+)?$`, notification.Name)
+			}
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				HasConditions(memberoperatortest.Running(), memberoperatortest.IdlerNotificationCreated())
+		})
+	})
+
+	t.Run("specific template is used only when all workloads share it", func(t *testing.T) {
+		// given
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler.DeepCopy(), nsTmplSet, mur)
+
+		// when
+		err := reconciler.createNotification(context.TODO(), idler.DeepCopy(), []idledWorkload{
+			{kind: "PipelineRun", name: "build", reason: idleReasonTimeout, template: pipelineRunCancelledTemplate},
+			{kind: "Deployment", name: "web", reason: idleReasonPending, template: appNotStartedTemplate},
+		})
+
+		// then
+		require.NoError(t, err)
+		notifications := idledNotifications(t, fakeClients)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, idlerTriggeredTemplate, notifications[0].Spec.Template)
+		assert.Equal(t, "PipelineRun/build (timeout), Deployment/web (pending)", notifications[0].Spec.Context["Workloads"])
+	})
+}
+
+var testIdledWorkloads = []idledWorkload{{kind: "testapptype", name: "testPodName", reason: idleReasonTimeout, template: idlerTriggeredTemplate}}
+
 func TestCreateNotification(t *testing.T) {
 	idler := &toolchainv1alpha1.Idler{
 		ObjectMeta: metav1.ObjectMeta{
@@ -669,7 +892,7 @@ func TestCreateNotification(t *testing.T) {
 		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
 
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 		//then
 		require.NoError(t, err)
 		require.True(t, condition.IsTrue(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated))
@@ -682,7 +905,7 @@ func TestCreateNotification(t *testing.T) {
 
 		t.Run("Notification not created if already sent", func(t *testing.T) {
 			//when
-			err = reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+			err = reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 			//then
 			require.NoError(t, err)
 			err = hostCl.Client.Get(context.TODO(), types.NamespacedName{Name: "alex-stage-idled", Namespace: hostCl.OperatorNamespace}, &notification)
@@ -707,7 +930,7 @@ func TestCreateNotification(t *testing.T) {
 		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
 
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 		//then
 		require.NoError(t, err)
 		require.True(t, condition.IsTrue(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated))
@@ -726,7 +949,7 @@ func TestCreateNotification(t *testing.T) {
 			return errors.New("can't update condition")
 		}
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 
 		//then
 		require.EqualError(t, err, "can't update condition")
@@ -737,25 +960,59 @@ func TestCreateNotification(t *testing.T) {
 
 		// second reconcile will not create the notification again but set the status
 		fakeClients.DefaultClient.MockStatusUpdate = nil
-		err = reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+		err = reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 		require.NoError(t, err)
 		require.True(t, condition.IsTrue(idler.Status.Conditions, toolchainv1alpha1.IdlerTriggeredNotificationCreated))
 	})
 
-	t.Run("Error in creating notification because MUR not found", func(t *testing.T) {
+	t.Run("Notification is queued because MUR not found", func(t *testing.T) {
 		idler.Status.Conditions = nil
 		namespaces := []string{"dev", "stage"}
 		usernames := []string{"alex"}
 		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet)
 
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
 		//then
-		require.EqualError(t, err, "could not get the MUR: masteruserrecords.toolchain.dev.openshift.com \"alex\" not found")
+		require.NoError(t, err)
+		// the notification is not reported as created (and the cooldown doesn't start) until it's delivered
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			ContainsCondition(toolchainv1alpha1.Condition{
+				Type:    toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+				Status:  corev1.ConditionFalse,
+				Reason:  IdlerNotificationQueuedReason,
+				Message: "the notification is queued until it can be delivered",
+			})
+		queued := queuedNotifications(t, fakeClients.DefaultClient)
+		require.Len(t, queued, 1)
+		assert.Equal(t, "could not get the MUR: masteruserrecords.toolchain.dev.openshift.com \"alex\" not found", queued["alex-stage-idled.alex"].LastError)
+
+		t.Run("queued notification is not sent again", func(t *testing.T) {
+			// when
+			err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, queued, queuedNotifications(t, fakeClients.DefaultClient))
+		})
+
+		t.Run("notification is reported as created once the outbox delivers it", func(t *testing.T) {
+			// given
+			require.NoError(t, fakeClients.DefaultClient.Create(context.TODO(), newMUR("alex")))
+
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), queued["alex-stage-idled.alex"].NextAttempt)
+
+			// then
+			require.NoError(t, err)
+			assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled", "alex@test.com")
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				ContainsCondition(memberoperatortest.IdlerNotificationCreated())
+		})
 	})
 
-	t.Run("Error in creating notification because no user email found in MUR", func(t *testing.T) {
+	t.Run("Notification is queued because no user email found in MUR", func(t *testing.T) {
 		// given
 		idler.Status.Conditions = nil
 		namespaces := []string{"dev", "stage"}
@@ -763,27 +1020,42 @@ func TestCreateNotification(t *testing.T) {
 		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
 		mur := newMUR("alex")
 		mur.Spec.PropagatedClaims.Email = ""
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
-		require.EqualError(t, err, "no email found for the user in MURs")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "no email found for the user in MUR", queuedNotifications(t, fakeClients.DefaultClient)["alex-stage-idled.alex"].LastError)
 	})
 
-	t.Run("Error in creating notification due to invalid email address", func(t *testing.T) {
+	t.Run("Notification is queued due to invalid email address", func(t *testing.T) {
 		idler.Status.Conditions = nil
 		namespaces := []string{"dev", "stage"}
 		usernames := []string{"alex"}
 		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
 		mur := newMUR("alex")
 		mur.Spec.PropagatedClaims.Email = "invalid-email-address"
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
 		//when
-		err := reconciler.createNotification(context.TODO(), idler, "testPodName", "testapptype")
-		require.EqualError(t, err, "unable to create Notification CR from Idler: The specified recipient [invalid-email-address] is not a valid email address: mail: missing '@' or angle-addr")
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "unable to create Notification CR from Idler: The specified recipient [invalid-email-address] is not a valid email address: mail: missing '@' or angle-addr",
+			queuedNotifications(t, fakeClients.DefaultClient)["alex-stage-idled.alex"].LastError)
+	})
+
+	t.Run("Error in creating notification because NSTemplateSet not found", func(t *testing.T) {
+		idler.Status.Conditions = nil
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		//when
+		err := reconciler.createNotification(context.TODO(), idler, testIdledWorkloads)
+		//then
+		require.EqualError(t, err, "nstemplatesets.toolchain.dev.openshift.com \"alex\" not found")
+		assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
 	})
 }
 
-func TestGetUserEmailFromMUR(t *testing.T) {
+func TestGetSpaceUsernames(t *testing.T) {
 	// given
 	idler := &toolchainv1alpha1.Idler{
 		ObjectMeta: metav1.ObjectMeta{
@@ -795,67 +1067,50 @@ func TestGetUserEmailFromMUR(t *testing.T) {
 		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
 	}
 
-	t.Run("Get user email when only space has only one user - DevSandbox", func(t *testing.T) {
+	t.Run("Get username when only space has only one user - DevSandbox", func(t *testing.T) {
 		//given
 		namespaces := []string{"dev", "stage"}
 		usernames := []string{"alex"}
 		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
-		mur := newMUR("alex")
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
-		hostCluster, _ := reconciler.GetHostCluster()
+		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet)
 		//when
-		emails, err := reconciler.getUserEmailsFromMURs(context.TODO(), hostCluster, idler)
+		spaceUsernames, err := reconciler.getSpaceUsernames(context.TODO(), idler)
 		//then
 		require.NoError(t, err)
-		require.NotEmpty(t, emails)
-		require.Len(t, emails, 1)
-		require.Equal(t, "alex@test.com", emails[0])
+		require.Equal(t, []string{"alex"}, spaceUsernames)
 	})
 
-	t.Run("Get user email when space has more than one user - AppStudio", func(t *testing.T) {
+	t.Run("Get usernames when space has more than one user - AppStudio", func(t *testing.T) {
 		//given
 		namespaces := []string{"dev", "stage"}
 		usernames := []string{"alex", "brian", "charlie"}
 		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
-		mur := newMUR("alex")
-		mur2 := newMUR("brian")
-		mur3 := newMUR("charlie")
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur, mur2, mur3)
-		hostCluster, _ := reconciler.GetHostCluster()
+		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet)
 		//when
-		emails, err := reconciler.getUserEmailsFromMURs(context.TODO(), hostCluster, idler)
+		spaceUsernames, err := reconciler.getSpaceUsernames(context.TODO(), idler)
 		//then
 		require.NoError(t, err)
-		require.NotEmpty(t, emails)
-		require.Len(t, emails, 3)
-		require.Contains(t, emails, "alex@test.com")
-		require.Contains(t, emails, "brian@test.com")
-		require.Contains(t, emails, "charlie@test.com")
+		require.Equal(t, []string{"alex", "brian", "charlie"}, spaceUsernames)
 	})
 
 	t.Run("unable to get NSTemplateSet", func(t *testing.T) {
 		//given
 		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler)
-		hostCluster, _ := reconciler.GetHostCluster()
 		//when
-		emails, err := reconciler.getUserEmailsFromMURs(context.TODO(), hostCluster, idler)
+		spaceUsernames, err := reconciler.getSpaceUsernames(context.TODO(), idler)
 		//then
 		require.EqualError(t, err, "nstemplatesets.toolchain.dev.openshift.com \"alex\" not found")
-		assert.Empty(t, emails)
+		assert.Empty(t, spaceUsernames)
 	})
 
-	t.Run("unable to get MUR, no error but no email found", func(t *testing.T) {
+	t.Run("no space label", func(t *testing.T) {
 		//given
-		namespaces := []string{"dev", "stage"}
-		usernames := []string{"alex"}
-		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", namespaces, usernames)
-		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet)
-		hostCluster, _ := reconciler.GetHostCluster()
+		reconciler, _, _ := prepareReconcile(t, "john-dev", getHostCluster)
 		//when
-		emails, err := reconciler.getUserEmailsFromMURs(context.TODO(), hostCluster, idler)
+		spaceUsernames, err := reconciler.getSpaceUsernames(context.TODO(), &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}})
 		//then
-		require.Error(t, err)
-		assert.Empty(t, emails)
+		require.NoError(t, err)
+		assert.Empty(t, spaceUsernames)
 	})
 }
 
@@ -1287,8 +1542,8 @@ func prepareReconcile(t *testing.T, name string, getHostClusterFunc func(fakeCli
 
 	fakeClient := test.NewFakeClient(t, initIdlerObjs...)
 	allNamespacesClient := test.NewFakeClient(t)
-	// Register custom list kinds for KServe resources
-	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, customListKinds)
+	// Register custom list kinds for KServe resources and the other unstructured owners
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), customListKinds)
 
 	fakeDiscovery := newFakeDiscoveryClient(allResourcesList(t)...)
 
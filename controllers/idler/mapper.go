@@ -17,3 +17,13 @@ func MapPodToIdler(_ context.Context, obj *v1.Pod) []reconcile.Request {
 		},
 	}}
 }
+
+// MapNamespaceToIdler maps the namespace to the idler
+func MapNamespaceToIdler(_ context.Context, obj *v1.Namespace) []reconcile.Request {
+	return []reconcile.Request{{
+		// the idler should have the same name as the user's namespace
+		NamespacedName: types.NamespacedName{
+			Name: obj.GetName(),
+		},
+	}}
+}
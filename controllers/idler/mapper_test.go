@@ -22,3 +22,16 @@ func TestMapper(t *testing.T) {
 	assert.Equal(t, "jane-dev", requests[0].Name)
 	assert.Empty(t, requests[0].Namespace)
 }
+
+func TestNamespaceMapper(t *testing.T) {
+	// given
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "jane-dev"}}
+
+	// when
+	requests := MapNamespaceToIdler(context.TODO(), namespace)
+
+	// then
+	require.Len(t, requests, 1)
+	assert.Equal(t, "jane-dev", requests[0].Name)
+	assert.Empty(t, requests[0].Namespace)
+}
@@ -0,0 +1,419 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	"github.com/codeready-toolchain/toolchain-common/pkg/cluster"
+	"github.com/codeready-toolchain/toolchain-common/pkg/condition"
+	notify "github.com/codeready-toolchain/toolchain-common/pkg/notification"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// notificationOutboxName is the name of the ConfigMap in the operator namespace which keeps the notifications
+	// that couldn't be delivered to the host cluster yet
+	notificationOutboxName = "idler-notification-outbox"
+
+	outboxRetryPeriod    = 30 * time.Second
+	outboxInitialBackoff = 30 * time.Second
+	outboxMaxBackoff     = time.Hour
+	// outboxMaxAttempts is the number of delivery attempts after which the notification is dropped (roughly one day with the backoff above)
+	outboxMaxAttempts = 30
+	// outboxMaxSize is the maximum size of the data of the outbox ConfigMap in bytes. It's well below the 1 MiB limit of the ConfigMaps,
+	// so the queued notifications can still be rescheduled with a longer error. The notifications which don't fit are dropped.
+	outboxMaxSize = 512 * 1024
+
+	// outboxDeliveredRetention is how long the events delivered to the webhooks are kept in the outbox, so they are not posted again
+	// when the same notification is sent again (eg. when the update of the Idler status failed after the notification was sent)
+	outboxDeliveredRetention = 24 * time.Hour
+
+	outboxDropReasonFull        = "outbox_full"
+	outboxDropReasonMaxAttempts = "max_attempts"
+	outboxDropReasonUnknownSink = "unknown_sink"
+)
+
+var errUnknownWebhookSink = errors.New("the webhook sink is not configured")
+
+// notificationDelivery is the result of sending a notification
+type notificationDelivery int
+
+const (
+	// notificationDelivered means that the notification was delivered to all its recipients
+	notificationDelivered notificationDelivery = iota
+	// notificationQueued means that the notification couldn't be delivered to some of its recipients and was queued in the outbox,
+	// which delivers it later
+	notificationQueued
+)
+
+// outboxEntry is a notification for a single recipient (or an event for a single webhook sink), as it's stored in the outbox
+type outboxEntry struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Template string            `json:"template,omitempty"`
+	Context  map[string]string `json:"context,omitempty"`
+	Username string            `json:"username,omitempty"`
+	// Idler is the name of the Idler which sent the notification, the IdlerTriggeredNotificationCreated condition of the Idler
+	// is set once the queued notification of the idled workloads is delivered to all its recipients
+	Idler string `json:"idler,omitempty"`
+	// Sink is the name of the webhook sink the Event is posted to, the notification is delivered to the host cluster when not set
+	Sink  string             `json:"sink,omitempty"`
+	Event *notificationEvent `json:"event,omitempty"`
+	// Delivered is true for the events which were already posted to the webhook sink, NextAttempt is the time when they are removed then
+	Delivered   bool      `json:"delivered,omitempty"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+func (e outboxEntry) key() string {
+	if e.Sink != "" {
+		return fmt.Sprintf("webhook.%s.%s", e.Sink, e.Name)
+	}
+	return fmt.Sprintf("%s.%s", e.Name, e.Username)
+}
+
+// notificationOutbox delivers the notifications to the host cluster and the events to the webhook sinks. The notifications which
+// can't be delivered to the host cluster (eg. because the host cluster is not available or the MUR of the recipient can't be fetched)
+// and all the events for the webhook sinks are queued in a ConfigMap, so they survive the restarts of the operator and the webhooks
+// are not called during the reconcile. The delivery of the queued entries is retried with an exponential backoff.
+// Every recipient and every webhook sink is delivered independently.
+type notificationOutbox struct {
+	client         client.Client
+	namespace      string
+	getHostCluster cluster.GetHostClusterFunc
+	getConfig      func() Config
+}
+
+func (r *Reconciler) notificationOutbox() *notificationOutbox {
+	return &notificationOutbox{client: r.Client, namespace: r.Namespace, getHostCluster: r.GetHostCluster, getConfig: r.GetConfig}
+}
+
+// Start retries the delivery of the queued notifications periodically until the context is done. It implements manager.Runnable.
+func (o *notificationOutbox) Start(ctx context.Context) error {
+	ticker := time.NewTicker(outboxRetryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := o.retry(ctx, now); err != nil {
+				log.FromContext(ctx).Error(err, "failed to retry the delivery of the queued notifications")
+			}
+		}
+	}
+}
+
+// send delivers the given notifications and queues the ones which couldn't be delivered. Returns notificationQueued
+// if some of them were queued, and an error only if the undelivered notifications couldn't be queued.
+func (o *notificationOutbox) send(ctx context.Context, entries []outboxEntry) (notificationDelivery, error) {
+	logger := log.FromContext(ctx)
+	var undelivered []outboxEntry
+	now := time.Now()
+	for _, entry := range entries {
+		if err := o.deliver(ctx, entry); err != nil {
+			logger.Error(err, "failed to deliver the notification, queueing it", "notification", entry.Name, "username", entry.Username)
+			entry.Attempts = 1
+			entry.LastError = err.Error()
+			entry.NextAttempt = now.Add(outboxBackoff(entry.Attempts))
+			undelivered = append(undelivered, entry)
+		}
+	}
+	if len(undelivered) == 0 {
+		return notificationDelivered, nil
+	}
+	return notificationQueued, o.enqueue(ctx, undelivered)
+}
+
+// enqueue adds the given entries to the outbox, unless they are already there (queued or delivered to the webhook sink).
+// The entries which don't fit in the outbox are dropped, and an error is returned then.
+func (o *notificationOutbox) enqueue(ctx context.Context, entries []outboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var dropped []outboxEntry
+	err := o.update(ctx, func(data map[string]string) error {
+		dropped = nil
+		size := outboxSize(data)
+		for _, entry := range entries {
+			if _, queued := data[entry.key()]; queued {
+				// keep the backoff of the notification which is already queued
+				continue
+			}
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if size += len(entry.key()) + len(value); size > outboxMaxSize {
+				dropped = append(dropped, entry)
+				continue
+			}
+			data[entry.key()] = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range dropped {
+		log.FromContext(ctx).Info("The outbox is full, dropping the notification", "notification", entry.Name, "username", entry.Username, "sink", entry.Sink)
+		metrics.IdlerNotificationsDroppedCounterVec.WithLabelValues(entry.Type, outboxDropReasonFull).Inc()
+	}
+	if len(dropped) > 0 {
+		return fmt.Errorf("the notification outbox is full, %d notification(s) dropped", len(dropped))
+	}
+	return nil
+}
+
+// outboxSize returns the size of the data of the outbox in bytes
+func outboxSize(data map[string]string) int {
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// retry delivers the queued notifications which are due. The delivered notifications and the ones which reached
+// the maximum number of attempts are removed from the outbox, the others are rescheduled. The events delivered
+// to the webhook sinks are kept in the outbox as delivered until the retention period elapses.
+func (o *notificationOutbox) retry(ctx context.Context, now time.Time) error {
+	logger := log.FromContext(ctx)
+	outbox := &corev1.ConfigMap{}
+	if err := o.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: notificationOutboxName}, outbox); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var removed []string
+	rescheduled := map[string]string{}
+	// the queued notifications of the idled workloads which were delivered or dropped, by the name of their Idler
+	delivered := map[string]bool{}
+	dropped := map[string]string{}
+	for key, value := range outbox.Data {
+		entry := outboxEntry{}
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			logger.Error(err, "dropping the invalid notification from the outbox", "key", key)
+			removed = append(removed, key)
+			continue
+		}
+		if entry.NextAttempt.After(now) {
+			continue
+		}
+		if entry.Delivered {
+			removed = append(removed, key)
+			continue
+		}
+		if err := o.deliver(ctx, entry); err != nil {
+			if errors.Is(err, errUnknownWebhookSink) {
+				logger.Info("dropping the event for the webhook sink which is not configured anymore", "notification", entry.Name, "sink", entry.Sink)
+				metrics.IdlerNotificationsDroppedCounterVec.WithLabelValues(entry.Type, outboxDropReasonUnknownSink).Inc()
+				removed = append(removed, key)
+				continue
+			}
+			entry.Attempts++
+			entry.LastError = err.Error()
+			if entry.Attempts >= outboxMaxAttempts {
+				logger.Error(err, "dropping the notification which couldn't be delivered", "notification", entry.Name, "username", entry.Username, "sink", entry.Sink, "attempts", entry.Attempts)
+				metrics.IdlerNotificationsDroppedCounterVec.WithLabelValues(entry.Type, outboxDropReasonMaxAttempts).Inc()
+				removed = append(removed, key)
+				if entry.notifiesIdledWorkloads() {
+					dropped[entry.Idler] = err.Error()
+				}
+				continue
+			}
+			entry.NextAttempt = now.Add(outboxBackoff(entry.Attempts))
+			updated, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			rescheduled[key] = string(updated)
+			continue
+		}
+		logger.Info("Queued notification delivered", "notification", entry.Name, "username", entry.Username, "sink", entry.Sink, "attempts", entry.Attempts+1)
+		if entry.Sink == "" {
+			removed = append(removed, key)
+			if entry.notifiesIdledWorkloads() {
+				delivered[entry.Idler] = true
+			}
+			continue
+		}
+		// only the name is kept, so the event is not posted again
+		deliveredEntry, err := json.Marshal(outboxEntry{Name: entry.Name, Type: entry.Type, Sink: entry.Sink, Delivered: true, NextAttempt: now.Add(outboxDeliveredRetention)})
+		if err != nil {
+			return err
+		}
+		rescheduled[key] = string(deliveredEntry)
+	}
+	if len(removed) == 0 && len(rescheduled) == 0 {
+		return nil
+	}
+	var pending map[string]bool
+	err := o.update(ctx, func(data map[string]string) error {
+		for _, key := range removed {
+			delete(data, key)
+		}
+		for key, value := range rescheduled {
+			if _, found := data[key]; found {
+				data[key] = value
+			}
+		}
+		pending = idlersWithQueuedNotifications(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the condition of the Idler is set once the notification is delivered to all recipients, or reset if it was dropped for any of them
+	for idlerName, lastError := range dropped {
+		o.updateIdlerCondition(ctx, idlerName, toolchainv1alpha1.Condition{
+			Type:    toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+			Status:  corev1.ConditionFalse,
+			Reason:  toolchainv1alpha1.IdlerTriggeredNotificationCreationFailedReason,
+			Message: lastError,
+		})
+	}
+	for idlerName := range delivered {
+		if pending[idlerName] || dropped[idlerName] != "" {
+			continue
+		}
+		o.updateIdlerCondition(ctx, idlerName, toolchainv1alpha1.Condition{
+			Type:   toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+			Status: corev1.ConditionTrue,
+			Reason: toolchainv1alpha1.IdlerTriggeredReason,
+		})
+	}
+	return nil
+}
+
+// notifiesIdledWorkloads returns true if the entry is the notification of the workloads idled by an Idler
+func (e outboxEntry) notifiesIdledWorkloads() bool {
+	return e.Sink == "" && e.Idler != "" && e.Type == toolchainv1alpha1.NotificationTypeIdled
+}
+
+// idlersWithQueuedNotifications returns the names of the Idlers with a notification of the idled workloads still queued in the given outbox data
+func idlersWithQueuedNotifications(data map[string]string) map[string]bool {
+	idlers := map[string]bool{}
+	for _, value := range data {
+		entry := outboxEntry{}
+		if err := json.Unmarshal([]byte(value), &entry); err == nil && entry.notifiesIdledWorkloads() {
+			idlers[entry.Idler] = true
+		}
+	}
+	return idlers
+}
+
+// updateIdlerCondition sets the given condition of the Idler with the given name. The failures are only logged,
+// and the Idlers which don't exist anymore are ignored.
+func (o *notificationOutbox) updateIdlerCondition(ctx context.Context, idlerName string, cond toolchainv1alpha1.Condition) {
+	idler := &toolchainv1alpha1.Idler{}
+	if err := o.client.Get(ctx, types.NamespacedName{Name: idlerName}, idler); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "failed to get the Idler to update its notification status", "idler_name", idlerName)
+		}
+		return
+	}
+	var updated bool
+	if idler.Status.Conditions, updated = condition.AddOrUpdateStatusConditions(idler.Status.Conditions, cond); !updated {
+		return
+	}
+	if err := o.client.Status().Update(ctx, idler); err != nil {
+		log.FromContext(ctx).Error(err, "failed to update the notification status of the Idler", "idler_name", idlerName)
+	}
+}
+
+// deliver posts the event to the webhook sink of the entry, or creates the Notification CR in the host cluster for the email
+// of the recipient. The notification which already exists is considered as delivered.
+func (o *notificationOutbox) deliver(ctx context.Context, entry outboxEntry) error {
+	if entry.Sink != "" {
+		return o.deliverToWebhook(ctx, entry)
+	}
+	hostCluster, ok := o.getHostCluster()
+	if !ok {
+		return fmt.Errorf("unable to get the host cluster")
+	}
+	mur := &toolchainv1alpha1.MasterUserRecord{}
+	if err := hostCluster.Client.Get(ctx, types.NamespacedName{Name: entry.Username, Namespace: hostCluster.OperatorNamespace}, mur); err != nil {
+		return fmt.Errorf("could not get the MUR: %w", err)
+	}
+	email := mur.Spec.PropagatedClaims.Email
+	if email == "" {
+		return fmt.Errorf("no email found for the user in MUR")
+	}
+	_, err := notify.NewNotificationBuilder(hostCluster.Client, hostCluster.OperatorNamespace).
+		WithName(entry.Name).
+		WithNotificationType(entry.Type).
+		WithTemplate(entry.Template).
+		WithKeysAndValues(entry.Context).
+		Create(ctx, email)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create Notification CR from Idler: %w", err)
+	}
+	return nil
+}
+
+// deliverToWebhook posts the event of the entry to the configured webhook sink of the entry
+func (o *notificationOutbox) deliverToWebhook(ctx context.Context, entry outboxEntry) error {
+	if entry.Event == nil {
+		return fmt.Errorf("no event to be posted to the webhook")
+	}
+	for _, spec := range configOrDefault(o.getConfig).WebhookSinks() {
+		if spec.Name == entry.Sink {
+			sink := &webhookNotificationSink{client: o.client, namespace: o.namespace, spec: spec}
+			return sink.send(ctx, *entry.Event)
+		}
+	}
+	return errUnknownWebhookSink
+}
+
+// update applies the given change to the data of the outbox ConfigMap, which is created if it doesn't exist yet
+func (o *notificationOutbox) update(ctx context.Context, change func(data map[string]string) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		outbox := &corev1.ConfigMap{}
+		err := o.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: notificationOutboxName}, outbox)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		exists := err == nil
+		if outbox.Data == nil {
+			outbox.Data = map[string]string{}
+		}
+		if err := change(outbox.Data); err != nil {
+			return err
+		}
+		if exists {
+			return o.client.Update(ctx, outbox)
+		}
+		outbox.ObjectMeta = metav1.ObjectMeta{Namespace: o.namespace, Name: notificationOutboxName}
+		if err := o.client.Create(ctx, outbox); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently, retry the update
+				return apierrors.NewConflict(corev1.Resource("configmaps"), notificationOutboxName, err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// outboxBackoff returns the delay before the next delivery attempt after the given number of failed attempts
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxInitialBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
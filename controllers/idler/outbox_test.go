@@ -0,0 +1,254 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/cluster"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestNotificationOutbox(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+	}
+	keysAndVals := map[string]string{"Namespace": idler.Name}
+
+	t.Run("every user of the space is notified independently", func(t *testing.T) {
+		// given
+		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex", "brian", "charlie"})
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, newMUR("alex"), newMUR("charlie"))
+		now := time.Now()
+
+		// when
+		delivery, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, notificationQueued, delivery)
+		assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled-alex", "alex@test.com")
+		assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled-charlie", "charlie@test.com")
+		queued := queuedNotifications(t, fakeClients.DefaultClient)
+		require.Len(t, queued, 1)
+		entry := queued["alex-stage-idled-brian.brian"]
+		assert.Equal(t, "brian", entry.Username)
+		assert.Equal(t, idler.Name, entry.Idler)
+		assert.Equal(t, idlerTriggeredTemplate, entry.Template)
+		assert.Equal(t, keysAndVals, entry.Context)
+		assert.Equal(t, 1, entry.Attempts)
+		assert.WithinDuration(t, now.Add(outboxInitialBackoff), entry.NextAttempt, 5*time.Second)
+		assert.Equal(t, "could not get the MUR: masteruserrecords.toolchain.dev.openshift.com \"brian\" not found", entry.LastError)
+
+		t.Run("queued notification is not retried before the backoff", func(t *testing.T) {
+			// given
+			require.NoError(t, fakeClients.DefaultClient.Create(context.TODO(), newMUR("brian")))
+
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), now)
+
+			// then
+			require.NoError(t, err)
+			assert.Len(t, queuedNotifications(t, fakeClients.DefaultClient), 1)
+			assertNotificationNotSent(t, fakeClients.DefaultClient, "alex-stage-idled-brian")
+		})
+
+		t.Run("queued notification is delivered after the backoff", func(t *testing.T) {
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), now.Add(outboxInitialBackoff+10*time.Second))
+
+			// then
+			require.NoError(t, err)
+			assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+			assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled-brian", "brian@test.com")
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				ContainsCondition(memberoperatortest.IdlerNotificationCreated())
+		})
+	})
+
+	t.Run("notifications are queued while the host cluster is not available", func(t *testing.T) {
+		// given
+		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, newMUR("alex"))
+		getAvailableHostCluster := reconciler.GetHostCluster
+		reconciler.GetHostCluster = func() (*cluster.CachedToolchainCluster, bool) {
+			return nil, false
+		}
+
+		// when
+		delivery, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, notificationQueued, delivery)
+		queued := queuedNotifications(t, fakeClients.DefaultClient)
+		require.Len(t, queued, 1)
+		assert.Equal(t, "unable to get the host cluster", queued["alex-stage-idled.alex"].LastError)
+
+		t.Run("notification queued again keeps its backoff", func(t *testing.T) {
+			// when
+			_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, queued, queuedNotifications(t, fakeClients.DefaultClient))
+		})
+
+		t.Run("backoff is increased when the delivery fails again", func(t *testing.T) {
+			// given
+			now := queued["alex-stage-idled.alex"].NextAttempt
+
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), now)
+
+			// then
+			require.NoError(t, err)
+			entry := queuedNotifications(t, fakeClients.DefaultClient)["alex-stage-idled.alex"]
+			assert.Equal(t, 2, entry.Attempts)
+			assert.True(t, now.Add(2*outboxInitialBackoff).Equal(entry.NextAttempt))
+
+			t.Run("notification is delivered when the host cluster is available again", func(t *testing.T) {
+				// given
+				reconciler.GetHostCluster = getAvailableHostCluster
+
+				// when
+				err := reconciler.notificationOutbox().retry(context.TODO(), entry.NextAttempt)
+
+				// then
+				require.NoError(t, err)
+				assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+				assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled", "alex@test.com")
+			})
+		})
+	})
+
+	t.Run("notification is dropped when the outbox is full", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, newMUR("alex"))
+		reconciler.GetHostCluster = func() (*cluster.CachedToolchainCluster, bool) {
+			return nil, false
+		}
+		outbox := reconciler.notificationOutbox()
+		require.NoError(t, outbox.update(context.TODO(), func(data map[string]string) error {
+			data["queued"] = strings.Repeat("x", outboxMaxSize-100)
+			return nil
+		}))
+
+		// when
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+		// then
+		require.EqualError(t, err, "the notification outbox is full, 1 notification(s) dropped")
+		outboxConfigMap := &corev1.ConfigMap{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Namespace: test.MemberOperatorNs, Name: notificationOutboxName}, outboxConfigMap))
+		assert.Len(t, outboxConfigMap.Data, 1)
+		assert.Contains(t, outboxConfigMap.Data, "queued")
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerNotificationsDroppedCounterVec.WithLabelValues(toolchainv1alpha1.NotificationTypeIdled, outboxDropReasonFull)), 0.01)
+	})
+
+	t.Run("notification is dropped after the maximum number of attempts", func(t *testing.T) {
+		// given
+		metrics.Reset()
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		outbox := reconciler.notificationOutbox()
+		entry := outboxEntry{Name: "alex-stage-idled", Type: toolchainv1alpha1.NotificationTypeIdled, Template: idlerTriggeredTemplate, Username: "alex", Idler: idler.Name, Attempts: outboxMaxAttempts - 1, LastError: "unable to get the host cluster"}
+		require.NoError(t, outbox.update(context.TODO(), func(data map[string]string) error {
+			value, err := json.Marshal(entry)
+			data[entry.key()] = string(value)
+			return err
+		}))
+
+		// when
+		err := outbox.retry(context.TODO(), time.Now())
+
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+		assertNotificationNotSent(t, fakeClients.DefaultClient, "alex-stage-idled")
+		assert.InDelta(t, float64(1), promtestutil.ToFloat64(metrics.IdlerNotificationsDroppedCounterVec.WithLabelValues(toolchainv1alpha1.NotificationTypeIdled, outboxDropReasonMaxAttempts)), 0.01)
+		// the notification is sent again in the next idling cycle
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			ContainsCondition(toolchainv1alpha1.Condition{
+				Type:    toolchainv1alpha1.IdlerTriggeredNotificationCreated,
+				Status:  corev1.ConditionFalse,
+				Reason:  toolchainv1alpha1.IdlerTriggeredNotificationCreationFailedReason,
+				Message: "could not get the MUR: masteruserrecords.toolchain.dev.openshift.com \"alex\" not found",
+			})
+	})
+
+	t.Run("nothing to retry without the outbox", func(t *testing.T) {
+		// given
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+
+		// when
+		err := reconciler.notificationOutbox().retry(context.TODO(), time.Now())
+
+		// then
+		require.NoError(t, err)
+		err = fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Namespace: test.MemberOperatorNs, Name: notificationOutboxName}, &corev1.ConfigMap{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:                 30 * time.Second,
+		2:                 time.Minute,
+		3:                 2 * time.Minute,
+		7:                 32 * time.Minute,
+		8:                 time.Hour,
+		outboxMaxAttempts: time.Hour,
+	} {
+		assert.Equal(t, expected, outboxBackoff(attempts), "attempts: %d", attempts)
+	}
+}
+
+// queuedNotifications returns the notifications queued in the outbox, by their keys
+func queuedNotifications(t *testing.T, cl client.Client) map[string]outboxEntry {
+	outbox := &corev1.ConfigMap{}
+	err := cl.Get(context.TODO(), types.NamespacedName{Namespace: test.MemberOperatorNs, Name: notificationOutboxName}, outbox)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	entries := map[string]outboxEntry{}
+	for key, value := range outbox.Data {
+		entry := outboxEntry{}
+		require.NoError(t, json.Unmarshal([]byte(value), &entry))
+		entries[key] = entry
+	}
+	return entries
+}
+
+func assertNotificationSent(t *testing.T, cl client.Client, name, recipient string) {
+	notification := &toolchainv1alpha1.Notification{}
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: test.HostOperatorNs, Name: name}, notification))
+	assert.Equal(t, recipient, notification.Spec.Recipient)
+}
+
+func assertNotificationNotSent(t *testing.T, cl client.Client, name string) {
+	err := cl.Get(context.TODO(), types.NamespacedName{Namespace: test.HostOperatorNs, Name: name}, &toolchainv1alpha1.Notification{})
+	assert.True(t, apierrors.IsNotFound(err), "notification %s should not exist", name)
+}
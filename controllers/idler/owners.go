@@ -4,18 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -25,24 +27,107 @@ type ownerIdler struct {
 	dynamicClient dynamic.Interface
 	scalesClient  scale.ScalesGetter
 	restClient    rest.Interface
+	activity      *activityTracker
+	idleActions   *idleActionTracker
+	dryRunActions *dryRunTracker
+	strategies    idlingStrategies
+	dryRun        bool
+	config        Config
+	// processed contains the owners which were already idled (or reported in the dry-run mode), so every owner is processed only once per reconcile
+	processed map[string]bool
+	// fightingOwners contains the kinds and names of the owners which were found fighting the idler in this reconcile
+	fightingOwners map[string]bool
+	// idledWorkloads contains the workloads idled in this reconcile, which are listed in the notification
+	idledWorkloads []idledWorkload
+	// crashLoops contains the workloads idled in this reconcile because their pods were crash-looping, which are notified separately
+	crashLoops []crashLoopWorkload
+	// keptScaledDown contains the kinds and names of the owners marked in this reconcile to be kept scaled down because of crash-looping pods
+	keptScaledDown map[string]bool
+	// idledResources contains the resources of the unknown kinds idled using the scale subresource in this reconcile,
+	// they are recorded on the Idler so only these resources are listed when the namespace is un-idled
+	idledResources map[schema.GroupVersionKind]schema.GroupVersionResource
+	// runningWorkloads contains the running workloads with their projected idle time, which are listed in the idler report
+	runningWorkloads []reportedRunningWorkload
+	// recorder records the Events on the idled owners, if set
+	recorder record.EventRecorder
 }
 
 func newOwnerIdler(idler *toolchainv1alpha1.Idler, reconciler *Reconciler) *ownerIdler {
 	return &ownerIdler{
 		idler:         idler,
-		ownerFetcher:  newOwnerFetcher(reconciler.DiscoveryClient, reconciler.DynamicClient),
+		ownerFetcher:  reconciler.newOwnerFetcher(),
 		dynamicClient: reconciler.DynamicClient,
 		scalesClient:  reconciler.ScalesClient,
 		restClient:    reconciler.RestClient,
+		activity:      &reconciler.activity,
+		idleActions:   &reconciler.idleActions,
+		dryRunActions: &reconciler.dryRunActions,
+		strategies:    reconciler.config().IdlingStrategies(),
+		dryRun:        reconciler.isDryRun(idler),
+		config:        reconciler.config(),
+		recorder:      reconciler.Recorder,
 	}
 }
 
+// newOwnerFetcher returns a new ownerFetcher sharing the discovery cache of the Reconciler
+func (r *Reconciler) newOwnerFetcher() *ownerFetcher {
+	fetcher := newOwnerFetcher(r.DiscoveryClient, r.DynamicClient)
+	fetcher.discoveryCache = &r.discoveryCache
+	return fetcher
+}
+
+// timeout returns the idler timeout in seconds for the given pod. The timeout is multiplied by the multiplier configured
+// for the kind of the first owner of the pod (walking up from the pod) that has one.
+func (i *ownerIdler) timeout(ctx context.Context, pod *corev1.Pod) int32 {
+	timeoutSeconds := i.idler.Spec.TimeoutSeconds
+	if multiplier, found := i.timeoutMultiplier(ctx, pod); found {
+		return int32(math.Round(float64(timeoutSeconds) * multiplier))
+	}
+	return timeoutSeconds
+}
+
+func (i *ownerIdler) timeoutMultiplier(ctx context.Context, pod *corev1.Pod) (float64, bool) {
+	multipliers := i.config.TimeoutMultipliers()
+	// check the controller owner reference first, so the owners don't have to be fetched for the default multipliers
+	for _, owner := range pod.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller {
+			if multiplier, found := multipliers[owner.Kind]; found {
+				return multiplier, true
+			}
+		}
+	}
+	if len(i.config.spec.TimeoutMultipliers) == 0 {
+		return 0, false
+	}
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to find all owners, use the information that is available to determine the timeout")
+	}
+	// the owners are ordered from the top one
+	for index := len(owners) - 1; index >= 0; index-- {
+		if multiplier, found := multipliers[owners[index].object.GetKind()]; found {
+			return multiplier, true
+		}
+	}
+	return 0, false
+}
+
+// escalationTimeout returns the time after which the next known owner of the pod is idled as well
+func (i *ownerIdler) escalationTimeout(timeoutSeconds int32) time.Duration {
+	return time.Duration(timeoutSeconds) * time.Second * time.Duration(i.config.EscalationPercentage()) / 100
+}
+
 // scaleOwnerToZero fetches the whole tree of the controller owners from the provided pod.
-// If any known controller owner is found, then it's scaled down (or deleted) and its kind and name is returned.
-// If the pod has been running for longer than 105% of the idler timeout, it will also idle the second known owner.
+// If any known controller owner is found, then it's idled using the idling strategy for its kind and its kind and name is returned.
+// If the pod has been running (or idle, when the activity is tracked) for longer than the escalation percentage (105% by default) of the idler timeout,
+// it will also idle the second known owner.
 // This is a workaround for cases when the top owner controller fails to idle the workload. For example the AAP controller sometimes fails to scale down StatefulSets for postgres pods owned by the top AAP CR. Scaling down the StatefulSet (AAP -> StatefulSet -> Pods) mitigates that AAP controller bug.
+// The next known owner is idled as well if the first one doesn't stop its running workloads when idled (eg. a suspended CronJob and its running Job),
+// or if the first one is fighting the idler, ie. it's scaled up again by another controller right after being idled.
+// The state of every idled owner before idling is recorded in its annotations together with the given reason, so it can be restored later.
+// Every owner is idled only once per reconcile, even if it owns multiple pods.
 // Otherwise, returns empty strings.
-func (i *ownerIdler) scaleOwnerToZero(ctx context.Context, pod *corev1.Pod) (string, string, error) {
+func (i *ownerIdler) scaleOwnerToZero(ctx context.Context, pod *corev1.Pod, reason idleReason) (string, string, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Scaling owner to zero")
 
@@ -57,21 +142,55 @@ func (i *ownerIdler) scaleOwnerToZero(ctx context.Context, pod *corev1.Pod) (str
 		owner := ownerWithGVR.object
 		ownerKind := owner.GetObjectKind().GroupVersionKind().Kind
 
-		switch ownerKind {
-		case "Deployment", "ReplicaSet", "Integration", "KameletBinding", "StatefulSet", "ReplicationController":
-			err = i.scaleToZero(ctx, ownerWithGVR)
-		case "DaemonSet", "Job":
-			err = i.deleteResource(ctx, ownerWithGVR) // Nothing to scale down. Delete instead.
-		case "DeploymentConfig":
-			err = i.scaleDeploymentConfigToZero(ctx, ownerWithGVR)
-		case "VirtualMachine":
-			err = i.stopVirtualMachine(ctx, ownerWithGVR) // Nothing to scale down. Stop instead.
-		case "AnsibleAutomationPlatform":
-			err = i.idleAAP(ctx, ownerWithGVR) // Nothing to scale down. Stop instead.
-		case "ServingRuntime":
-			err = i.idleServingRuntime(ctx, ownerWithGVR) // Idle by deleting old InferenceService objects.
+		idle := i.idleFuncFor(ownerWithGVR)
+		if idle == nil {
+			continue // Skip unknown owner types which can't be scaled
+		}
+		strategy, _ := i.strategyFor(ownerWithGVR)
+		key := ownerKey(ownerWithGVR)
+		switch {
+		case i.processed[key]:
+			err = nil // already idled when processing another pod of the same owner
+		case i.dryRun:
+			err = nil
+			i.reportDryRunAction(ctx, pod, ownerKind, owner.GetName(), string(strategy.Type), reason)
 		default:
-			continue // Skip unknown owner types
+			var paused *pausedAutoscalers
+			if strategy.setsReplicas() {
+				// the autoscalers are paused first, so they can't scale the owner up again right after it's idled
+				var pauseErr error
+				if paused, pauseErr = i.pauseAutoscalers(ctx, ownerWithGVR, reason); pauseErr != nil {
+					// not returning the error, the owner is idled anyway
+					logger.Error(pauseErr, "failed to pause the autoscalers of the owner", "kind", ownerKind, "name", owner.GetName())
+				}
+			}
+			err = idle(ctx, ownerWithGVR)
+			if err != nil {
+				metrics.IdlerIdlingFailuresCounterVec.WithLabelValues(ownerKind).Inc()
+				if paused != nil {
+					if resumeErr := i.resumePausedAutoscalers(ctx, ownerWithGVR, paused); resumeErr != nil {
+						logger.Error(resumeErr, "failed to resume the autoscalers of the owner which couldn't be idled", "kind", ownerKind, "name", owner.GetName())
+					}
+				}
+				break
+			}
+			metrics.IdlerWorkloadsIdledCounterVec.WithLabelValues(ownerKind, string(reason)).Inc()
+			i.recordEvent(owner, corev1.EventTypeNormal, IdledEventReason, "%s %s was idled (reason: %s)", ownerKind, owner.GetName(), reason)
+			if recordErr := i.recordStateBeforeIdling(ctx, ownerWithGVR, reason); recordErr != nil {
+				// not returning the error, the owner is already idled
+				logger.Error(recordErr, "failed to record the state of the owner before idling", "kind", ownerKind, "name", owner.GetName())
+			}
+			if _, configured := i.strategies.get(strategy.groupVersionKind()); !configured && strategy.Type == ScaleSubresourceStrategy {
+				if i.idledResources == nil {
+					i.idledResources = map[schema.GroupVersionKind]schema.GroupVersionResource{}
+				}
+				i.idledResources[strategy.groupVersionKind()] = *ownerWithGVR.gvr
+			}
+		}
+		fighting := false
+		if err == nil {
+			i.markProcessed(key)
+			fighting = !i.dryRun && i.fightingIdler(ownerWithGVR)
 		}
 
 		// Store the first processed owner's info and preserve its error
@@ -85,197 +204,89 @@ func (i *ownerIdler) scaleOwnerToZero(ctx context.Context, pod *corev1.Pod) (str
 			break
 		}
 
-		// If no error occurred and the pod doesn't run for longer than 105% of the idler timeout, return immediately after the first owner was idled
-		timeoutSeconds := getTimeout(i.idler, *pod)
-		if err == nil && !time.Now().After(pod.Status.StartTime.Add(time.Duration(float64(timeoutSeconds)*1.05)*time.Second)) {
+		// If no error occurred and the pod doesn't run for longer than the escalation percentage of the idler timeout, return immediately after the first owner was idled
+		// (the pods idled in the idling window might not have been started yet), unless the owner doesn't stop its running workloads when idled
+		// or is fighting the idler
+		if err == nil && strategy.stopsRunningWorkloads() && !fighting && (pod.Status.StartTime == nil || !time.Now().After(i.idleSince(ctx, pod).Add(i.escalationTimeout(i.timeout(ctx, pod))))) {
 			return topOwnerKind, topOwnerName, nil
 		}
-		logger.Info("Scaling the first known owner down either failed or the pod has been running for longer than 105% of the idler timeout. Scaling the next known owner.")
+		logger.Info("Scaling the first known owner down either failed or the pod has been running for longer than the escalation percentage of the idler timeout. Scaling the next known owner.", "escalation_percentage", i.config.EscalationPercentage())
 	}
 
 	// Return the first processed owner's info (or empty if none were processed), and the list of errors (if any happened)
 	return topOwnerKind, topOwnerName, errToReturn
 }
 
-var supportedScaleResources = map[schema.GroupVersionKind]schema.GroupVersionResource{
-	schema.GroupVersion{Group: "camel.apache.org", Version: "v1"}.WithKind("Integration"):          schema.GroupVersion{Group: "camel.apache.org", Version: "v1"}.WithResource("integrations"),
-	schema.GroupVersion{Group: "camel.apache.org", Version: "v1alpha1"}.WithKind("KameletBinding"): schema.GroupVersion{Group: "camel.apache.org", Version: "v1alpha1"}.WithResource("kameletbindings"),
+// ownerKey returns the key identifying the owner among all processed owners
+func ownerKey(owner *objectWithGVR) string {
+	return fmt.Sprintf("%s/%s", owner.gvr.String(), owner.object.GetName())
 }
 
-func (i *ownerIdler) scaleToZero(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	object := objectWithGVR.object
-	logger := log.FromContext(ctx).WithValues("kind", object.GetObjectKind().GroupVersionKind().Kind, "name", object.GetName())
-	logger.Info("Scaling controller owner to zero")
-
-	patch := []byte(`{"spec":{"replicas":0}}`)
-	for _, groupVersionResource := range supportedScaleResources {
-		if groupVersionResource.String() == objectWithGVR.gvr.String() {
-			logger.Info("Scaling controller owner to zero using the scale subresource")
-			_, err := i.scalesClient.Scales(object.GetNamespace()).Patch(ctx, *objectWithGVR.gvr, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
-			if err != nil {
-				return err
-			}
-			logger.Info("Controller owner scaled to zero using the scale subresource")
-			return nil
-		}
+func (i *ownerIdler) markProcessed(key string) {
+	if i.processed == nil {
+		i.processed = map[string]bool{}
 	}
-
-	_, err := i.dynamicClient.
-		Resource(*objectWithGVR.gvr).
-		Namespace(object.GetNamespace()).
-		Patch(ctx, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
-	if err != nil {
-		return err
-	}
-
-	logger.Info("Controller owner scaled to zero")
-	return nil
-}
-
-// idleAAP idles AAP instance if not already idled
-func (i *ownerIdler) idleAAP(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	aapName := objectWithGVR.object.GetName()
-	logger := log.FromContext(ctx).WithValues("name", aapName)
-	idled, _, err := unstructured.NestedBool(objectWithGVR.object.UnstructuredContent(), "spec", "idle_aap")
-	if err != nil {
-		logger.Error(err, "Failed to parse AAP CR to get the spec.idle_aap field")
-	}
-	if idled {
-		logger.Info("AAP CR is already idled")
-		return nil
-	}
-	logger.Info("Idling AAP")
-
-	// Patch the aap resource by setting spec.idle_aap to true in order to idle it
-	patch := []byte(`{"spec":{"idle_aap":true}}`)
-	_, err = i.dynamicClient.
-		Resource(*objectWithGVR.gvr).
-		Namespace(objectWithGVR.object.GetNamespace()).
-		Patch(ctx, aapName, types.MergePatchType, patch, metav1.PatchOptions{})
-	if err != nil {
-		return err
-	}
-
-	logger.Info("AAP idled", "name", aapName)
-	return nil
-}
-
-func (i *ownerIdler) deleteResource(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	logger := log.FromContext(ctx)
-	object := objectWithGVR.object
-	logger.Info("Deleting controller owner",
-		"kind", object.GetObjectKind().GroupVersionKind().Kind, "name", object.GetName())
-	// see https://github.com/kubernetes/kubernetes/issues/20902#issuecomment-321484735
-	// also, this may be needed for the e2e tests if the call to `client.Delete` comes too quickly after creating the job,
-	// which may leave the job's pod running but orphan, hence causing a test failure (and making the test flaky)
-	propagationPolicy := metav1.DeletePropagationBackground
-
-	err := i.dynamicClient.
-		Resource(*objectWithGVR.gvr).
-		Namespace(object.GetNamespace()).
-		Delete(ctx, object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagationPolicy})
-	if err != nil {
-		return err
-	}
-
-	logger.Info("Controller owner deleted",
-		"kind", object.GetObjectKind().GroupVersionKind().Kind, "name", object.GetName())
-	return nil
+	i.processed[key] = true
 }
 
-func (i *ownerIdler) scaleDeploymentConfigToZero(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	logger := log.FromContext(ctx)
-	object := objectWithGVR.object
-	logger.Info("Scaling DeploymentConfig to zero", "name", object.GetName())
-	patch := []byte(`{"spec":{"replicas":0,"paused":false}}`)
-	_, err := i.dynamicClient.
-		Resource(*objectWithGVR.gvr).
-		Namespace(object.GetNamespace()).
-		Patch(ctx, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
-	if err != nil {
-		return err
+// topOwner returns the kind and name of the top known controller owner of the given pod, ie. the owner that would be idled first.
+// If there is no known owner, then it returns the kind and the name of the pod itself.
+func (i *ownerIdler) topOwner(ctx context.Context, pod *corev1.Pod) (string, string) {
+	if owner := i.topKnownOwner(ctx, pod); owner != nil {
+		return owner.object.GetKind(), owner.object.GetName()
 	}
-	log.FromContext(ctx).Info("DeploymentConfig scaled to zero", "name", object.GetName())
-	return nil
+	return "Pod", pod.Name
 }
 
-func (i *ownerIdler) stopVirtualMachine(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	logger := log.FromContext(ctx)
-	object := objectWithGVR.object
-	logger.Info("Stopping VirtualMachine", "name", object.GetName())
-	err := i.restClient.Put().
-		AbsPath(fmt.Sprintf(vmSubresourceURLFmt, "v1")).
-		Namespace(object.GetNamespace()).
-		Resource("virtualmachines").
-		Name(object.GetName()).
-		SubResource("stop").
-		Do(ctx).
-		Error()
-	if err != nil {
-		return err
+// topFetchedOwner returns the kind and name of the top known controller owner of the given pod like topOwner,
+// but only the owners already fetched in this reconcile are considered, so no owner is fetched from the API server
+func (i *ownerIdler) topFetchedOwner(pod *corev1.Pod) (string, string) {
+	for _, owner := range i.ownerFetcher.fetchedOwnersOf(pod) {
+		if i.idleFuncFor(owner) != nil {
+			return owner.object.GetKind(), owner.object.GetName()
+		}
 	}
-
-	logger.Info("VirtualMachine stopped", "name", object.GetName())
-	return nil
+	return "Pod", pod.Name
 }
 
-// idleServingRuntime idles ServingRuntime by deleting InferenceService objects that exist for longer than the timeout
-func (i *ownerIdler) idleServingRuntime(ctx context.Context, objectWithGVR *objectWithGVR) error {
-	logger := log.FromContext(ctx)
-	namespace := objectWithGVR.object.GetNamespace()
-
-	logger.Info("Idling ServingRuntime by deleting old InferenceService objects", "name", objectWithGVR.object.GetName())
-
-	// Construct GVR for InferenceService objects
-	inferenceServiceGVR := schema.GroupVersionResource{
-		Group:    "serving.kserve.io",
-		Version:  "v1beta1",
-		Resource: "inferenceservices",
-	}
-
-	// List all InferenceService objects in the namespace
-	inferenceServiceList, err := i.dynamicClient.
-		Resource(inferenceServiceGVR).
-		Namespace(namespace).
-		List(ctx, metav1.ListOptions{})
+// topKnownOwner returns the top known controller owner of the given pod, ie. the owner that would be idled first,
+// or nil if there is no known owner
+func (i *ownerIdler) topKnownOwner(ctx context.Context, pod *corev1.Pod) *objectWithGVR {
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
 	if err != nil {
-		return fmt.Errorf("failed to list InferenceService objects: %w", err)
+		log.FromContext(ctx).Error(err, "failed to find all owners, use the information that is available")
 	}
-
-	cutoffTime := time.Now().Add(-time.Duration(i.idler.Spec.TimeoutSeconds) * time.Second)
-	var deletionErrors []error
-
-	// Delete InferenceService objects that are older than the timeout
-	for _, inferenceService := range inferenceServiceList.Items {
-		creationTime := inferenceService.GetCreationTimestamp().Time
-		if creationTime.Before(cutoffTime) {
-			logger.Info("Deleting old InferenceService", "name", inferenceService.GetName(), "age", time.Since(creationTime))
-
-			err := i.dynamicClient.
-				Resource(inferenceServiceGVR).
-				Namespace(namespace).
-				Delete(ctx, inferenceService.GetName(), metav1.DeleteOptions{})
-			if err != nil {
-				deletionErrors = append(deletionErrors, err)
-			} else {
-				logger.Info("InferenceService deleted", "name", inferenceService.GetName())
-			}
+	for _, owner := range owners {
+		if i.idleFuncFor(owner) != nil {
+			return owner
 		}
 	}
-
-	return errors.Join(deletionErrors...)
+	return nil
 }
 
 type ownerFetcher struct {
 	resourceLists   []*metav1.APIResourceList // All available API in the cluster
 	discoveryClient discovery.ServerResourcesInterface
 	dynamicClient   dynamic.Interface
+	// discoveryCache is shared across the reconciles when the fetcher is created for the Reconciler
+	discoveryCache *discoveryCache
+	// fetchedOwners contains the owners fetched by this fetcher, so every owner is fetched only once even if it owns multiple objects.
+	// The fetcher is expected to be used only within a single reconcile, so the owners don't get outdated.
+	fetchedOwners map[string]fetchedOwner
+}
+
+type fetchedOwner struct {
+	object *unstructured.Unstructured
+	gvr    *schema.GroupVersionResource
+	err    error
 }
 
 func newOwnerFetcher(discoveryClient discovery.ServerResourcesInterface, dynamicClient dynamic.Interface) *ownerFetcher {
 	return &ownerFetcher{
 		discoveryClient: discoveryClient,
 		dynamicClient:   dynamicClient,
+		discoveryCache:  &discoveryCache{},
+		fetchedOwners:   map[string]fetchedOwner{},
 	}
 }
 
@@ -286,44 +297,22 @@ type objectWithGVR struct {
 
 // getOwners returns the whole tree of all controller owners going recursively to the top owner for the given object
 func (o *ownerFetcher) getOwners(ctx context.Context, obj metav1.Object) ([]*objectWithGVR, error) {
-	if o.resourceLists == nil {
-		// Get all API resources from the cluster using the discovery client. We need it for constructing GVRs for unstructured objects.
-		// Do it here once, so we do not have to list it multiple times before listing/getting every unstructured resource.
-		resourceLists, err := o.discoveryClient.ServerPreferredResources()
-		if err != nil {
-			return nil, err
-		}
-		o.resourceLists = resourceLists
+	if err := o.loadResourceLists(); err != nil {
+		return nil, err
 	}
 
-	// get the controller owner (it's possible to have only one controller owner)
 	owners := obj.GetOwnerReferences()
-	var ownerReference metav1.OwnerReference
-	var nonControllerOwner metav1.OwnerReference
-	for _, ownerRef := range owners {
-		// try to get the controller owner as the preferred one
-		if ownerRef.Controller != nil && *ownerRef.Controller {
-			ownerReference = ownerRef
-			break
-		} else if nonControllerOwner.Name == "" {
-			// take only the first non-controller owner
-			nonControllerOwner = ownerRef
-		}
-	}
-	// if no controller owner was found, then use the first non-controller owner (if present)
-	if ownerReference.Name == "" {
-		ownerReference = nonControllerOwner
-	}
-	if ownerReference.Name == "" {
+	ownerReference, found := ownerReferenceOf(obj)
+	if !found {
 		return nil, nil // No owner
 	}
 	// Get the GVR for the owner
-	gvr, err := gvrForKind(ownerReference.Kind, ownerReference.APIVersion, o.resourceLists)
+	gvr, err := o.gvrForKind(ownerReference.Kind, ownerReference.APIVersion)
 	if err != nil {
 		return nil, err
 	}
 	// Get the owner object
-	ownerObject, err := o.dynamicClient.Resource(*gvr).Namespace(obj.GetNamespace()).Get(ctx, ownerReference.Name, metav1.GetOptions{})
+	ownerObject, err := o.getOwner(ctx, *gvr, obj.GetNamespace(), ownerReference)
 	if err != nil {
 		return nil, err
 	}
@@ -339,6 +328,97 @@ func (o *ownerFetcher) getOwners(ctx context.Context, obj metav1.Object) ([]*obj
 	return append(ownerOwners, owner), nil
 }
 
+// ownerReferenceOf returns the controller owner reference of the given object (it's possible to have only one controller owner),
+// or its first non-controller owner reference if there is no controller owner
+func ownerReferenceOf(obj metav1.Object) (metav1.OwnerReference, bool) {
+	var nonControllerOwner *metav1.OwnerReference
+	for index, ownerRef := range obj.GetOwnerReferences() {
+		// try to get the controller owner as the preferred one
+		if ownerRef.Controller != nil && *ownerRef.Controller {
+			return ownerRef, true
+		} else if nonControllerOwner == nil {
+			// take only the first non-controller owner
+			nonControllerOwner = &obj.GetOwnerReferences()[index]
+		}
+	}
+	if nonControllerOwner == nil {
+		return metav1.OwnerReference{}, false
+	}
+	return *nonControllerOwner, true
+}
+
+// fetchedOwnersOf returns the owners of the given object which were already fetched by this fetcher, ordered from the top owner
+// the same way as getOwners. Nothing is fetched from the API server, so the tree ends with the first owner which wasn't fetched.
+func (o *ownerFetcher) fetchedOwnersOf(obj metav1.Object) []*objectWithGVR {
+	ownerReference, found := ownerReferenceOf(obj)
+	if !found {
+		return nil
+	}
+	fetched, found := o.fetchedOwners[fetchedOwnerKey(obj.GetNamespace(), ownerReference)]
+	if !found || fetched.err != nil {
+		return nil
+	}
+	return append(o.fetchedOwnersOf(fetched.object), &objectWithGVR{object: fetched.object, gvr: fetched.gvr})
+}
+
+func fetchedOwnerKey(namespace string, ownerReference metav1.OwnerReference) string {
+	return fmt.Sprintf("%s/%s/%s/%s", namespace, ownerReference.APIVersion, ownerReference.Kind, ownerReference.Name)
+}
+
+// getOwner returns the owner object for the given reference. Every owner is fetched only once, the following calls
+// return the same object (or error).
+func (o *ownerFetcher) getOwner(ctx context.Context, gvr schema.GroupVersionResource, namespace string, ownerReference metav1.OwnerReference) (*unstructured.Unstructured, error) {
+	key := fetchedOwnerKey(namespace, ownerReference)
+	if fetched, found := o.fetchedOwners[key]; found {
+		return fetched.object, fetched.err
+	}
+	object, err := o.dynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, ownerReference.Name, metav1.GetOptions{})
+	if o.fetchedOwners == nil {
+		o.fetchedOwners = map[string]fetchedOwner{}
+	}
+	o.fetchedOwners[key] = fetchedOwner{object: object, gvr: &gvr, err: err}
+	return object, err
+}
+
+// loadResourceLists gets all API resources from the cluster (if not already loaded).
+// We need it for constructing GVRs for unstructured objects.
+// Do it only once, so we do not have to list it multiple times before listing/getting every unstructured resource.
+// The resources are taken from the discovery cache, so they are discovered only when the cache is empty or outdated.
+func (o *ownerFetcher) loadResourceLists() error {
+	if o.resourceLists != nil {
+		return nil
+	}
+	resourceLists, err := o.discoveryCache.get(o.discoveryClient)
+	if err != nil {
+		return err
+	}
+	o.resourceLists = resourceLists
+	return nil
+}
+
+// gvrForKind returns GVR for the kind. If the kind is not found in the loaded API resources (eg. a CRD was installed
+// after the resources were discovered), then the resources are refreshed and the kind is looked up again.
+func (o *ownerFetcher) gvrForKind(kind, apiVersion string) (*schema.GroupVersionResource, error) {
+	gvr, err := findGVRForKind(kind, apiVersion, o.resourceLists)
+	if gvr != nil || err != nil {
+		return gvr, err
+	}
+	resourceLists, err := o.discoveryCache.refresh(o.discoveryClient)
+	if err != nil {
+		return nil, err
+	}
+	o.resourceLists = resourceLists
+	return gvrForKind(kind, apiVersion, o.resourceLists)
+}
+
+// hasScaleSubresource returns true if the given resource has the scale subresource.
+// The preferred resources don't list the subresources, so the resources of its group version are discovered (and cached).
+// If they can't be discovered, then the resource is considered not to have the subresource.
+func (o *ownerFetcher) hasScaleSubresource(gvr schema.GroupVersionResource) bool {
+	hasScale, err := o.discoveryCache.hasSubresource(o.discoveryClient, gvr, "scale")
+	return err == nil && hasScale
+}
+
 // gvrForKind returns GVR for the kind, if it's found in the available API list in the cluster
 // returns an error if not found or failed to parse the API version
 func gvrForKind(kind, apiVersion string, resourceLists []*metav1.APIResourceList) (*schema.GroupVersionResource, error) {
@@ -377,3 +457,20 @@ func findGVRForKind(kind, apiVersion string, resourceLists []*metav1.APIResource
 
 	return nil, nil
 }
+
+// findGVRForGroupKind returns GVR for the kind of the given group in any version available in the cluster, or nil if not found
+func findGVRForGroupKind(groupKind schema.GroupKind, resourceLists []*metav1.APIResourceList) *schema.GroupVersionResource {
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || gv.Group != groupKind.Group {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if apiResource.Kind == groupKind.Kind {
+				gvr := gv.WithResource(apiResource.Name)
+				return &gvr
+			}
+		}
+	}
+	return nil
+}
@@ -33,6 +33,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	fakescale "k8s.io/client-go/scale/fake"
 	clienttest "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -208,7 +209,25 @@ var testConfigs = map[string]createTestConfigFunc{
 }
 
 var customListKinds = map[schema.GroupVersionResource]string{
-	{Group: "serving.kserve.io", Version: "v1beta1", Resource: "inferenceservices"}: "InferenceServiceList",
+	{Group: "serving.kserve.io", Version: "v1beta1", Resource: "inferenceservices"}:         "InferenceServiceList",
+	{Group: "camel.apache.org", Version: "v1", Resource: "integrations"}:                    "IntegrationList",
+	{Group: "camel.apache.org", Version: "v1alpha1", Resource: "kameletbindings"}:           "KameletBindingList",
+	{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}:                      "VirtualMachineList",
+	{Group: "aap.ansible.com", Version: "v1alpha1", Resource: "ansibleautomationplatforms"}: "AnsibleAutomationPlatformList",
+}
+
+// unstructuredScheme returns a scheme with all types from the given scheme registered as unstructured objects,
+// so the fake dynamic client can list the objects which were created as unstructured.
+func unstructuredScheme(s *runtime.Scheme) *runtime.Scheme {
+	result := runtime.NewScheme()
+	for gvk := range s.AllKnownTypes() {
+		if strings.HasSuffix(gvk.Kind, "List") {
+			result.AddKnownTypeWithName(gvk, &unstructured.UnstructuredList{})
+			continue
+		}
+		result.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	}
+	return result
 }
 
 func TestAppNameTypeForControllers(t *testing.T) {
@@ -271,7 +290,7 @@ func TestAppNameTypeForControllers(t *testing.T) {
 				ownerIdler, fakeClients, testConfig, plds, pod := setup(t, createTestConfig, false)
 
 				//when
-				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod)
+				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
 
 				//then
 				require.NoError(t, err)
@@ -296,7 +315,7 @@ func TestAppNameTypeForControllers(t *testing.T) {
 				ownerIdler, fakeClients, testConfig, _, pod := setup(t, createTestConfig, true)
 
 				//when
-				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod)
+				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
 
 				//then
 				require.NoError(t, err)
@@ -336,7 +355,7 @@ func TestAppNameTypeForControllers(t *testing.T) {
 				})
 
 				//when
-				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod)
+				appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
 
 				//then
 				fakeClients.ScalesClient.ClearActions()
@@ -369,7 +388,7 @@ func TestAppNameTypeForControllers(t *testing.T) {
 		})
 
 		//when
-		appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod)
+		appType, appName, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
 
 		// then
 		require.NoError(t, err) // errors are ignored!
@@ -387,7 +406,9 @@ func TestAppNameTypeForControllers(t *testing.T) {
 }
 
 func assertOtherOwners(t *testing.T, ownerIdler *ownerIdler, pod *corev1.Pod, secondOwnerIdled bool) {
-	owners, err := ownerIdler.ownerFetcher.getOwners(context.TODO(), pod)
+	// use a new fetcher, the owners fetched by the idler contain the state before idling
+	fetcher := newOwnerFetcher(ownerIdler.ownerFetcher.discoveryClient, ownerIdler.ownerFetcher.dynamicClient)
+	owners, err := fetcher.getOwners(context.TODO(), pod)
 	if !apierrors.IsNotFound(err) {
 		require.NoError(t, err)
 	}
@@ -414,6 +435,93 @@ func assertReplicas(t *testing.T, object *unstructured.Unstructured, expReplicas
 	require.Equal(t, expReplicas, replicas)
 }
 
+func TestTimeoutMultipliersAndEscalation(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(testcommon.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	startedAgo := func(duration time.Duration) *metav1.Time {
+		return &metav1.Time{Time: time.Now().Add(-duration)}
+	}
+
+	t.Run("timeout is multiplied for the configured owner kind", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{TimeoutMultipliers: map[string]string{"Deployment": "0.5"}})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startedAgo(31*time.Minute), nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		test.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment).
+			ReplicaSetScaledUp(replicaSet)
+		// requeued to check the next owner after 5% of the multiplied timeout
+		assert.Equal(t, 90*time.Second, res.RequeueAfter)
+	})
+
+	t.Run("timeout is not multiplied for other owner kinds", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{TimeoutMultipliers: map[string]string{"StatefulSet": "0.5"}})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startedAgo(40*time.Minute), nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		test.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		assertRequeueTimeInDelta(t, res.RequeueAfter, 20*60)
+	})
+
+	t.Run("next owner is idled after the configured escalation percentage", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{EscalationPercentage: ptr.To(150)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startedAgo(80*time.Minute), nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		test.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment).
+			ReplicaSetScaledUp(replicaSet) // not yet over 150% of the timeout
+		assert.Equal(t, 30*time.Minute, res.RequeueAfter)
+
+		t.Run("next owner is idled when over the escalation percentage", func(t *testing.T) {
+			// given
+			require.NoError(t, fakeClients.AllNamespacesClient.DeleteAllOf(context.TODO(), &corev1.Pod{}, client.InNamespace(idler.Name)))
+			createPodsWithSuffix(t, "-old", fakeClients.AllNamespacesClient, replicaSet, nil, corev1.PodStatus{StartTime: startedAgo(91 * time.Minute)})
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			test.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledDown(deployment).
+				ReplicaSetScaledDown(replicaSet)
+		})
+	})
+}
+
 func TestGetAPIResourceList(t *testing.T) {
 	// given
 	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
@@ -679,6 +787,7 @@ func TestGetOwnersFailures(t *testing.T) {
 type fakeDiscoveryClient struct {
 	*fake.FakeDiscovery
 	ServerPreferredResourcesError error
+	serverPreferredResourcesCalls int
 }
 
 func newFakeDiscoveryClient(resources ...*metav1.APIResourceList) *fakeDiscoveryClient {
@@ -689,8 +798,20 @@ func newFakeDiscoveryClient(resources ...*metav1.APIResourceList) *fakeDiscovery
 	}
 }
 
+// ServerPreferredResources returns the resources without the subresources, the same way as the real client does
 func (c *fakeDiscoveryClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
-	return c.Resources, c.ServerPreferredResourcesError
+	c.serverPreferredResourcesCalls++
+	resourceLists := make([]*metav1.APIResourceList, 0, len(c.Resources))
+	for _, resourceList := range c.Resources {
+		filtered := &metav1.APIResourceList{GroupVersion: resourceList.GroupVersion}
+		for _, apiResource := range resourceList.APIResources {
+			if !strings.Contains(apiResource.Name, "/") {
+				filtered.APIResources = append(filtered.APIResources, apiResource)
+			}
+		}
+		resourceLists = append(resourceLists, filtered)
+	}
+	return resourceLists, c.ServerPreferredResourcesError
 }
 
 func noAAPResourceList(t *testing.T) []*metav1.APIResourceList {
@@ -714,7 +835,11 @@ func noAAPResourceList(t *testing.T) []*metav1.APIResourceList {
 		})
 	}
 
-	for gvk, gvr := range supportedScaleResources {
+	for gvk, strategy := range builtInIdlingStrategies {
+		if strategy.Type != ScaleSubresourceStrategy {
+			continue
+		}
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
 		noAAPResources = append(noAAPResources, &metav1.APIResourceList{
 			GroupVersion: gvr.GroupVersion().String(),
 			APIResources: []metav1.APIResource{
@@ -0,0 +1,36 @@
+package idler
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// untilPendingTimeout returns the time left until the pod that never started (eg. an unschedulable pod, or a pod failing
+// to pull the image or to create the container) exceeds the given pending timeout, counted from the creation of the pod.
+// Returns false if the pod is not pending or if the timeout is disabled.
+func untilPendingTimeout(pod *corev1.Pod, timeout time.Duration) (time.Duration, bool) {
+	if timeout <= 0 || pod.Status.Phase != corev1.PodPending {
+		return 0, false
+	}
+	return time.Until(pod.CreationTimestamp.Add(timeout + time.Second)), true
+}
+
+// pendingReason returns the reason why the pending pod didn't start: the reason of the first waiting container
+// (eg. ImagePullBackOff or CreateContainerConfigError), or the reason why the pod is not scheduled (eg. Unschedulable).
+// Returns an empty string if the reason is not known.
+func pendingReason(pod *corev1.Pod) string {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+				return status.State.Waiting.Reason
+			}
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+			return cond.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestPendingTimeout(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	deploymentGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	createPendingPod := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, owner metav1.Object, createdAt time.Time) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              owner.GetName() + "-pod",
+				Namespace:         idler.Name,
+				CreationTimestamp: metav1.Time{Time: createdAt},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+				}},
+			},
+		}
+		require.NoError(t, controllerutil.SetControllerReference(owner, pod, scheme.Scheme))
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+		return pod
+	}
+
+	t.Run("owner of the pod pending for too long is idled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{PendingTimeout: ptr.To("30m")})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPendingPod(t, fakeClients, replicaSet, time.Now().Add(-31*time.Minute))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		idledDeployment, err := fakeClients.DynamicClient.Resource(deploymentGVR).Namespace(idler.Name).Get(context.TODO(), deployment.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, string(idleReasonPending), idledDeployment.GetAnnotations()[IdledReasonAnnotationKey])
+		notification := &toolchainv1alpha1.Notification{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: "alex-stage-idled", Namespace: test.HostOperatorNs}, notification))
+		assert.Equal(t, appNotStartedTemplate, notification.Spec.Template)
+		assert.Equal(t, "ImagePullBackOff", notification.Spec.Context["PendingReason"])
+		assert.Equal(t, "Deployment", notification.Spec.Context["AppType"])
+		assert.Equal(t, deployment.Name, notification.Spec.Context["AppName"])
+	})
+
+	t.Run("pod pending for a shorter time is checked again when the timeout is exceeded", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{PendingTimeout: ptr.To("30m")})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPendingPod(t, fakeClients, replicaSet, time.Now().Add(-10*time.Minute))
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		assert.InDelta(t, (20 * time.Minute).Seconds(), res.RequeueAfter.Seconds(), 2)
+	})
+
+	t.Run("pending pods are not idled when the pending timeout is not set", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPendingPod(t, fakeClients, replicaSet, time.Now().Add(-2*time.Hour))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+	})
+}
+
+func TestPendingReason(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status   corev1.PodStatus
+		expected string
+	}{
+		"waiting init container": {
+			status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CreateContainerConfigError"}}}},
+				ContainerStatuses:     []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}}},
+			},
+			expected: "CreateContainerConfigError",
+		},
+		"waiting container": {
+			status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}},
+			},
+			expected: "ImagePullBackOff",
+		},
+		"unschedulable pod": {
+			status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable}},
+			},
+			expected: corev1.PodReasonUnschedulable,
+		},
+		"unknown reason": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// given
+			pod := &corev1.Pod{Status: tc.status}
+
+			// when
+			reason := pendingReason(pod)
+
+			// then
+			assert.Equal(t, tc.expected, reason)
+		})
+	}
+}
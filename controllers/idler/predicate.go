@@ -4,14 +4,21 @@ import (
 	"github.com/codeready-toolchain/member-operator/pkg/webhook/mutatingwebhook"
 	corev1 "k8s.io/api/core/v1"
 	runtimeevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type PodIdlerPredicate struct {
+	// GetConfig returns the idler settings, the defaults are used when not set
+	GetConfig func() Config
+	// DeadlinesScheduled is true when the deadlines of the pods are scheduled by the central scheduler,
+	// so neither the newly set start time nor the deletion of the pod needs to trigger reconcile
+	DeadlinesScheduled bool
 }
 
 // Update triggers reconcile if the pod runs in users namespace
-// and if either the highest restart count is higher than the threshold
-// or the startTime was newly set in the new version of the pod
+// and if either the highest restart count is higher than the threshold,
+// or the highest restart count increased when the restart rate rule is enabled,
+// or the startTime was newly set in the new version of the pod (unless the deadlines are scheduled by the central scheduler)
 func (p PodIdlerPredicate) Update(event runtimeevent.TypedUpdateEvent[*corev1.Pod]) bool {
 	// all pods running in users' namespaces have the priorityClassName set, so trigger reconcile only
 	// if the pod contains the same class name to ensure that the pod runs in a user's namespace
@@ -19,8 +26,14 @@ func (p PodIdlerPredicate) Update(event runtimeevent.TypedUpdateEvent[*corev1.Po
 	if event.ObjectNew.Spec.PriorityClassName != mutatingwebhook.PriorityClassName {
 		return false
 	}
-	startTimeNewlySet := event.ObjectOld.Status.StartTime == nil && event.ObjectNew.Status.StartTime != nil
-	return startTimeNewlySet || getHighestRestartCount(event.ObjectNew.Status) > aapRestartThreshold
+	startTimeNewlySet := !p.DeadlinesScheduled && event.ObjectOld.Status.StartTime == nil && event.ObjectNew.Status.StartTime != nil
+	restartCount := getHighestRestartCount(event.ObjectNew.Status)
+	config := configOrDefault(p.GetConfig)
+	// the owners of the pod are not known here, so the lowest threshold of all kinds is used
+	threshold := config.lowestRestartThreshold()
+	overThreshold := threshold > 0 && restartCount > int32(threshold)
+	restarted := config.RestartRateThreshold() > 0 && restartCount > getHighestRestartCount(event.ObjectOld.Status)
+	return startTimeNewlySet || overThreshold || restarted
 }
 
 // Create doesn't trigger reconcile
@@ -28,8 +41,12 @@ func (PodIdlerPredicate) Create(_ runtimeevent.TypedCreateEvent[*corev1.Pod]) bo
 	return false
 }
 
-// Delete triggers reconcile for users pods to make sure that the deleted pod is not tracked in the status anymore
-func (PodIdlerPredicate) Delete(event runtimeevent.TypedDeleteEvent[*corev1.Pod]) bool {
+// Delete triggers reconcile for users pods to make sure that the deleted pod is not tracked in the status anymore,
+// unless the deadlines of the pods are scheduled by the central scheduler
+func (p PodIdlerPredicate) Delete(event runtimeevent.TypedDeleteEvent[*corev1.Pod]) bool {
+	if p.DeadlinesScheduled {
+		return false
+	}
 	// all pods running in users' namespaces have the priorityClassName set, so trigger reconcile only
 	// if the pod contains the same class name to ensure that the pod runs in a user's namespace
 	// (we don't care about other pods)
@@ -40,3 +57,11 @@ func (PodIdlerPredicate) Delete(event runtimeevent.TypedDeleteEvent[*corev1.Pod]
 func (p PodIdlerPredicate) Generic(_ runtimeevent.TypedGenericEvent[*corev1.Pod]) bool {
 	return false
 }
+
+// UnidleRequestedPredicate triggers reconcile only for the namespaces which have the un-idle annotation set
+func UnidleRequestedPredicate() predicate.TypedPredicate[*corev1.Namespace] {
+	return predicate.NewTypedPredicateFuncs(func(namespace *corev1.Namespace) bool {
+		_, found := namespace.GetAnnotations()[UnidleAnnotationKey]
+		return found
+	})
+}
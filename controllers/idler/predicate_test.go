@@ -2,10 +2,12 @@ package idler
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
@@ -112,3 +114,86 @@ func TestPredicate(t *testing.T) {
 		})
 	}
 }
+
+func TestPredicateWithRestartRules(t *testing.T) {
+	// given
+	newPod := func(restartCount int32) *corev1.Pod {
+		return &corev1.Pod{
+			Spec: corev1.PodSpec{PriorityClassName: "sandbox-users-pods"},
+			Status: corev1.PodStatus{
+				StartTime:         &metav1.Time{Time: time.Now()},
+				ContainerStatuses: []corev1.ContainerStatus{{RestartCount: restartCount}},
+			},
+		}
+	}
+	update := func(oldRestartCount, newRestartCount int32) event.TypedUpdateEvent[*corev1.Pod] {
+		return event.TypedUpdateEvent[*corev1.Pod]{ObjectOld: newPod(oldRestartCount), ObjectNew: newPod(newRestartCount)}
+	}
+
+	t.Run("configured restart threshold", func(t *testing.T) {
+		// given
+		predicate := PodIdlerPredicate{GetConfig: configFor(ConfigSpec{RestartThreshold: ptr.To(5)})}
+
+		// when & then
+		// the default threshold of AnsibleAutomationPlatforms is one restart lower
+		assert.True(t, predicate.Update(update(4, 5)))
+		assert.False(t, predicate.Update(update(3, 4)))
+	})
+
+	t.Run("lowest restart threshold per kind", func(t *testing.T) {
+		// given
+		predicate := PodIdlerPredicate{GetConfig: configFor(ConfigSpec{
+			RestartThreshold:  ptr.To(5),
+			RestartThresholds: map[string]int{"AnsibleAutomationPlatform": 0, "StatefulSet": 2},
+		})}
+
+		// when & then
+		assert.True(t, predicate.Update(update(2, 3)))
+		assert.False(t, predicate.Update(update(1, 2)))
+	})
+
+	t.Run("restart threshold disabled", func(t *testing.T) {
+		// given
+		predicate := PodIdlerPredicate{GetConfig: configFor(ConfigSpec{RestartThreshold: ptr.To(0)})}
+
+		// when & then
+		assert.False(t, predicate.Update(update(100, 101)))
+	})
+
+	t.Run("restart rate enabled", func(t *testing.T) {
+		// given
+		predicate := PodIdlerPredicate{GetConfig: configFor(ConfigSpec{RestartRateThreshold: ptr.To(3)})}
+
+		// when & then
+		assert.True(t, predicate.Update(update(1, 2)))
+		assert.False(t, predicate.Update(update(2, 2)))
+	})
+
+	t.Run("restart rate disabled", func(t *testing.T) {
+		// given
+		predicate := PodIdlerPredicate{}
+
+		// when & then
+		assert.False(t, predicate.Update(update(1, 2)))
+	})
+}
+
+func TestPredicateWithScheduledDeadlines(t *testing.T) {
+	// given
+	predicate := PodIdlerPredicate{DeadlinesScheduled: true}
+	newPod := func(startTime *metav1.Time, restartCount int32) *corev1.Pod {
+		return &corev1.Pod{
+			Spec: corev1.PodSpec{PriorityClassName: "sandbox-users-pods"},
+			Status: corev1.PodStatus{
+				StartTime:         startTime,
+				ContainerStatuses: []corev1.ContainerStatus{{RestartCount: restartCount}},
+			},
+		}
+	}
+	startTime := &metav1.Time{Time: time.Now()}
+
+	// when & then
+	assert.False(t, predicate.Update(event.TypedUpdateEvent[*corev1.Pod]{ObjectOld: newPod(nil, 0), ObjectNew: newPod(startTime, 0)}))
+	assert.True(t, predicate.Update(event.TypedUpdateEvent[*corev1.Pod]{ObjectOld: newPod(startTime, 50), ObjectNew: newPod(startTime, 51)}))
+	assert.False(t, predicate.Delete(event.TypedDeleteEvent[*corev1.Pod]{Object: newPod(startTime, 0)}))
+}
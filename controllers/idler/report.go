@@ -0,0 +1,177 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// IdlerReportName is the name of the ConfigMap maintained by the idler in every namespace, which lists the recently idled workloads
+	// and the time the running workloads are going to be idled at. Any change made by the users is overwritten.
+	IdlerReportName = "idler-report"
+	// IdlerReportManagedLabelKey marks the reports maintained by the idler. A ConfigMap named as the report but without this label
+	// is never overwritten, and only the labeled ConfigMaps are cached by the operator.
+	IdlerReportManagedLabelKey = toolchainv1alpha1.LabelKeyPrefix + "idler-managed"
+
+	// reportIdledKey is the key of the report listing the recently idled workloads
+	reportIdledKey = "idled"
+	// reportRunningKey is the key of the report listing the running workloads with their projected idle time
+	reportRunningKey = "running"
+
+	// reportRetention is how long the idled workloads are listed in the report
+	reportRetention = 7 * 24 * time.Hour
+	// reportMaxIdled is the maximum number of the idled workloads listed in the report
+	reportMaxIdled = 50
+)
+
+// reportedIdledWorkload is a workload listed in the report as recently idled
+type reportedIdledWorkload struct {
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Reason  string    `json:"reason"`
+	IdledAt time.Time `json:"idledAt"`
+}
+
+// reportedRunningWorkload is a running workload listed in the report with the time it's going to be idled at,
+// unless it's active again (when the activity is tracked)
+type reportedRunningWorkload struct {
+	Kind   string    `json:"kind"`
+	Name   string    `json:"name"`
+	IdleAt time.Time `json:"idleAt"`
+}
+
+// recordRunning adds the top owner of the running pod to the workloads listed in the report with the given projected idle time.
+// The owners of the running pods were already fetched when the pod was evaluated, so they are taken from the owners fetched in this reconcile.
+// The earliest idle time of all pods of the workload is kept.
+func (i *ownerIdler) recordRunning(pod *corev1.Pod, idleAt time.Time) {
+	kind, name := i.topFetchedOwner(pod)
+	idleAt = idleAt.UTC().Truncate(time.Second)
+	for index, running := range i.runningWorkloads {
+		if running.Kind == kind && running.Name == name {
+			if idleAt.Before(running.IdleAt) {
+				i.runningWorkloads[index].IdleAt = idleAt
+			}
+			return
+		}
+	}
+	i.runningWorkloads = append(i.runningWorkloads, reportedRunningWorkload{Kind: kind, Name: name, IdleAt: idleAt})
+}
+
+// updateReport updates the report in the namespace of the Idler with the workloads idled in this reconcile and the running workloads.
+// The workloads idled in the previous reconciles are kept in the report for the retention period.
+// Errors are only logged since the report is informational only.
+func (r *Reconciler) updateReport(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler, now time.Time) {
+	if err := r.ensureReport(ctx, idler, ownerIdler, now); err != nil {
+		log.FromContext(ctx).Error(err, "failed to update the idler report")
+	}
+}
+
+func (r *Reconciler) ensureReport(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler, now time.Time) error {
+	report := &corev1.ConfigMap{}
+	err := r.AllNamespacesClient.Get(ctx, types.NamespacedName{Namespace: idler.Name, Name: IdlerReportName}, report)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get the idler report: %w", err)
+	}
+	exists := err == nil
+	if exists && report.Labels[IdlerReportManagedLabelKey] != "true" {
+		log.FromContext(ctx).Info("The ConfigMap of the idler report is not managed by the idler, not updating it", "name", IdlerReportName)
+		return nil
+	}
+
+	var idled []reportedIdledWorkload
+	if value, found := report.Data[reportIdledKey]; found {
+		if err := yaml.Unmarshal([]byte(value), &idled); err != nil {
+			// the report was modified, start over
+			log.FromContext(ctx).Info("Ignoring the invalid list of the idled workloads in the idler report", "error", err.Error())
+			idled = nil
+		}
+	}
+	idled = reportIdled(idled, ownerIdler, now)
+	running := append([]reportedRunningWorkload{}, ownerIdler.runningWorkloads...)
+	sort.Slice(running, func(i, j int) bool {
+		if !running[i].IdleAt.Equal(running[j].IdleAt) {
+			return running[i].IdleAt.Before(running[j].IdleAt)
+		}
+		return running[i].Kind+"/"+running[i].Name < running[j].Kind+"/"+running[j].Name
+	})
+
+	data := map[string]string{}
+	for key, value := range map[string]any{reportIdledKey: idled, reportRunningKey: running} {
+		encoded, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		data[key] = string(encoded)
+	}
+	if exists && reflect.DeepEqual(report.Data, data) {
+		return nil
+	}
+	report.Data = data
+	if !exists {
+		report.ObjectMeta = metav1.ObjectMeta{
+			Name:      IdlerReportName,
+			Namespace: idler.Name,
+			Labels: map[string]string{
+				toolchainv1alpha1.ProviderLabelKey: toolchainv1alpha1.ProviderLabelValue,
+				IdlerReportManagedLabelKey:         "true",
+			},
+		}
+		err := r.AllNamespacesClient.Create(ctx, report)
+		if apierrors.IsAlreadyExists(err) {
+			// only the managed reports are cached, so the ConfigMap which is not found in the cache is not managed by the idler
+			log.FromContext(ctx).Info("The ConfigMap of the idler report is not managed by the idler, not updating it", "name", IdlerReportName)
+			return nil
+		}
+		return err
+	}
+	if err := r.AllNamespacesClient.Update(ctx, report); err != nil && !apierrors.IsConflict(err) {
+		return err
+	} // the cached report might be outdated after the previous update, it's updated again in the next reconcile
+	return nil
+}
+
+// reportIdled returns the idled workloads listed in the report: the workloads idled in this reconcile followed by the ones
+// idled previously, the most recent first. Every workload is listed only once with its latest idling.
+func reportIdled(previous []reportedIdledWorkload, ownerIdler *ownerIdler, now time.Time) []reportedIdledWorkload {
+	idledAt := now.UTC().Truncate(time.Second)
+	idled := []reportedIdledWorkload{}
+	for _, workload := range ownerIdler.idledWorkloads {
+		idled = append(idled, reportedIdledWorkload{Kind: workload.kind, Name: workload.name, Reason: string(workload.reason), IdledAt: idledAt})
+	}
+	for _, workload := range ownerIdler.crashLoops {
+		idled = append(idled, reportedIdledWorkload{Kind: workload.kind, Name: workload.name, Reason: string(workload.reason), IdledAt: idledAt})
+	}
+	sort.SliceStable(previous, func(i, j int) bool {
+		return previous[i].IdledAt.After(previous[j].IdledAt)
+	})
+	for _, workload := range previous {
+		if now.Sub(workload.IdledAt) > reportRetention || len(idled) >= reportMaxIdled {
+			break
+		}
+		listed := false
+		for _, recent := range idled {
+			if recent.Kind == workload.Kind && recent.Name == workload.Name {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			idled = append(idled, workload)
+		}
+	}
+	if len(idled) > reportMaxIdled {
+		idled = idled[:reportMaxIdled]
+	}
+	return idled
+}
@@ -0,0 +1,219 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestIdlerReport(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	expiredStartTime := &metav1.Time{Time: expiredStartTimes(idler.Spec.TimeoutSeconds).defaultStartTime}
+	runningStartTime := &metav1.Time{Time: time.Now().Add(-time.Minute).Truncate(time.Second)}
+	expectedIdleAt := runningStartTime.Add(time.Hour).UTC()
+
+	t.Run("idled and running workloads are listed in the report", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		idledDeployment, idledReplicaSet := createDeployment(t, fakeClients, idler.Name, "idled-", "-deployment", nil)
+		idledPods := createPods(t, fakeClients.AllNamespacesClient, idledReplicaSet, expiredStartTime, nil, noRestart())
+		runningDeployment, runningReplicaSet := createDeployment(t, fakeClients, idler.Name, "running-", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, runningReplicaSet, runningStartTime, nil, noRestart())
+		standalonePod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "standalone-pod", Namespace: idler.Name},
+			Status:     corev1.PodStatus{StartTime: expiredStartTime},
+		}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), standalonePod))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(idledDeployment).
+			DeploymentScaledUp(runningDeployment)
+		idled, running := getReport(t, fakeClients, idler.Name)
+		require.Len(t, idled, 2)
+		assert.Equal(t, "Deployment", idled[0].Kind)
+		assert.Equal(t, idledDeployment.Name, idled[0].Name)
+		assert.Equal(t, string(idleReasonTimeout), idled[0].Reason)
+		assert.WithinDuration(t, time.Now(), idled[0].IdledAt, 5*time.Second)
+		assert.Equal(t, "Pod", idled[1].Kind)
+		assert.Equal(t, standalonePod.Name, idled[1].Name)
+		assert.Equal(t, []reportedRunningWorkload{{Kind: "Deployment", Name: runningDeployment.Name, IdleAt: expectedIdleAt}}, running)
+		assert.ElementsMatch(t, []string{
+			fmt.Sprintf("Normal Idled Deployment %s was idled (reason: timeout)", idledDeployment.Name),
+			"Normal Idled Pod standalone-pod was deleted (reason: timeout)",
+		}, recordedEvents(recorder))
+
+		t.Run("previously idled workloads are kept in the report", func(t *testing.T) {
+			// given
+			for _, pod := range idledPods {
+				require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), pod))
+			}
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			idledAgain, running := getReport(t, fakeClients, idler.Name)
+			assert.Equal(t, idled, idledAgain)
+			assert.Equal(t, []reportedRunningWorkload{{Kind: "Deployment", Name: runningDeployment.Name, IdleAt: expectedIdleAt}}, running)
+			assert.Empty(t, recordedEvents(recorder))
+		})
+
+		t.Run("changes of the report are overwritten", func(t *testing.T) {
+			// given
+			report := &corev1.ConfigMap{}
+			require.NoError(t, fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Namespace: idler.Name, Name: IdlerReportName}, report))
+			report.Data[reportIdledKey] = "not a list"
+			report.Data[reportRunningKey] = "[]"
+			require.NoError(t, fakeClients.AllNamespacesClient.Update(context.TODO(), report))
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			idled, running := getReport(t, fakeClients, idler.Name)
+			assert.Empty(t, idled)
+			assert.Equal(t, []reportedRunningWorkload{{Kind: "Deployment", Name: runningDeployment.Name, IdleAt: expectedIdleAt}}, running)
+		})
+	})
+
+	t.Run("ConfigMap not managed by the idler is not overwritten", func(t *testing.T) {
+		// given
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: IdlerReportName, Namespace: idler.Name},
+			Data:       map[string]string{"owner": "user"},
+		}
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), configMap))
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		report := &corev1.ConfigMap{}
+		require.NoError(t, fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Namespace: idler.Name, Name: IdlerReportName}, report))
+		assert.Equal(t, map[string]string{"owner": "user"}, report.Data)
+		assert.NotContains(t, report.Labels, IdlerReportManagedLabelKey)
+	})
+
+	t.Run("no report in the dry-run mode", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{DryRun: ptr.To(true)})
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		err = fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Namespace: idler.Name, Name: IdlerReportName}, &corev1.ConfigMap{})
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.Empty(t, recordedEvents(recorder))
+	})
+}
+
+func TestReportIdled(t *testing.T) {
+	// given
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	previous := []reportedIdledWorkload{
+		{Kind: "Deployment", Name: "old", Reason: string(idleReasonTimeout), IdledAt: now.Add(-reportRetention - time.Minute)},
+		{Kind: "Deployment", Name: "web", Reason: string(idleReasonTimeout), IdledAt: now.Add(-2 * time.Hour)},
+		{Kind: "StatefulSet", Name: "db", Reason: string(idleReasonScheduled), IdledAt: now.Add(-time.Hour)},
+	}
+	ownerIdler := &ownerIdler{
+		idledWorkloads: []idledWorkload{{kind: "Deployment", name: "web", reason: idleReasonSpaceBudget}},
+		crashLoops:     []crashLoopWorkload{{kind: "Deployment", name: "worker", reason: idleReasonRestartThreshold}},
+	}
+
+	// when
+	idled := reportIdled(previous, ownerIdler, now)
+
+	// then
+	assert.Equal(t, []reportedIdledWorkload{
+		{Kind: "Deployment", Name: "web", Reason: string(idleReasonSpaceBudget), IdledAt: now},
+		{Kind: "Deployment", Name: "worker", Reason: string(idleReasonRestartThreshold), IdledAt: now},
+		{Kind: "StatefulSet", Name: "db", Reason: string(idleReasonScheduled), IdledAt: now.Add(-time.Hour)},
+	}, idled)
+
+	t.Run("number of the idled workloads is limited", func(t *testing.T) {
+		// given
+		var previous []reportedIdledWorkload
+		for i := 0; i < reportMaxIdled+10; i++ {
+			previous = append(previous, reportedIdledWorkload{Kind: "Pod", Name: fmt.Sprintf("pod-%d", i), IdledAt: now.Add(-time.Duration(i) * time.Minute)})
+		}
+
+		// when
+		idled := reportIdled(previous, ownerIdler, now)
+
+		// then
+		require.Len(t, idled, reportMaxIdled)
+		assert.Equal(t, "web", idled[0].Name)
+		assert.Equal(t, "pod-0", idled[2].Name)
+	})
+}
+
+// getReport returns the idled and the running workloads listed in the report in the given namespace
+func getReport(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, namespace string) ([]reportedIdledWorkload, []reportedRunningWorkload) {
+	report := &corev1.ConfigMap{}
+	require.NoError(t, fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: IdlerReportName}, report))
+	assert.Equal(t, toolchainv1alpha1.ProviderLabelValue, report.Labels[toolchainv1alpha1.ProviderLabelKey])
+	assert.Equal(t, "true", report.Labels[IdlerReportManagedLabelKey])
+	var idled []reportedIdledWorkload
+	require.NoError(t, yaml.Unmarshal([]byte(report.Data[reportIdledKey]), &idled))
+	var running []reportedRunningWorkload
+	require.NoError(t, yaml.Unmarshal([]byte(report.Data[reportRunningKey]), &running))
+	return idled, running
+}
+
+// recordedEvents returns the events recorded by the fake recorder since the last call
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
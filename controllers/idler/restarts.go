@@ -0,0 +1,120 @@
+package idler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// restartTracker keeps the times of the restarts of the pods observed by the idler, so the pods can be killed based
+// on the number of restarts within a time window instead of the total number of restarts over the whole life of the pod.
+// The pods are identified by their UID. The zero value is ready to use.
+type restartTracker struct {
+	mu   sync.Mutex
+	pods map[types.UID]*podRestarts
+}
+
+type podRestarts struct {
+	namespace string
+	// count is the highest restart count of the containers of the pod observed the last time
+	count int32
+	times []time.Time
+}
+
+// recentRestarts records the restarts of the pod that happened since the pod was observed the last time and returns
+// the number of its restarts within the given window. The restarts that happened before the pod was observed
+// for the first time are not known, so they are not counted.
+func (t *restartTracker) recentRestarts(namespace string, pod *corev1.Pod, window time.Duration, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pods == nil {
+		t.pods = map[types.UID]*podRestarts{}
+	}
+	count := getHighestRestartCount(pod.Status)
+	restarts, found := t.pods[pod.UID]
+	if !found {
+		t.pods[pod.UID] = &podRestarts{namespace: namespace, count: count}
+		return 0
+	}
+	for ; restarts.count < count; restarts.count++ {
+		restarts.times = append(restarts.times, now)
+	}
+	var recent []time.Time
+	for _, restartedAt := range restarts.times {
+		if now.Sub(restartedAt) <= window {
+			recent = append(recent, restartedAt)
+		}
+	}
+	restarts.times = recent
+	return len(recent)
+}
+
+// prune removes all records of the given namespace that don't belong to any of the given (still existing) pods
+func (t *restartTracker) prune(namespace string, existing map[types.UID]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for uid, restarts := range t.pods {
+		if restarts.namespace == namespace && !existing[uid] {
+			delete(t.pods, uid)
+		}
+	}
+}
+
+// crashLoopReason returns the reason to idle the pod if it's restarting too often according to the configured rules,
+// or an empty string otherwise
+func (r *Reconciler) crashLoopReason(ctx context.Context, ownerIdler *ownerIdler, namespace string, pod *corev1.Pod) idleReason {
+	recentRestarts := 0
+	rateThreshold := r.config().RestartRateThreshold()
+	if rateThreshold > 0 {
+		// the restarts are recorded in every reconcile so the number of the recent restarts is accurate
+		recentRestarts = r.restarts.recentRestarts(namespace, pod, r.config().RestartRateWindow(), time.Now())
+	}
+	restartCount := getHighestRestartCount(pod.Status)
+	// the owners are fetched only when the pod could be over the threshold of any kind
+	lowestThreshold := r.config().lowestRestartThreshold()
+	overThreshold := lowestThreshold > 0 && restartCount > int32(lowestThreshold)
+	if overThreshold {
+		threshold := ownerIdler.restartThreshold(ctx, pod)
+		overThreshold = threshold > 0 && restartCount > int32(threshold)
+	}
+	switch {
+	case overThreshold:
+		return idleReasonRestartThreshold
+	case rateThreshold > 0 && recentRestarts >= rateThreshold:
+		return idleReasonRestartRate
+	}
+	return ""
+}
+
+// restartThreshold returns the restart threshold for the given pod: the threshold configured for the kind of the first owner
+// of the pod (walking up from the pod) that has one, or the general restart threshold
+func (i *ownerIdler) restartThreshold(ctx context.Context, pod *corev1.Pod) int {
+	thresholds := i.config.RestartThresholds()
+	if len(thresholds) == 0 {
+		return i.config.RestartThreshold()
+	}
+	owners, err := i.ownerFetcher.getOwners(ctx, pod)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to find all owners, use the information that is available to determine the restart threshold")
+	}
+	// the owners are ordered from the top one
+	for index := len(owners) - 1; index >= 0; index-- {
+		if threshold, found := thresholds[owners[index].object.GetKind()]; found {
+			return threshold
+		}
+	}
+	return i.config.RestartThreshold()
+}
+
+// pruneRestarts removes the restarts of the pods which don't exist in the namespace anymore
+func (r *Reconciler) pruneRestarts(namespace string, pods []corev1.Pod) {
+	existing := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		existing[pod.UID] = true
+	}
+	r.restarts.prune(namespace, existing)
+}
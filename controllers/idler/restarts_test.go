@@ -0,0 +1,206 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestRestartTracker(t *testing.T) {
+	// given
+	now := time.Now()
+	withRestarts := func(restartCount int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "crashing", Namespace: "john-dev", UID: "pod-uid"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{RestartCount: restartCount}}},
+		}
+	}
+
+	t.Run("restarts before the first observation are not counted", func(t *testing.T) {
+		// given
+		tracker := &restartTracker{}
+
+		// when
+		recent := tracker.recentRestarts("john-dev", withRestarts(100), time.Hour, now)
+
+		// then
+		assert.Zero(t, recent)
+	})
+
+	t.Run("restarts are counted within the window", func(t *testing.T) {
+		// given
+		tracker := &restartTracker{}
+		tracker.recentRestarts("john-dev", withRestarts(1), 10*time.Minute, now.Add(-time.Hour))
+		tracker.recentRestarts("john-dev", withRestarts(3), 10*time.Minute, now.Add(-20*time.Minute))
+		tracker.recentRestarts("john-dev", withRestarts(4), 10*time.Minute, now.Add(-5*time.Minute))
+
+		// when
+		recent := tracker.recentRestarts("john-dev", withRestarts(6), 10*time.Minute, now)
+
+		// then
+		assert.Equal(t, 3, recent)
+	})
+
+	t.Run("prune removes the records of the deleted pods", func(t *testing.T) {
+		// given
+		tracker := &restartTracker{}
+		tracker.recentRestarts("john-dev", withRestarts(1), time.Hour, now.Add(-time.Minute))
+		tracker.recentRestarts("john-dev", withRestarts(2), time.Hour, now)
+		otherNamespacePod := withRestarts(1)
+		otherNamespacePod.UID = "other-uid"
+		tracker.recentRestarts("john-stage", otherNamespacePod, time.Hour, now)
+
+		// when
+		tracker.prune("john-dev", map[types.UID]bool{})
+
+		// then
+		assert.Zero(t, tracker.recentRestarts("john-dev", withRestarts(3), time.Hour, now))
+		assert.Contains(t, tracker.pods, types.UID("other-uid"))
+	})
+}
+
+func TestIdleCrashLoopingPods(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	startTime := &metav1.Time{Time: time.Now().Add(-time.Minute)}
+
+	t.Run("pod restarting fast is idled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{RestartRateThreshold: ptr.To(3), RestartRateWindow: ptr.To("10m")})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pod := createRestartingPod(t, fakeClients, replicaSet, startTime, 1)
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		setRestartCount(t, fakeClients, pod, 4)
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		assert.Equal(t, string(idleReasonRestartRate), getDeployment(t, fakeClients, deployment).GetAnnotations()[IdledReasonAnnotationKey])
+	})
+
+	t.Run("pod restarting slowly is not idled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{RestartRateThreshold: ptr.To(3), RestartRateWindow: ptr.To("10m")})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pod := createRestartingPod(t, fakeClients, replicaSet, startTime, 1)
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		setRestartCount(t, fakeClients, pod, 3)
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+	})
+
+	t.Run("total restart count is ignored when the threshold is disabled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{RestartThreshold: ptr.To(0)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startTime, nil, restartingOverThreshold())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+	})
+
+	t.Run("configured restart threshold", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{RestartThreshold: ptr.To(5)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startTime, nil, []corev1.ContainerStatus{{RestartCount: 6}})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		assert.Equal(t, string(idleReasonRestartThreshold), getDeployment(t, fakeClients, deployment).GetAnnotations()[IdledReasonAnnotationKey])
+	})
+
+	t.Run("AAP has a lower restart threshold by default", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		aap := newAAP(t, false, "my-aap", idler.Name)
+		createObjectWithDynamicClient(t, fakeClients.DynamicClient, aap)
+		aapDeployment, aapReplicaSet := createDeployment(t, fakeClients, idler.Name, "", "-aap-deployment", aap)
+		createPods(t, fakeClients.AllNamespacesClient, aapReplicaSet, startTime, nil, []corev1.ContainerStatus{{RestartCount: 50}})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, startTime, nil, []corev1.ContainerStatus{{RestartCount: 50}})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		idledAAP, err := fakeClients.DynamicClient.Resource(schema.GroupVersionResource{Group: "aap.ansible.com", Version: "v1alpha1", Resource: "ansibleautomationplatforms"}).Namespace(idler.Name).Get(context.TODO(), aap.GetName(), metav1.GetOptions{})
+		require.NoError(t, err)
+		idle, _, err := unstructured.NestedBool(idledAAP.Object, "spec", "idle_aap")
+		require.NoError(t, err)
+		assert.True(t, idle)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(aapDeployment). // the AAP operator scales it down once the AAP is idled
+			DeploymentScaledUp(deployment)
+	})
+}
+
+func createRestartingPod(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, owner metav1.Object, startTime *metav1.Time, restartCount int32) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: owner.GetName() + "-restarting", Namespace: owner.GetNamespace(), UID: "restarting-pod-uid"},
+		Status:     corev1.PodStatus{StartTime: startTime, ContainerStatuses: []corev1.ContainerStatus{{RestartCount: restartCount}}},
+	}
+	require.NoError(t, controllerutil.SetControllerReference(owner, pod, scheme.Scheme))
+	require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+	return pod
+}
+
+func setRestartCount(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, pod *corev1.Pod, restartCount int32) {
+	current := &corev1.Pod{}
+	require.NoError(t, fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, current))
+	current.Status.ContainerStatuses = []corev1.ContainerStatus{{RestartCount: restartCount}}
+	require.NoError(t, fakeClients.AllNamespacesClient.Status().Update(context.TODO(), current))
+}
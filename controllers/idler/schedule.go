@@ -0,0 +1,126 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/robfig/cron/v3"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// IdlingScheduleAnnotationKey can be set on the Idler to idle all workloads in the namespace in a recurring window.
+	// The value is a cron expression (eg. "0 22 * * 1-5") evaluated in UTC which defines the start of the window.
+	// It takes precedence over the schedule configured for the tier of the Idler.
+	IdlingScheduleAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idling-schedule"
+	// IdlingScheduleDurationAnnotationKey can be set on the Idler together with the IdlingScheduleAnnotationKey to define
+	// the duration of the window (eg. "8h"). Defaults to 8 hours.
+	IdlingScheduleDurationAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idling-schedule-duration"
+
+	defaultIdlingWindowDuration = 8 * time.Hour
+	// maxIdlingWindowDuration limits the duration of the window, so the workloads are not kept idled for more than a week
+	maxIdlingWindowDuration = 7 * 24 * time.Hour
+)
+
+// IdlingSchedule defines a recurring window in which all workloads in the namespace are idled regardless of their age
+type IdlingSchedule struct {
+	// Schedule is a cron expression (eg. "0 22 * * 1-5") evaluated in UTC which defines the start of the window
+	Schedule string `json:"schedule"`
+	// Duration is the duration of the window (eg. "8h"). Defaults to 8 hours.
+	Duration string `json:"duration,omitempty"`
+}
+
+// idlingWindow is a parsed IdlingSchedule
+type idlingWindow struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// cronParser parses the standard cron expressions with five fields (the same as the ones of the CronJobs)
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+func (s IdlingSchedule) parse() (*idlingWindow, error) {
+	schedule, err := cronParser.Parse(s.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': %w", s.Schedule, err)
+	}
+	duration := defaultIdlingWindowDuration
+	if s.Duration != "" {
+		if duration, err = time.ParseDuration(s.Duration); err != nil {
+			return nil, fmt.Errorf("invalid duration of the idling window '%s': %w", s.Duration, err)
+		}
+	}
+	if duration <= 0 || duration > maxIdlingWindowDuration {
+		return nil, fmt.Errorf("the duration of the idling window '%s' must be positive and not longer than %s", s.Duration, maxIdlingWindowDuration)
+	}
+	return &idlingWindow{schedule: schedule, duration: duration}, nil
+}
+
+// isActive returns true if the window started within its duration before the given time
+func (w *idlingWindow) isActive(now time.Time) bool {
+	_, active := w.activeUntil(now)
+	return active
+}
+
+// activeUntil returns the end of the window active at the given time, ie. the duration after the latest start of the window.
+// Returns false if the window is not active.
+func (w *idlingWindow) activeUntil(now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	start := w.schedule.Next(now.Add(-w.duration))
+	if start.IsZero() || start.After(now) {
+		return time.Time{}, false
+	}
+	// the window can start several times within its duration, the latest start is the first minute after which
+	// the window doesn't start again until now
+	minutes := int(now.Truncate(time.Minute).Sub(start) / time.Minute)
+	latest := sort.Search(minutes, func(i int) bool {
+		return w.schedule.Next(start.Add(time.Duration(i) * time.Minute)).After(now)
+	})
+	return start.Add(time.Duration(latest) * time.Minute).Add(w.duration), true
+}
+
+// nextStart returns the next start of the window after the given time. Returns false if the schedule never matches.
+func (w *idlingWindow) nextStart(now time.Time) (time.Time, bool) {
+	start := w.schedule.Next(now.UTC())
+	return start, !start.IsZero()
+}
+
+// idlingSchedule returns the idling schedule of the Idler - either the one set via the annotations or the one configured
+// for the tier of the Idler. Returns false if there is no schedule.
+func (c Config) idlingSchedule(idler *toolchainv1alpha1.Idler) (IdlingSchedule, bool) {
+	if schedule, found := idler.GetAnnotations()[IdlingScheduleAnnotationKey]; found {
+		return IdlingSchedule{Schedule: schedule, Duration: idler.GetAnnotations()[IdlingScheduleDurationAnnotationKey]}, true
+	}
+	tier, found := idler.GetLabels()[toolchainv1alpha1.TierLabelKey]
+	if !found {
+		return IdlingSchedule{}, false
+	}
+	schedule, found := c.spec.IdlingSchedules[tier]
+	return schedule, found
+}
+
+// checkIdlingWindow returns the end of the idling window of the Idler if the window is active (or zero time if it's not).
+// If it's not active, then it also returns the time until the next start of the window (or zero if it's not known).
+// An invalid schedule is only logged, so it doesn't block the regular idling.
+func (r *Reconciler) checkIdlingWindow(ctx context.Context, idler *toolchainv1alpha1.Idler) (time.Time, time.Duration) {
+	schedule, found := r.config().idlingSchedule(idler)
+	if !found {
+		return time.Time{}, 0
+	}
+	window, err := schedule.parse()
+	if err != nil {
+		log.FromContext(ctx).Error(err, "invalid idling schedule, ignoring it", "schedule", schedule.Schedule)
+		return time.Time{}, 0
+	}
+	now := time.Now()
+	if end, active := window.activeUntil(now); active {
+		return end, 0
+	}
+	if nextStart, found := window.nextStart(now); found {
+		return time.Time{}, nextStart.Sub(now)
+	}
+	return time.Time{}, 0
+}
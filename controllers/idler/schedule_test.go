@@ -0,0 +1,297 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCronExpressions(t *testing.T) {
+	// Wednesday
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, time.January, 15, hour, minute, 0, 0, time.UTC)
+	}
+
+	t.Run("valid expressions", func(t *testing.T) {
+		for expression, expected := range map[string]map[time.Time]bool{
+			"* * * * *":        {at(0, 0): true, at(13, 37): true},
+			"0 22 * * *":       {at(22, 0): true, at(22, 1): false, at(21, 0): false},
+			"*/15 * * * *":     {at(10, 0): true, at(10, 45): true, at(10, 20): false},
+			"0-30/10 8 * * *":  {at(8, 20): true, at(8, 40): false},
+			"0 8,20 * * *":     {at(8, 0): true, at(20, 0): true, at(12, 0): false},
+			"0 22 * * 1-5":     {at(22, 0): true},
+			"0 22 * * 0,6":     {at(22, 0): false},
+			"0 22 * * 3":       {at(22, 0): true},
+			"0 22 * 2 *":       {at(22, 0): false},
+			"0 22 1 * *":       {at(22, 0): false},
+			"0 22 1 * 3":       {at(22, 0): true}, // either of the day fields matches
+			"0 22 15 * 0":      {at(22, 0): true},
+			"0 0 * * SUN":      {time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC): true},
+			"0 22 * JAN WED":   {at(22, 0): true},
+			"  0   22 * *  * ": {at(22, 0): true},
+		} {
+			t.Run(expression, func(t *testing.T) {
+				// when
+				window, err := IdlingSchedule{Schedule: expression}.parse()
+
+				// then
+				require.NoError(t, err)
+				for moment, starts := range expected {
+					assert.Equal(t, starts, window.schedule.Next(moment.Add(-time.Minute)).Equal(moment), moment.String())
+				}
+			})
+		}
+	})
+
+	t.Run("invalid expressions", func(t *testing.T) {
+		for _, expression := range []string{
+			"",
+			"* * * *",
+			"* * * * * *",
+			"60 * * * *",
+			"* 24 * * *",
+			"* * 0 * *",
+			"* * * 13 *",
+			"* * * * 8",
+			"@daily",
+			"@every 1h",
+			"5-1 * * * *",
+			"*/0 * * * *",
+			"a * * * *",
+			"1-b * * * *",
+		} {
+			t.Run(expression, func(t *testing.T) {
+				// when
+				_, err := IdlingSchedule{Schedule: expression}.parse()
+
+				// then
+				require.ErrorContains(t, err, "invalid cron expression")
+			})
+		}
+	})
+}
+
+func TestIdlingWindow(t *testing.T) {
+	// given
+	now := time.Date(2025, time.January, 15, 23, 30, 0, 0, time.UTC)
+
+	t.Run("window is active", func(t *testing.T) {
+		// given
+		window, err := IdlingSchedule{Schedule: "0 22 * * *", Duration: "2h"}.parse()
+		require.NoError(t, err)
+
+		// then
+		assert.True(t, window.isActive(now))
+		assert.True(t, window.isActive(now.Add(29*time.Minute)))
+		assert.False(t, window.isActive(now.Add(30*time.Minute)))
+		end, active := window.activeUntil(now)
+		require.True(t, active)
+		assert.Equal(t, time.Date(2025, time.January, 16, 0, 0, 0, 0, time.UTC), end)
+	})
+
+	t.Run("window started several times within its duration", func(t *testing.T) {
+		// given
+		window, err := IdlingSchedule{Schedule: "*/15 * * * *", Duration: "1h"}.parse()
+		require.NoError(t, err)
+
+		// then
+		for moment, expectedEnd := range map[time.Time]time.Time{
+			now:                       time.Date(2025, time.January, 16, 0, 30, 0, 0, time.UTC),
+			now.Add(7 * time.Minute):  time.Date(2025, time.January, 16, 0, 30, 0, 0, time.UTC),
+			now.Add(15 * time.Minute): time.Date(2025, time.January, 16, 0, 45, 0, 0, time.UTC),
+			now.Add(-1 * time.Second): time.Date(2025, time.January, 16, 0, 15, 0, 0, time.UTC),
+		} {
+			end, active := window.activeUntil(moment)
+			require.True(t, active, moment.String())
+			assert.Equal(t, expectedEnd, end, moment.String())
+		}
+	})
+
+	t.Run("window is not active", func(t *testing.T) {
+		// given
+		window, err := IdlingSchedule{Schedule: "0 22 * * *", Duration: "1h"}.parse()
+		require.NoError(t, err)
+
+		// then
+		assert.False(t, window.isActive(now))
+		nextStart, found := window.nextStart(now)
+		require.True(t, found)
+		assert.Equal(t, time.Date(2025, time.January, 16, 22, 0, 0, 0, time.UTC), nextStart)
+	})
+
+	t.Run("default duration", func(t *testing.T) {
+		// when
+		window, err := IdlingSchedule{Schedule: "0 22 * * *"}.parse()
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 8*time.Hour, window.duration)
+	})
+
+	t.Run("next start of rare schedules", func(t *testing.T) {
+		// given
+		window, err := IdlingSchedule{Schedule: "0 0 1 1 *"}.parse()
+		require.NoError(t, err)
+
+		// when
+		nextStart, found := window.nextStart(now)
+
+		// then
+		require.True(t, found)
+		assert.Equal(t, time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), nextStart)
+		assert.False(t, window.isActive(now))
+	})
+
+	t.Run("next start is not known when the schedule never matches", func(t *testing.T) {
+		// given
+		window, err := IdlingSchedule{Schedule: "0 0 30 2 *"}.parse()
+		require.NoError(t, err)
+
+		// when
+		_, found := window.nextStart(now)
+
+		// then
+		assert.False(t, found)
+		assert.False(t, window.isActive(now))
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		for _, duration := range []string{"forever", "-1h", "8d", "169h"} {
+			_, err := IdlingSchedule{Schedule: "0 22 * * *", Duration: duration}.parse()
+			assert.Error(t, err, duration)
+		}
+	})
+}
+
+func TestIdlingScheduleOfIdler(t *testing.T) {
+	// given
+	cfg := NewConfig(ConfigSpec{IdlingSchedules: map[string]IdlingSchedule{
+		"base": {Schedule: "0 22 * * *", Duration: "10h"},
+	}})
+	newIdler := func(tier string, annotations map[string]string) *toolchainv1alpha1.Idler {
+		return &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{
+			Name:        "john-dev",
+			Labels:      map[string]string{toolchainv1alpha1.TierLabelKey: tier},
+			Annotations: annotations,
+		}}
+	}
+
+	t.Run("configured for the tier", func(t *testing.T) {
+		// when
+		schedule, found := cfg.idlingSchedule(newIdler("base", nil))
+
+		// then
+		require.True(t, found)
+		assert.Equal(t, IdlingSchedule{Schedule: "0 22 * * *", Duration: "10h"}, schedule)
+	})
+
+	t.Run("annotation takes precedence", func(t *testing.T) {
+		// when
+		schedule, found := cfg.idlingSchedule(newIdler("base", map[string]string{
+			IdlingScheduleAnnotationKey:         "0 20 * * 1-5",
+			IdlingScheduleDurationAnnotationKey: "12h",
+		}))
+
+		// then
+		require.True(t, found)
+		assert.Equal(t, IdlingSchedule{Schedule: "0 20 * * 1-5", Duration: "12h"}, schedule)
+	})
+
+	t.Run("no schedule for other tiers", func(t *testing.T) {
+		// when
+		_, found := cfg.idlingSchedule(newIdler("advanced", nil))
+
+		// then
+		assert.False(t, found)
+	})
+}
+
+func TestScheduledIdling(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+				toolchainv1alpha1.TierLabelKey:  "base",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: TestIdlerTimeOutSeconds},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	fresh := &metav1.Time{Time: time.Now()}
+
+	t.Run("all workloads are idled in the active window", func(t *testing.T) {
+		// given
+		scheduledIdler := idler.DeepCopy()
+		scheduledIdler.Annotations = map[string]string{
+			IdlingScheduleAnnotationKey:         "* * * * *",
+			IdlingScheduleDurationAnnotationKey: "1h",
+		}
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, scheduledIdler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, fresh, nil, noRestart())
+		notStartedPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "not-started", Namespace: idler.Name}}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), notStartedPod))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment).
+			ReplicaSetScaledUp(replicaSet).
+			PodsDoNotExist([]*corev1.Pod{notStartedPod})
+		assert.Equal(t, string(idleReasonScheduled), getDeployment(t, fakeClients, deployment).GetAnnotations()[IdledReasonAnnotationKey])
+	})
+
+	t.Run("workloads are not idled outside the window", func(t *testing.T) {
+		// given
+		nextStart := time.Now().UTC().Add(30 * time.Minute)
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{IdlingSchedules: map[string]IdlingSchedule{
+			"base": {Schedule: fmt.Sprintf("%d %d * * *", nextStart.Minute(), nextStart.Hour()), Duration: "1h"},
+		}})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, fresh, nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		// requeued to the start of the window
+		assert.LessOrEqual(t, res.RequeueAfter, 30*time.Minute)
+		assert.Greater(t, res.RequeueAfter, 28*time.Minute)
+	})
+
+	t.Run("invalid schedule is ignored", func(t *testing.T) {
+		// given
+		scheduledIdler := idler.DeepCopy()
+		scheduledIdler.Annotations = map[string]string{IdlingScheduleAnnotationKey: "every night"}
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, scheduledIdler, nsTmplSet, mur)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, fresh, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+	})
+}
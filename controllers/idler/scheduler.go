@@ -0,0 +1,411 @@
+package idler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/webhook/mutatingwebhook"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// schedulerResolution is the duration of one slot of the time wheel - the deadlines are fired with this precision
+	schedulerResolution = time.Second
+	// schedulerSlots is the number of the slots of the time wheel; the deadlines further in the future than one turn
+	// of the wheel stay in their slot until the wheel gets to them in the right turn
+	schedulerSlots = 3600
+	// idlerDeadline is the key of the deadlines which belong to the Idler itself rather than to any of its pods
+	// (eg. the next activity check, or the start of the idling window)
+	idlerDeadline = ""
+)
+
+// deadlineKey identifies a deadline: the name of the Idler and the name of the pod (or idlerDeadline)
+type deadlineKey struct {
+	idler string
+	pod   string
+}
+
+// idlingScheduler is a hashed time wheel which keeps the next deadline of every pod watched by the idler
+// and enqueues the Idler only when one of its deadlines is due, so the Idlers don't have to be requeued
+// periodically and the workqueue carries only real work. The names of the due pods are kept until the reconcile
+// of the Idler takes them, so only these pods are evaluated.
+// The deadlines are populated from the pod informer and refined by every reconcile of the Idler.
+type idlingScheduler struct {
+	mu sync.Mutex
+	// slots contain the deadlines hashed by their time
+	slots [schedulerSlots]map[deadlineKey]time.Time
+	// deadlines contain the deadlines of every Idler by the pod name, so they can be found without scanning the slots
+	deadlines map[string]map[string]time.Time
+	// duePods contain the names of the due pods of every Idler (or idlerDeadline) which were not taken by the reconcile yet
+	duePods map[string]map[string]bool
+	// timeouts contain the timeouts of the Idlers set by their reconciles, so the deadlines of the pods can be scheduled
+	// without fetching the Idlers
+	timeouts map[string]int32
+	// idlingWindowEnds contain the ends of the active idling windows of the Idlers set by their reconciles,
+	// so the pods created in the window are idled without waiting for their deadlines
+	idlingWindowEnds map[string]time.Time
+	// scaleUpWatchEnds contain the ends of the fighting windows of the Idlers which idled some owners, so the pods created
+	// in the window are evaluated without waiting for their deadlines and the owners scaled up again are detected right away
+	scaleUpWatchEnds map[string]time.Time
+	// processedUntil is the time up to which the slots were already processed
+	processedUntil time.Time
+	events         chan event.TypedGenericEvent[*toolchainv1alpha1.Idler]
+}
+
+func newIdlingScheduler() *idlingScheduler {
+	return &idlingScheduler{
+		deadlines:        map[string]map[string]time.Time{},
+		duePods:          map[string]map[string]bool{},
+		timeouts:         map[string]int32{},
+		idlingWindowEnds: map[string]time.Time{},
+		scaleUpWatchEnds: map[string]time.Time{},
+		processedUntil:   time.Now().Truncate(schedulerResolution),
+		events:           make(chan event.TypedGenericEvent[*toolchainv1alpha1.Idler], 1024),
+	}
+}
+
+// Start fires the due deadlines until the context is done. It implements manager.Runnable.
+func (s *idlingScheduler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(schedulerResolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			for _, idlerName := range s.due(now) {
+				select {
+				case s.events <- event.TypedGenericEvent[*toolchainv1alpha1.Idler]{
+					Object: &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: idlerName}},
+				}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// schedule sets the deadline of the given pod of the Idler (or of the Idler itself when the pod name is idlerDeadline).
+// If there is already an earlier deadline for the same pod, then it's kept.
+func (s *idlingScheduler) schedule(idlerName, podName string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := deadlineKey{idler: idlerName, pod: podName}
+	// round the deadline up to the resolution, so it's not missed when its slot is processed
+	if rounded := at.Truncate(schedulerResolution); rounded.Before(at) {
+		at = rounded.Add(schedulerResolution)
+	}
+	if current, found := s.deadlines[idlerName][podName]; found {
+		if !current.After(at) {
+			return
+		}
+		delete(s.slots[slotOf(current)], key)
+	}
+	// the deadlines which are already due are fired with the next tick
+	if next := s.processedUntil.Add(schedulerResolution); at.Before(next) {
+		at = next
+	}
+	if s.deadlines[idlerName] == nil {
+		s.deadlines[idlerName] = map[string]time.Time{}
+	}
+	s.deadlines[idlerName][podName] = at
+	slot := slotOf(at)
+	if s.slots[slot] == nil {
+		s.slots[slot] = map[deadlineKey]time.Time{}
+	}
+	s.slots[slot][key] = at
+}
+
+// scheduleAfter sets the deadline of the given pod of the Idler to the given duration from now
+func (s *idlingScheduler) scheduleAfter(idlerName, podName string, after time.Duration) {
+	s.schedule(idlerName, podName, time.Now().Add(after))
+}
+
+// unschedulePod removes the deadline of the given pod of the Idler
+func (s *idlingScheduler) unschedulePod(idlerName, podName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at, found := s.deadlines[idlerName][podName]; found {
+		delete(s.slots[slotOf(at)], deadlineKey{idler: idlerName, pod: podName})
+		delete(s.deadlines[idlerName], podName)
+		if len(s.deadlines[idlerName]) == 0 {
+			delete(s.deadlines, idlerName)
+		}
+	}
+}
+
+// unscheduleIdler removes all deadlines, due pods, the timeout, the idling window and the scale-up watch of the Idler
+func (s *idlingScheduler) unscheduleIdler(idlerName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for podName, at := range s.deadlines[idlerName] {
+		delete(s.slots[slotOf(at)], deadlineKey{idler: idlerName, pod: podName})
+	}
+	delete(s.deadlines, idlerName)
+	delete(s.duePods, idlerName)
+	delete(s.timeouts, idlerName)
+	delete(s.idlingWindowEnds, idlerName)
+	delete(s.scaleUpWatchEnds, idlerName)
+}
+
+// setTimeout sets the timeout of the Idler which is used for the deadlines of its pods.
+// Returns true if the timeout differs from the previously set one.
+func (s *idlingScheduler) setTimeout(idlerName string, timeoutSeconds int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, found := s.timeouts[idlerName]
+	s.timeouts[idlerName] = timeoutSeconds
+	return !found || previous != timeoutSeconds
+}
+
+// timeout returns the timeout of the Idler set by its last reconcile, or false if there is none
+func (s *idlingScheduler) timeout(idlerName string) (int32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timeoutSeconds, found := s.timeouts[idlerName]
+	return timeoutSeconds, found
+}
+
+// setIdlingWindowEnd sets the end of the active idling window of the Idler. The zero time means that the window is not active.
+func (s *idlingScheduler) setIdlingWindowEnd(idlerName string, end time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end.IsZero() {
+		delete(s.idlingWindowEnds, idlerName)
+		return
+	}
+	s.idlingWindowEnds[idlerName] = end
+}
+
+// inIdlingWindow returns true if the idling window of the Idler set by its last reconcile is active at the given time
+func (s *idlingScheduler) inIdlingWindow(idlerName string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end, found := s.idlingWindowEnds[idlerName]
+	return found && now.Before(end)
+}
+
+// watchScaleUps makes the pods of the Idler with a controller created before the given time evaluated right away
+func (s *idlingScheduler) watchScaleUps(idlerName string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until.After(s.scaleUpWatchEnds[idlerName]) {
+		s.scaleUpWatchEnds[idlerName] = until
+	}
+}
+
+// watchingScaleUps returns true if the scale-ups of the owners of the Idler are watched at the given time
+func (s *idlingScheduler) watchingScaleUps(idlerName string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end, found := s.scaleUpWatchEnds[idlerName]
+	if found && !now.Before(end) {
+		delete(s.scaleUpWatchEnds, idlerName)
+		return false
+	}
+	return found
+}
+
+// markDue marks the given pod of the Idler as due, so it's evaluated by the next reconcile of the Idler
+func (s *idlingScheduler) markDue(idlerName, podName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markDueLocked(idlerName, podName)
+}
+
+func (s *idlingScheduler) markDueLocked(idlerName, podName string) {
+	if s.duePods[idlerName] == nil {
+		s.duePods[idlerName] = map[string]bool{}
+	}
+	s.duePods[idlerName][podName] = true
+}
+
+// takeDuePods removes and returns the names of the due pods of the Idler. Returns nil if all pods of the Idler should be
+// evaluated - that is if the deadline of the Idler itself is due, or if the reconcile was not triggered by any deadline.
+func (s *idlingScheduler) takeDuePods(idlerName string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	duePods := s.duePods[idlerName]
+	delete(s.duePods, idlerName)
+	if duePods[idlerDeadline] {
+		return nil
+	}
+	return duePods
+}
+
+// mapDuePodToIdler marks the pod as due and maps it to its Idler, so the reconcile evaluates the pod
+// even if it was merged with the reconcile triggered by the deadlines of other pods
+func (s *idlingScheduler) mapDuePodToIdler(ctx context.Context, pod *corev1.Pod) []reconcile.Request {
+	s.markDue(pod.Namespace, pod.Name)
+	return MapPodToIdler(ctx, pod)
+}
+
+// due removes all deadlines which are due at the given time, marks their pods as due and returns the names of their Idlers (each only once)
+func (s *idlingScheduler) due(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = now.Truncate(schedulerResolution)
+	var idlerNames []string
+	dueIdlers := map[string]bool{}
+	// if the ticks were delayed for more than one turn of the wheel, then processing all slots once is enough
+	for at, processed := s.processedUntil.Add(schedulerResolution), 0; !at.After(now) && processed < schedulerSlots; at, processed = at.Add(schedulerResolution), processed+1 {
+		slot := slotOf(at)
+		for key, deadline := range s.slots[slot] {
+			if deadline.After(now) {
+				// belongs to one of the next turns of the wheel
+				continue
+			}
+			delete(s.slots[slot], key)
+			s.markDueLocked(key.idler, key.pod)
+			delete(s.deadlines[key.idler], key.pod)
+			if len(s.deadlines[key.idler]) == 0 {
+				delete(s.deadlines, key.idler)
+			}
+			if !dueIdlers[key.idler] {
+				dueIdlers[key.idler] = true
+				idlerNames = append(idlerNames, key.idler)
+			}
+		}
+	}
+	if now.After(s.processedUntil) {
+		s.processedUntil = now
+	}
+	return idlerNames
+}
+
+func slotOf(at time.Time) int {
+	return int((at.UnixNano() / int64(schedulerResolution)) % schedulerSlots)
+}
+
+// scheduleAfter sets the deadline of the given pod of the Idler if the central scheduler is used
+func (r *Reconciler) scheduleAfter(idlerName, podName string, after time.Duration) {
+	if r.scheduler != nil {
+		r.scheduler.scheduleAfter(idlerName, podName, after)
+	}
+}
+
+// unscheduleIdler removes all deadlines of the Idler if the central scheduler is used
+func (r *Reconciler) unscheduleIdler(idlerName string) {
+	if r.scheduler != nil {
+		r.scheduler.unscheduleIdler(idlerName)
+	}
+}
+
+// watchScaleUps makes the new pods of the Idler evaluated right away until the given time if the central scheduler is used
+func (r *Reconciler) watchScaleUps(idlerName string, until time.Time) {
+	if r.scheduler != nil {
+		r.scheduler.watchScaleUps(idlerName, until)
+	}
+}
+
+// setIdlingWindowEnd sets the end of the active idling window of the Idler if the central scheduler is used
+func (r *Reconciler) setIdlingWindowEnd(idlerName string, end time.Time) {
+	if r.scheduler != nil {
+		r.scheduler.setIdlingWindowEnd(idlerName, end)
+	}
+}
+
+// takeDuePods returns the names of the pods of the Idler which should be evaluated by the reconcile,
+// or nil if all of them should be - which is always the case when the central scheduler is not used,
+// or when the timeout of the Idler changed since the last reconcile
+func (r *Reconciler) takeDuePods(idler *toolchainv1alpha1.Idler) map[string]bool {
+	if r.scheduler == nil {
+		return nil
+	}
+	timeoutChanged := r.scheduler.setTimeout(idler.Name, idler.Spec.TimeoutSeconds)
+	duePods := r.scheduler.takeDuePods(idler.Name)
+	if timeoutChanged {
+		return nil
+	}
+	return duePods
+}
+
+var _ handler.TypedEventHandler[*corev1.Pod, reconcile.Request] = &podDeadlineHandler{}
+
+// podDeadlineHandler feeds the scheduler with the deadlines of the pods observed by the pod informer.
+// It never enqueues the Idler itself - that's done by the scheduler when the deadline is due.
+type podDeadlineHandler struct {
+	scheduler *idlingScheduler
+	getConfig func() Config
+}
+
+// Create schedules the deadline of the new pod. The new pods of the owners which might have been scaled up again after
+// being idled are evaluated right away, so the controllers fighting the idler are detected.
+func (h *podDeadlineHandler) Create(_ context.Context, evt event.TypedCreateEvent[*corev1.Pod], _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	pod := evt.Object
+	if pod.Spec.PriorityClassName == mutatingwebhook.PriorityClassName && metav1.GetControllerOf(pod) != nil &&
+		h.scheduler.watchingScaleUps(pod.Namespace, time.Now()) {
+		h.scheduler.scheduleAfter(pod.Namespace, pod.Name, 0)
+	}
+	h.schedulePod(pod)
+}
+
+// Update schedules the deadline of the pod, eg. when its start time is set
+func (h *podDeadlineHandler) Update(_ context.Context, evt event.TypedUpdateEvent[*corev1.Pod], _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.schedulePod(evt.ObjectNew)
+}
+
+// Delete removes the deadline of the deleted pod
+func (h *podDeadlineHandler) Delete(_ context.Context, evt event.TypedDeleteEvent[*corev1.Pod], _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.scheduler.unschedulePod(evt.Object.GetNamespace(), evt.Object.GetName())
+}
+
+// Generic does nothing
+func (h *podDeadlineHandler) Generic(_ context.Context, _ event.TypedGenericEvent[*corev1.Pod], _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+// schedulePod schedules the deadline of the pod based on its start time (or on its creation time if the pod is pending). The deadline doesn't take the activity of the pod
+// nor the timeout multipliers of the indirect owners into account, so it might be earlier than the real one - in that case
+// the reconcile of the Idler schedules the real deadline.
+func (h *podDeadlineHandler) schedulePod(pod *corev1.Pod) {
+	// we care only about the pods running in users' namespaces
+	if pod.Spec.PriorityClassName != mutatingwebhook.PriorityClassName {
+		return
+	}
+	// the pods created (or started) while the idling window of the Idler is active are idled right away
+	if h.scheduler.inIdlingWindow(pod.Namespace, time.Now()) {
+		if pod.DeletionTimestamp == nil {
+			h.scheduler.scheduleAfter(pod.Namespace, pod.Name, 0)
+		}
+		return
+	}
+	config := configOrDefault(h.getConfig)
+	untilTimeout, pending := untilPendingTimeout(pod, config.PendingTimeout())
+	if pod.Status.StartTime == nil && !pending {
+		return
+	}
+	// the idler has the same name as the user's namespace. If its timeout is not known yet, then
+	// the deadline is scheduled by the first reconcile of the Idler.
+	idlerTimeout, found := h.scheduler.timeout(pod.Namespace)
+	if !found || idlerTimeout <= 0 {
+		return
+	}
+	if pending {
+		h.scheduler.scheduleAfter(pod.Namespace, pod.Name, untilTimeout)
+	}
+	if pod.Status.StartTime == nil {
+		return
+	}
+	timeoutSeconds := float64(idlerTimeout)
+	for _, owner := range pod.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller {
+			if multiplier, found := config.TimeoutMultipliers()[owner.Kind]; found {
+				timeoutSeconds = math.Round(timeoutSeconds * multiplier)
+			}
+		}
+	}
+	timeout := time.Duration(timeoutSeconds+1) * time.Second
+	if warningPercentage := config.PreIdleWarningPercentage(); warningPercentage > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second * time.Duration(warningPercentage) / 100
+	}
+	h.scheduler.schedule(pod.Namespace, pod.Name, pod.Status.StartTime.Add(timeout))
+}
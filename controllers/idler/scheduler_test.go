@@ -0,0 +1,481 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/webhook/mutatingwebhook"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestIdlingScheduler(t *testing.T) {
+	// given
+	now := time.Date(2025, time.January, 15, 10, 0, 0, 0, time.UTC)
+	newScheduler := func() *idlingScheduler {
+		scheduler := newIdlingScheduler()
+		scheduler.processedUntil = now
+		return scheduler
+	}
+
+	t.Run("deadlines are fired when due", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-dev", "pod-b", now.Add(20*time.Second))
+		scheduler.schedule("john-stage", "pod-a", now.Add(10*time.Second))
+
+		// when & then
+		assert.Empty(t, scheduler.due(now.Add(9*time.Second)))
+		assert.ElementsMatch(t, []string{"john-dev", "john-stage"}, scheduler.due(now.Add(10*time.Second)))
+		assert.Equal(t, []string{"john-dev"}, scheduler.due(now.Add(25*time.Second)))
+		assert.Empty(t, scheduler.deadlines)
+	})
+
+	t.Run("deadlines are rounded up to the resolution", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second+time.Millisecond))
+
+		// when & then
+		assert.Empty(t, scheduler.due(now.Add(10*time.Second)))
+		assert.Equal(t, []string{"john-dev"}, scheduler.due(now.Add(11*time.Second)))
+	})
+
+	t.Run("earlier deadline is kept", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(time.Minute))
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+
+		// when
+		scheduler.schedule("john-dev", "pod-a", now.Add(30*time.Second))
+
+		// then
+		assert.Equal(t, []string{"john-dev"}, scheduler.due(now.Add(10*time.Second)))
+		assert.Empty(t, scheduler.due(now.Add(2*time.Minute)))
+	})
+
+	t.Run("deadlines in the past are fired with the next tick", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+
+		// when
+		scheduler.schedule("john-dev", "pod-a", now.Add(-time.Hour))
+
+		// then
+		assert.Equal(t, []string{"john-dev"}, scheduler.due(now.Add(time.Second)))
+	})
+
+	t.Run("deadlines after one turn of the wheel", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(schedulerSlots*schedulerResolution+5*time.Second))
+
+		// when & then
+		assert.Empty(t, scheduler.due(now.Add(5*time.Second)))
+		assert.Empty(t, scheduler.due(now.Add(time.Hour)))
+		assert.Equal(t, []string{"john-dev"}, scheduler.due(now.Add(time.Hour+5*time.Second)))
+	})
+
+	t.Run("delayed ticks fire all missed deadlines", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-stage", "pod-a", now.Add(90*time.Minute))
+
+		// when & then
+		assert.ElementsMatch(t, []string{"john-dev", "john-stage"}, scheduler.due(now.Add(3*time.Hour)))
+	})
+
+	t.Run("unschedule", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-dev", idlerDeadline, now.Add(10*time.Second))
+		scheduler.schedule("john-stage", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-stage", "pod-b", now.Add(10*time.Second))
+
+		// when
+		scheduler.unscheduleIdler("john-dev")
+		scheduler.unschedulePod("john-stage", "pod-a")
+
+		// then
+		assert.Equal(t, map[string]map[string]time.Time{"john-stage": {"pod-b": now.Add(10 * time.Second)}}, scheduler.deadlines)
+		assert.Equal(t, []string{"john-stage"}, scheduler.due(now.Add(10*time.Second)))
+	})
+
+	t.Run("due pods are taken once", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-dev", "pod-b", now.Add(time.Minute))
+		scheduler.markDue("john-dev", "pod-c")
+		scheduler.due(now.Add(10 * time.Second))
+
+		// when
+		duePods := scheduler.takeDuePods("john-dev")
+
+		// then
+		assert.Equal(t, map[string]bool{"pod-a": true, "pod-c": true}, duePods)
+		assert.Nil(t, scheduler.takeDuePods("john-dev"))
+	})
+
+	t.Run("all pods are due with the deadline of the Idler", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+		scheduler.schedule("john-dev", "pod-a", now.Add(10*time.Second))
+		scheduler.schedule("john-dev", idlerDeadline, now.Add(10*time.Second))
+		scheduler.due(now.Add(10 * time.Second))
+
+		// when
+		duePods := scheduler.takeDuePods("john-dev")
+
+		// then
+		assert.Nil(t, duePods)
+	})
+
+	t.Run("timeout change is reported", func(t *testing.T) {
+		// given
+		scheduler := newScheduler()
+
+		// when & then
+		assert.True(t, scheduler.setTimeout("john-dev", 3600))
+		assert.False(t, scheduler.setTimeout("john-dev", 3600))
+		assert.True(t, scheduler.setTimeout("john-dev", 60))
+		timeout, found := scheduler.timeout("john-dev")
+		assert.True(t, found)
+		assert.Equal(t, int32(60), timeout)
+		scheduler.unscheduleIdler("john-dev")
+		_, found = scheduler.timeout("john-dev")
+		assert.False(t, found)
+	})
+
+	t.Run("start sends the events of the due Idlers", func(t *testing.T) {
+		// given
+		scheduler := newIdlingScheduler()
+		scheduler.scheduleAfter("john-dev", "pod-a", 0)
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		// when
+		go func() {
+			_ = scheduler.Start(ctx)
+		}()
+
+		// then
+		select {
+		case evt := <-scheduler.events:
+			assert.Equal(t, "john-dev", evt.Object.Name)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the Idler was not enqueued")
+		}
+	})
+}
+
+func TestPodDeadlineHandler(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{Name: "john-dev"},
+		Spec:       toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	startTime := time.Date(2025, time.January, 15, 10, 0, 0, 0, time.UTC)
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "john-dev"},
+			Spec:       corev1.PodSpec{PriorityClassName: mutatingwebhook.PriorityClassName},
+			Status:     corev1.PodStatus{StartTime: &metav1.Time{Time: startTime}},
+		}
+	}
+	newHandler := func(config Config) *podDeadlineHandler {
+		scheduler := newIdlingScheduler()
+		scheduler.processedUntil = startTime
+		scheduler.setTimeout(idler.Name, idler.Spec.TimeoutSeconds)
+		return &podDeadlineHandler{scheduler: scheduler, getConfig: func() Config { return config }}
+	}
+
+	t.Run("deadline of the started pod is scheduled", func(t *testing.T) {
+		// given
+		handler := newHandler(Config{})
+		notStarted := newPod("not-started")
+		notStarted.Status.StartTime = nil
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: notStarted}, nil)
+		handler.Update(context.TODO(), event.TypedUpdateEvent[*corev1.Pod]{ObjectOld: notStarted, ObjectNew: newPod("started")}, nil)
+
+		// then
+		assert.Equal(t, map[string]map[string]time.Time{"john-dev": {"started": startTime.Add(3601 * time.Second)}}, handler.scheduler.deadlines)
+	})
+
+	t.Run("timeout multiplier and warning are taken into account", func(t *testing.T) {
+		// given
+		handler := newHandler(NewConfig(ConfigSpec{PreIdleWarningPercentage: ptr.To(80)}))
+		vmPod := newPod("vm-pod")
+		vmPod.OwnerReferences = []metav1.OwnerReference{{Kind: "VirtualMachineInstance", Name: "vm", Controller: ptr.To(true)}}
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: vmPod}, nil)
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: newPod("regular-pod")}, nil)
+
+		// then
+		assert.Equal(t, startTime.Add(240*time.Second), handler.scheduler.deadlines["john-dev"]["vm-pod"])
+		assert.Equal(t, startTime.Add(2880*time.Second), handler.scheduler.deadlines["john-dev"]["regular-pod"])
+	})
+
+	t.Run("deadline of the pending pod is scheduled when the pending timeout is set", func(t *testing.T) {
+		// given
+		handler := newHandler(NewConfig(ConfigSpec{PendingTimeout: ptr.To("30m")}))
+		pending := newPod("pending")
+		pending.CreationTimestamp = metav1.Time{Time: time.Now()}
+		pending.Status = corev1.PodStatus{Phase: corev1.PodPending}
+		notPending := newPod("not-pending")
+		notPending.Status.StartTime = nil
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: pending}, nil)
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: notPending}, nil)
+
+		// then
+		require.Len(t, handler.scheduler.deadlines["john-dev"], 1)
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), handler.scheduler.deadlines["john-dev"]["pending"], 2*time.Second)
+	})
+
+	t.Run("pods outside of the users' namespaces or without known Idler timeout are ignored", func(t *testing.T) {
+		// given
+		handler := newHandler(Config{})
+		otherPod := newPod("other-pod")
+		otherPod.Spec.PriorityClassName = "some-class"
+		noIdlerPod := newPod("no-idler-pod")
+		noIdlerPod.Namespace = "john-stage"
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: otherPod}, nil)
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: noIdlerPod}, nil)
+
+		// then
+		assert.Empty(t, handler.scheduler.deadlines)
+	})
+
+	t.Run("pod created in the active idling window is due right away", func(t *testing.T) {
+		// given
+		handler := newHandler(Config{})
+		handler.scheduler.setIdlingWindowEnd(idler.Name, time.Now().Add(time.Hour))
+		notStarted := newPod("not-started")
+		notStarted.Status.StartTime = nil
+		terminating := newPod("terminating")
+		terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: notStarted}, nil)
+		handler.Update(context.TODO(), event.TypedUpdateEvent[*corev1.Pod]{ObjectOld: terminating, ObjectNew: terminating}, nil)
+
+		// then
+		require.Len(t, handler.scheduler.deadlines["john-dev"], 1)
+		assert.WithinDuration(t, time.Now(), handler.scheduler.deadlines["john-dev"]["not-started"], 2*time.Second)
+
+		t.Run("regular deadline after the window ended", func(t *testing.T) {
+			// given
+			handler := newHandler(Config{})
+			handler.scheduler.setIdlingWindowEnd(idler.Name, time.Now().Add(-time.Minute))
+
+			// when
+			handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: newPod("started")}, nil)
+
+			// then
+			assert.Equal(t, map[string]map[string]time.Time{"john-dev": {"started": startTime.Add(3601 * time.Second)}}, handler.scheduler.deadlines)
+		})
+	})
+
+	t.Run("new pod of an owner is due right away while the scale-ups are watched", func(t *testing.T) {
+		// given
+		handler := newHandler(Config{})
+		handler.scheduler.watchScaleUps(idler.Name, time.Now().Add(time.Hour))
+		owned := newPod("owned")
+		owned.Status.StartTime = nil
+		owned.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", UID: "web-uid", Controller: ptr.To(true)}}
+		standalone := newPod("standalone")
+		standalone.Status.StartTime = nil
+
+		// when
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: owned}, nil)
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: standalone}, nil)
+
+		// then
+		require.Len(t, handler.scheduler.deadlines["john-dev"], 1)
+		assert.WithinDuration(t, time.Now(), handler.scheduler.deadlines["john-dev"]["owned"], 2*time.Second)
+
+		t.Run("regular deadline after the watch ended", func(t *testing.T) {
+			// given
+			handler := newHandler(Config{})
+			handler.scheduler.watchScaleUps(idler.Name, time.Now().Add(-time.Minute))
+			started := newPod("started")
+			started.OwnerReferences = owned.OwnerReferences
+
+			// when
+			handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: started}, nil)
+
+			// then
+			assert.Equal(t, map[string]map[string]time.Time{"john-dev": {"started": startTime.Add(3601 * time.Second)}}, handler.scheduler.deadlines)
+			assert.Empty(t, handler.scheduler.scaleUpWatchEnds)
+		})
+	})
+
+	t.Run("deadline of the deleted pod is removed", func(t *testing.T) {
+		// given
+		handler := newHandler(Config{})
+		pod := newPod("deleted")
+		handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: pod}, nil)
+
+		// when
+		handler.Delete(context.TODO(), event.TypedDeleteEvent[*corev1.Pod]{Object: pod}, nil)
+
+		// then
+		assert.Empty(t, handler.scheduler.deadlines)
+	})
+}
+
+func TestReconcileWithScheduler(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "alex-stage",
+			Labels: map[string]string{toolchainv1alpha1.SpaceLabelKey: "alex"},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+
+	t.Run("deadlines of the pods are scheduled instead of requeue", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.scheduler = newIdlingScheduler()
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		startTime := time.Now().Add(-30 * time.Minute)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: startTime}, nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{}, res)
+		deadlines := reconciler.scheduler.deadlines[idler.Name]
+		require.Len(t, deadlines, len(pods)+1)
+		for _, pod := range pods {
+			assert.WithinDuration(t, startTime.Add(3601*time.Second), deadlines[pod.Name], 2*time.Second)
+		}
+		assert.WithinDuration(t, time.Now().Add(time.Hour), deadlines[idlerDeadline], 2*time.Second)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			PodsExist(pods)
+	})
+
+	t.Run("only the due pods are evaluated", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.scheduler = newIdlingScheduler()
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		timedOut := &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+		notDueDeployment, notDueReplicaSet := createDeployment(t, fakeClients, idler.Name, "", "-not-due", nil)
+		createPods(t, fakeClients.AllNamespacesClient, notDueReplicaSet, timedOut, nil, noRestart())
+		dueDeployment, dueReplicaSet := createDeployment(t, fakeClients, idler.Name, "", "-due", nil)
+		duePods := createPods(t, fakeClients.AllNamespacesClient, dueReplicaSet, timedOut, nil, noRestart())
+		reconciler.scheduler.markDue(idler.Name, duePods[0].Name)
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(dueDeployment).
+			DeploymentScaledUp(notDueDeployment)
+
+		t.Run("all pods are evaluated when the reconcile is not triggered by the scheduler", func(t *testing.T) {
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+				DeploymentScaledDown(notDueDeployment)
+		})
+	})
+
+	t.Run("all pods are evaluated when the timeout changes", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.scheduler = newIdlingScheduler()
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: time.Now().Add(-30 * time.Minute)}, nil, noRestart())
+		reconciler.scheduler.markDue(idler.Name, "other-pod")
+		current := &toolchainv1alpha1.Idler{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: idler.Name}, current))
+		current.Spec.TimeoutSeconds = 60
+		require.NoError(t, fakeClients.DefaultClient.Update(context.TODO(), current))
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+
+	t.Run("pod created in the middle of the idling window is idled right away", func(t *testing.T) {
+		// given
+		scheduledIdler := idler.DeepCopy()
+		scheduledIdler.Annotations = map[string]string{IdlingScheduleAnnotationKey: "* * * * *"}
+		reconciler, req, fakeClients := prepareReconcile(t, scheduledIdler.Name, getHostCluster, scheduledIdler, nsTmplSet, mur)
+		reconciler.scheduler = newIdlingScheduler()
+		handler := &podDeadlineHandler{scheduler: reconciler.scheduler, getConfig: reconciler.GetConfig}
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, &metav1.Time{Time: time.Now()}, nil, noRestart())
+		for _, pod := range pods {
+			pod.Spec.PriorityClassName = mutatingwebhook.PriorityClassName
+			handler.Create(context.TODO(), event.TypedCreateEvent[*corev1.Pod]{Object: pod}, nil)
+		}
+
+		// when
+		dueIdlers := reconciler.scheduler.due(time.Now().Add(2 * time.Second))
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []string{idler.Name}, dueIdlers)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+	})
+
+	t.Run("deadlines are removed when the Idler is gone", func(t *testing.T) {
+		// given
+		reconciler, req, _ := prepareReconcile(t, idler.Name, getHostCluster, nsTmplSet, mur)
+		reconciler.scheduler = newIdlingScheduler()
+		reconciler.scheduler.scheduleAfter(idler.Name, "some-pod", time.Hour)
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{}, res)
+		assert.Empty(t, reconciler.scheduler.deadlines)
+	})
+}
@@ -0,0 +1,183 @@
+package idler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// notificationEvent is an event of the idler (eg. workloads idled or about to be idled) sent to the notification sinks
+type notificationEvent struct {
+	// Type is the type of the notification, eg. "idled" or "idlerwarning"
+	Type string `json:"type"`
+	// Name is the name of the notification, unique per event
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Space     string `json:"space,omitempty"`
+	// Template is the name of the template of the email sent to the users
+	Template string            `json:"template"`
+	Context  map[string]string `json:"context,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// hostNotificationSink notifies the users of the space the Idler belongs to by creating Notification CRs in the host cluster
+type hostNotificationSink struct {
+	reconciler *Reconciler
+}
+
+// send notifies the users of the space. The notifications which couldn't be created are queued in the notification outbox,
+// and notificationQueued is returned then, so the notification is not reported as delivered before the outbox delivers it.
+func (s *hostNotificationSink) send(ctx context.Context, idler *toolchainv1alpha1.Idler, event notificationEvent) (notificationDelivery, error) {
+	usernames, err := s.reconciler.getSpaceUsernames(ctx, idler)
+	if err != nil {
+		return notificationDelivered, err
+	}
+	if len(usernames) == 0 {
+		// no user found, thus no email sent
+		return notificationDelivered, fmt.Errorf("no user found for the space")
+	}
+
+	entries := make([]outboxEntry, 0, len(usernames))
+	for _, username := range usernames {
+		name := event.Name
+		if len(usernames) > 1 {
+			name = fmt.Sprintf("%s-%s", event.Name, username)
+		}
+		entries = append(entries, outboxEntry{
+			Name:     name,
+			Type:     event.Type,
+			Template: event.Template,
+			Context:  event.Context,
+			Username: username,
+			Idler:    idler.Name,
+		})
+	}
+	return s.reconciler.notificationOutbox().send(ctx, entries)
+}
+
+// WebhookSink defines an HTTP endpoint (eg. a Slack-compatible incoming webhook or an event collector)
+// the events of the idler are posted to as JSON
+type WebhookSink struct {
+	// Name identifies the sink in the logs and in the notification outbox, it must be a valid key of a ConfigMap
+	Name string `json:"name"`
+
+	// URL of the endpoint the events are posted to
+	URL string `json:"url"`
+
+	// SecretRef refers to the key of a Secret in the operator namespace holding the token which is sent
+	// in the Authorization header as a bearer token. No Authorization header is sent when not set.
+	SecretRef *corev1.SecretKeySelector `json:"secretRef,omitempty"`
+
+	// PayloadTemplate is the Go template of the JSON payload, eg. {"text": {{ printf "%s idled in %s" .Context.Workloads .Namespace | json }}}.
+	// The fields of the event (.Type, .Name, .Namespace, .Space, .Template, .Context and .Time) are available in the template,
+	// and the json function encodes a value as JSON. The event itself is posted when not set.
+	PayloadTemplate string `json:"payloadTemplate,omitempty"`
+}
+
+func (s WebhookSink) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if errs := validation.IsConfigMapKey(s.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name '%s': %s", s.Name, strings.Join(errs, ", "))
+	}
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL '%s'", s.URL)
+	}
+	if s.SecretRef != nil && (s.SecretRef.Name == "" || s.SecretRef.Key == "") {
+		return fmt.Errorf("the name and the key of the secret are required")
+	}
+	if _, err := s.payloadTemplate(); err != nil {
+		return fmt.Errorf("invalid payload template: %w", err)
+	}
+	return nil
+}
+
+// payloadTemplate returns the parsed payload template, or nil if it's not set
+func (s WebhookSink) payloadTemplate() (*template.Template, error) {
+	if s.PayloadTemplate == "" {
+		return nil, nil
+	}
+	return template.New(s.Name).Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}).Option("missingkey=zero").Parse(s.PayloadTemplate)
+}
+
+// webhookClient is the HTTP client used by the webhook sinks
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookNotificationSink posts the events of the idler to the configured HTTP endpoint. The events are posted by the notification outbox.
+type webhookNotificationSink struct {
+	client    client.Client
+	namespace string
+	spec      WebhookSink
+}
+
+func (s *webhookNotificationSink) send(ctx context.Context, event notificationEvent) error {
+	payload, err := s.payload(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.spec.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if s.spec.SecretRef != nil {
+		secret := &corev1.Secret{}
+		if err := s.client.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: s.spec.SecretRef.Name}, secret); err != nil {
+			return fmt.Errorf("unable to get the secret of the webhook: %w", err)
+		}
+		token, found := secret.Data[s.spec.SecretRef.Key]
+		if !found {
+			return fmt.Errorf("the secret '%s' doesn't contain the key '%s'", s.spec.SecretRef.Name, s.spec.SecretRef.Key)
+		}
+		request.Header.Set("Authorization", "Bearer "+string(token))
+	}
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// read the body, so the connection can be reused
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("the webhook responded with the status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// payload returns the JSON payload of the event rendered by the payload template (or the event itself if there is no template)
+func (s *webhookNotificationSink) payload(event notificationEvent) ([]byte, error) {
+	tmpl, err := s.spec.payloadTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return json.Marshal(event)
+	}
+	payload := &bytes.Buffer{}
+	if err := tmpl.Execute(payload, event); err != nil {
+		return nil, fmt.Errorf("unable to render the payload of the webhook: %w", err)
+	}
+	if !json.Valid(payload.Bytes()) {
+		return nil, fmt.Errorf("the payload of the webhook is not a valid JSON: %s", payload.String())
+	}
+	return payload.Bytes(), nil
+}
@@ -0,0 +1,254 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWebhookSinks(t *testing.T) {
+	// given
+	secretRef := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "idler-webhook"}, Key: "token"}
+
+	// when
+	sinks := NewConfig(ConfigSpec{WebhookSinks: []WebhookSink{
+		{Name: "collector", URL: "https://events.example.com/idler"},
+		{Name: "slack", URL: "https://hooks.example.com/services/T0/B0/X0", SecretRef: secretRef, PayloadTemplate: `{"text": {{ .Namespace | json }}}`},
+		{URL: "https://events.example.com/idler"},
+		{Name: "no-scheme", URL: "events.example.com/idler"},
+		{Name: "no-secret-key", URL: "https://events.example.com/idler", SecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "idler-webhook"}}},
+		{Name: "invalid-template", URL: "https://events.example.com/idler", PayloadTemplate: `{"text": {{ .Namespace }`},
+		{Name: "invalid name", URL: "https://events.example.com/idler"},
+	}}).WebhookSinks()
+
+	// then
+	require.Len(t, sinks, 2)
+	assert.Equal(t, "collector", sinks[0].Name)
+	assert.Equal(t, "slack", sinks[1].Name)
+}
+
+func TestWebhookNotificationSink(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 60},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "idler-webhook", Namespace: test.MemberOperatorNs},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	secretRef := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "idler-webhook"}, Key: "token"}
+	keysAndVals := map[string]string{"Namespace": idler.Name, "Workloads": "Deployment/web (timeout)"}
+
+	type webhookRequest struct {
+		header http.Header
+		body   []byte
+	}
+	newWebhook := func(t *testing.T, statusCode int) (*httptest.Server, *[]webhookRequest) {
+		var requests []webhookRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			requests = append(requests, webhookRequest{header: r.Header, body: body})
+			w.WriteHeader(statusCode)
+		}))
+		t.Cleanup(server.Close)
+		return server, &requests
+	}
+
+	t.Run("event is posted to the webhook by the outbox after the host notification", func(t *testing.T) {
+		// given
+		server, requests := newWebhook(t, http.StatusOK)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur, secret)
+		reconciler.GetConfig = configFor(ConfigSpec{WebhookSinks: []WebhookSink{{Name: "collector", URL: server.URL, SecretRef: secretRef}}})
+
+		// when
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+		// then
+		require.NoError(t, err)
+		assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled", "alex@test.com")
+		// the webhook is not called during the reconcile
+		assert.Empty(t, *requests)
+		require.Contains(t, queuedNotifications(t, fakeClients.DefaultClient), "webhook.collector.alex-stage-idled")
+
+		t.Run("queued event is posted", func(t *testing.T) {
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), time.Now())
+
+			// then
+			require.NoError(t, err)
+			require.Len(t, *requests, 1)
+			request := (*requests)[0]
+			assert.Equal(t, "Bearer s3cr3t", request.header.Get("Authorization"))
+			assert.Equal(t, "application/json", request.header.Get("Content-Type"))
+			event := notificationEvent{}
+			require.NoError(t, json.Unmarshal(request.body, &event))
+			assert.Equal(t, toolchainv1alpha1.NotificationTypeIdled, event.Type)
+			assert.Equal(t, "alex-stage-idled", event.Name)
+			assert.Equal(t, idler.Name, event.Namespace)
+			assert.Equal(t, "alex", event.Space)
+			assert.Equal(t, idlerTriggeredTemplate, event.Template)
+			assert.Equal(t, keysAndVals, event.Context)
+			assert.False(t, event.Time.IsZero())
+			entry := queuedNotifications(t, fakeClients.DefaultClient)["webhook.collector.alex-stage-idled"]
+			assert.True(t, entry.Delivered)
+			assert.Nil(t, entry.Event)
+		})
+
+		t.Run("event sent again is not posted again", func(t *testing.T) {
+			// when
+			_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+			require.NoError(t, err)
+			err = reconciler.notificationOutbox().retry(context.TODO(), time.Now())
+
+			// then
+			require.NoError(t, err)
+			assert.Len(t, *requests, 1)
+		})
+
+		t.Run("delivered event is removed after the retention period", func(t *testing.T) {
+			// when
+			err := reconciler.notificationOutbox().retry(context.TODO(), time.Now().Add(outboxDeliveredRetention+time.Minute))
+
+			// then
+			require.NoError(t, err)
+			assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+			assert.Len(t, *requests, 1)
+		})
+	})
+
+	t.Run("payload is rendered by the template", func(t *testing.T) {
+		// given
+		server, requests := newWebhook(t, http.StatusOK)
+		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{WebhookSinks: []WebhookSink{{
+			Name:            "slack",
+			URL:             server.URL,
+			PayloadTemplate: `{"text": {{ printf "Workloads %s in %s: %s" .Type .Namespace .Context.Workloads | json }}}`,
+		}}})
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+		require.NoError(t, err)
+
+		// when
+		err = reconciler.notificationOutbox().retry(context.TODO(), time.Now())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, *requests, 1)
+		assert.JSONEq(t, `{"text": "Workloads idled in alex-stage: Deployment/web (timeout)"}`, string((*requests)[0].body))
+		assert.Empty(t, (*requests)[0].header.Get("Authorization"))
+	})
+
+	t.Run("webhook failures are retried and don't affect the host notification", func(t *testing.T) {
+		// given
+		server, requests := newWebhook(t, http.StatusInternalServerError)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{WebhookSinks: []WebhookSink{{Name: "collector", URL: server.URL}}})
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+		require.NoError(t, err)
+		now := time.Now()
+
+		// when
+		err = reconciler.notificationOutbox().retry(context.TODO(), now)
+
+		// then
+		require.NoError(t, err)
+		assert.Len(t, *requests, 1)
+		assertNotificationSent(t, fakeClients.DefaultClient, "alex-stage-idled", "alex@test.com")
+		entry := queuedNotifications(t, fakeClients.DefaultClient)["webhook.collector.alex-stage-idled"]
+		assert.False(t, entry.Delivered)
+		assert.Equal(t, 1, entry.Attempts)
+		assert.Equal(t, "the webhook responded with the status code 500", entry.LastError)
+		assert.True(t, now.Add(outboxInitialBackoff).Equal(entry.NextAttempt))
+	})
+
+	t.Run("event is not queued when the host notification fails", func(t *testing.T) {
+		// given
+		server, requests := newWebhook(t, http.StatusOK)
+		// no NSTemplateSet, so the users of the space are not known
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{WebhookSinks: []WebhookSink{{Name: "collector", URL: server.URL}}})
+
+		// when
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+
+		// then
+		require.Error(t, err)
+		assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+		assert.Empty(t, *requests)
+	})
+
+	t.Run("event for the sink which is not configured anymore is dropped", func(t *testing.T) {
+		// given
+		server, requests := newWebhook(t, http.StatusOK)
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{WebhookSinks: []WebhookSink{{Name: "collector", URL: server.URL}}})
+		_, err := reconciler.sendNotification(context.TODO(), idler, "alex-stage-idled", toolchainv1alpha1.NotificationTypeIdled, idlerTriggeredTemplate, keysAndVals)
+		require.NoError(t, err)
+		reconciler.GetConfig = configFor(ConfigSpec{})
+
+		// when
+		err = reconciler.notificationOutbox().retry(context.TODO(), time.Now())
+
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, queuedNotifications(t, fakeClients.DefaultClient))
+		assert.Empty(t, *requests)
+	})
+
+	t.Run("sink errors", func(t *testing.T) {
+		server, requests := newWebhook(t, http.StatusBadRequest)
+		reconciler, _, _ := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		event := notificationEvent{Type: toolchainv1alpha1.NotificationTypeIdled, Name: "alex-stage-idled", Namespace: idler.Name, Context: keysAndVals}
+
+		for name, tc := range map[string]struct {
+			sink          WebhookSink
+			expectedError string
+		}{
+			"unexpected status code": {
+				sink:          WebhookSink{Name: "collector", URL: server.URL},
+				expectedError: "the webhook responded with the status code 400",
+			},
+			"secret not found": {
+				sink:          WebhookSink{Name: "collector", URL: server.URL, SecretRef: secretRef},
+				expectedError: "unable to get the secret of the webhook: secrets \"idler-webhook\" not found",
+			},
+			"payload is not a valid JSON": {
+				sink:          WebhookSink{Name: "collector", URL: server.URL, PayloadTemplate: `{"text": {{ .Namespace }}}`},
+				expectedError: "the payload of the webhook is not a valid JSON: {\"text\": alex-stage}",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// given
+				sink := &webhookNotificationSink{client: reconciler.Client, namespace: reconciler.Namespace, spec: tc.sink}
+
+				// when
+				err := sink.send(context.TODO(), event)
+
+				// then
+				require.EqualError(t, err, tc.expectedError)
+			})
+		}
+		// only the request with the unexpected status code reached the webhook
+		assert.Len(t, *requests, 1)
+	})
+}
@@ -0,0 +1,534 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// IdlingStrategyType defines how the owners of a given kind are idled
+type IdlingStrategyType string
+
+const (
+	// ScaleSubresourceStrategy scales the owner to zero using its scale subresource
+	ScaleSubresourceStrategy IdlingStrategyType = "ScaleSubresource"
+	// PatchStrategy applies the configured JSON merge patch to the owner, eg. {"spec":{"replicas":0}}
+	PatchStrategy IdlingStrategyType = "Patch"
+	// StopSubresourceStrategy calls the "stop" subresource of the owner served by the "subresources.<group>" API group (as KubeVirt does)
+	StopSubresourceStrategy IdlingStrategyType = "StopSubresource"
+	// DeleteStrategy deletes the owner
+	DeleteStrategy IdlingStrategyType = "Delete"
+	// DeleteOldInferenceServicesStrategy deletes all InferenceServices in the namespace which are older than the idler timeout
+	DeleteOldInferenceServicesStrategy IdlingStrategyType = "DeleteOldInferenceServices"
+	// CancelStrategy applies the configured JSON merge patch cancelling the owner, eg. {"spec":{"status":"Cancelled"}}.
+	// Unlike the Patch strategy, the cancelled owners are not restored when un-idling the namespace.
+	CancelStrategy IdlingStrategyType = "Cancel"
+	// StopAnnotationStrategy sets the configured annotation to the time of idling, which makes the controller of the owner
+	// stop it (as the Kubeflow notebook controller does with the "kubeflow-resource-stopped" annotation)
+	StopAnnotationStrategy IdlingStrategyType = "StopAnnotation"
+
+	// kubeflowResourceStoppedAnnotation stops the Kubeflow Notebooks when set
+	kubeflowResourceStoppedAnnotation = "kubeflow-resource-stopped"
+)
+
+// IdlingStrategy defines how the owners of the given kind are idled
+type IdlingStrategy struct {
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+
+	Type IdlingStrategyType `json:"type"`
+
+	// Patch is the JSON merge patch applied to the owner when the type is Patch or Cancel
+	Patch string `json:"patch,omitempty"`
+
+	// Annotation is the annotation set on the owner when the type is StopAnnotation
+	Annotation string `json:"annotation,omitempty"`
+}
+
+func (s IdlingStrategy) groupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: s.Group, Version: s.Version, Kind: s.Kind}
+}
+
+func (s IdlingStrategy) validate() error {
+	if s.Version == "" || s.Kind == "" {
+		return fmt.Errorf("version and kind are required")
+	}
+	switch s.Type {
+	case ScaleSubresourceStrategy, StopSubresourceStrategy, DeleteStrategy, DeleteOldInferenceServicesStrategy:
+		return nil
+	case PatchStrategy, CancelStrategy:
+		if _, err := s.patchContent(); err != nil {
+			return fmt.Errorf("invalid patch: %w", err)
+		}
+		return nil
+	case StopAnnotationStrategy:
+		if s.Annotation == "" {
+			return fmt.Errorf("annotation is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown idling strategy type '%s'", s.Type)
+	}
+}
+
+func (s IdlingStrategy) patchContent() (map[string]any, error) {
+	content := map[string]any{}
+	if err := json.Unmarshal([]byte(s.Patch), &content); err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("the patch is empty")
+	}
+	return content, nil
+}
+
+// setsReplicas returns true if the owners are idled by changing the number of replicas
+func (s IdlingStrategy) setsReplicas() bool {
+	if s.Type == ScaleSubresourceStrategy {
+		return true
+	}
+	if s.Type != PatchStrategy {
+		return false
+	}
+	content, err := s.patchContent()
+	if err != nil {
+		return false
+	}
+	spec, ok := content["spec"].(map[string]any)
+	if !ok {
+		return false
+	}
+	_, ok = spec["replicas"]
+	return ok
+}
+
+// stopsRunningWorkloads returns false if idling the owner doesn't stop the workloads it already created
+// (eg. a suspended CronJob doesn't stop its running Jobs), so the next owner has to be idled as well
+func (s IdlingStrategy) stopsRunningWorkloads() bool {
+	return s.groupVersionKind().GroupKind() != cronJobGVK.GroupKind()
+}
+
+// restorable returns true if the owners idled by this strategy can be restored when un-idling the namespace
+func (s IdlingStrategy) restorable() bool {
+	return s.Type == ScaleSubresourceStrategy || s.Type == PatchStrategy || s.Type == StopSubresourceStrategy || s.Type == StopAnnotationStrategy
+}
+
+func scaledByPatch(gvk schema.GroupVersionKind) IdlingStrategy {
+	return IdlingStrategy{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Type: PatchStrategy, Patch: `{"spec":{"replicas":0}}`}
+}
+
+func withStrategy(gvk schema.GroupVersionKind, strategyType IdlingStrategyType) IdlingStrategy {
+	return IdlingStrategy{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Type: strategyType}
+}
+
+var cronJobGVK = schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}
+
+// builtInIdlingStrategies are the strategies for the kinds known by the idler out of the box
+var builtInIdlingStrategies = newIdlingStrategies(
+	scaledByPatch(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}),
+	scaledByPatch(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}),
+	scaledByPatch(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}),
+	scaledByPatch(schema.GroupVersionKind{Group: "", Version: "v1", Kind: "ReplicationController"}),
+	IdlingStrategy{Group: "apps.openshift.io", Version: "v1", Kind: "DeploymentConfig", Type: PatchStrategy, Patch: `{"spec":{"replicas":0,"paused":false}}`},
+	withStrategy(schema.GroupVersionKind{Group: "camel.apache.org", Version: "v1", Kind: "Integration"}, ScaleSubresourceStrategy),
+	withStrategy(schema.GroupVersionKind{Group: "camel.apache.org", Version: "v1alpha1", Kind: "KameletBinding"}, ScaleSubresourceStrategy),
+	// Nothing to scale down. Delete instead.
+	withStrategy(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, DeleteStrategy),
+	withStrategy(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, DeleteStrategy),
+	// Suspend the CronJob, so it doesn't create new Jobs. Its running Job is idled as well.
+	IdlingStrategy{Group: cronJobGVK.Group, Version: cronJobGVK.Version, Kind: cronJobGVK.Kind, Type: PatchStrategy, Patch: `{"spec":{"suspend":true}}`},
+	// Nothing to scale down. Stop instead.
+	withStrategy(schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachine"}, StopSubresourceStrategy),
+	IdlingStrategy{Group: "aap.ansible.com", Version: "v1alpha1", Kind: "AnsibleAutomationPlatform", Type: PatchStrategy, Patch: `{"spec":{"idle_aap":true}}`},
+	// Stop the workspace as the DevWorkspace operator expects, scaling its Deployment would conflict with the operator.
+	IdlingStrategy{Group: "workspace.devfile.io", Version: "v1alpha2", Kind: "DevWorkspace", Type: PatchStrategy, Patch: `{"spec":{"started":false}}`},
+	// Scaling the StatefulSet of the Notebook would be reverted by the notebook controller. Stop the Notebook instead.
+	IdlingStrategy{Group: "kubeflow.org", Version: "v1", Kind: "Notebook", Type: StopAnnotationStrategy, Annotation: kubeflowResourceStoppedAnnotation},
+	// Cancel the Tekton runs, deleting their pods would make them fail in confusing ways. Cancelling the PipelineRun cancels all its TaskRuns.
+	IdlingStrategy{Group: "tekton.dev", Version: "v1", Kind: "PipelineRun", Type: CancelStrategy, Patch: `{"spec":{"status":"Cancelled"}}`},
+	IdlingStrategy{Group: "tekton.dev", Version: "v1beta1", Kind: "PipelineRun", Type: CancelStrategy, Patch: `{"spec":{"status":"Cancelled"}}`},
+	IdlingStrategy{Group: "tekton.dev", Version: "v1", Kind: "TaskRun", Type: CancelStrategy, Patch: `{"spec":{"status":"TaskRunCancelled"}}`},
+	IdlingStrategy{Group: "tekton.dev", Version: "v1beta1", Kind: "TaskRun", Type: CancelStrategy, Patch: `{"spec":{"status":"TaskRunCancelled"}}`},
+	// Idle by deleting old InferenceService objects.
+	withStrategy(schema.GroupVersionKind{Group: "serving.kserve.io", Version: "v1alpha1", Kind: "ServingRuntime"}, DeleteOldInferenceServicesStrategy),
+)
+
+// idlingStrategies is a registry of the idling strategies keyed by the GVK of the owners
+type idlingStrategies map[schema.GroupVersionKind]IdlingStrategy
+
+func newIdlingStrategies(strategies ...IdlingStrategy) idlingStrategies {
+	registry := idlingStrategies{}
+	for _, strategy := range strategies {
+		registry[strategy.groupVersionKind()] = strategy
+	}
+	return registry
+}
+
+// get returns the strategy for the given GVK. The strategies in this registry take precedence over the built-in ones.
+// If there is no strategy for the exact GVK, then the strategy registered for the same kind in another version of the API
+// is used (eg. for the older versions of the VirtualMachines or the AnsibleAutomationPlatforms). The group has to match,
+// so the owners of the same kind from unrelated APIs (eg. a custom Job) aren't idled by the strategy of another API.
+func (s idlingStrategies) get(gvk schema.GroupVersionKind) (IdlingStrategy, bool) {
+	if strategy, found := s[gvk]; found {
+		return strategy, true
+	}
+	if strategy, found := builtInIdlingStrategies[gvk]; found {
+		return strategy, true
+	}
+	for _, registry := range []idlingStrategies{s, builtInIdlingStrategies} {
+		if strategy, found := registry.forGroupKind(gvk.GroupKind()); found {
+			strategy.Version = gvk.Version
+			return strategy, true
+		}
+	}
+	return IdlingStrategy{}, false
+}
+
+// forGroupKind returns the strategy registered for the given group and kind in any version. If there are more of them,
+// then the one with the lowest version (in the lexical order) is returned, so the result is stable.
+func (s idlingStrategies) forGroupKind(groupKind schema.GroupKind) (IdlingStrategy, bool) {
+	var strategy IdlingStrategy
+	found := false
+	for gvk, candidate := range s {
+		if gvk.GroupKind() == groupKind && (!found || gvk.Version < strategy.Version) {
+			strategy = candidate
+			found = true
+		}
+	}
+	return strategy, found
+}
+
+// all returns all strategies including the built-in ones which are not overridden
+func (s idlingStrategies) all() []IdlingStrategy {
+	var strategies []IdlingStrategy
+	for gvk, strategy := range builtInIdlingStrategies {
+		if _, overridden := s[gvk]; !overridden {
+			strategies = append(strategies, strategy)
+		}
+	}
+	for _, strategy := range s {
+		strategies = append(strategies, strategy)
+	}
+	return strategies
+}
+
+// cancels returns true if the owners of the given kind are cancelled rather than idled
+func (s idlingStrategies) cancels(kind string) bool {
+	for _, strategy := range s.all() {
+		if strategy.Kind == kind {
+			return strategy.Type == CancelStrategy
+		}
+	}
+	return false
+}
+
+// strategyFor returns the idling strategy for the given owner.
+// If there is no strategy for the kind of the owner, but its resource has the scale subresource, then the owner is scaled using the subresource.
+func (i *ownerIdler) strategyFor(owner *objectWithGVR) (IdlingStrategy, bool) {
+	gvk := owner.object.GroupVersionKind()
+	if strategy, found := i.strategies.get(gvk); found {
+		return strategy, true
+	}
+	if owner.gvr != nil && i.ownerFetcher.hasScaleSubresource(*owner.gvr) {
+		return withStrategy(gvk, ScaleSubresourceStrategy), true
+	}
+	return IdlingStrategy{}, false
+}
+
+type idleFunc func(ctx context.Context, objectWithGVR *objectWithGVR) error
+
+// idleFuncFor returns the function that idles the given owner, or nil if the owner can't be idled
+func (i *ownerIdler) idleFuncFor(owner *objectWithGVR) idleFunc {
+	strategy, found := i.strategyFor(owner)
+	if !found {
+		return nil
+	}
+	switch strategy.Type {
+	case ScaleSubresourceStrategy:
+		return i.scaleToZeroWithSubresource
+	case CancelStrategy:
+		if isCompleted(owner.object) {
+			return nil // nothing to cancel, the owner is handled as an unknown one
+		}
+		return func(ctx context.Context, objectWithGVR *objectWithGVR) error {
+			return i.patch(ctx, objectWithGVR, strategy)
+		}
+	case PatchStrategy:
+		return func(ctx context.Context, objectWithGVR *objectWithGVR) error {
+			return i.patch(ctx, objectWithGVR, strategy)
+		}
+	case StopSubresourceStrategy:
+		return i.stop
+	case StopAnnotationStrategy:
+		return func(ctx context.Context, objectWithGVR *objectWithGVR) error {
+			return i.annotateStopped(ctx, objectWithGVR, strategy.Annotation)
+		}
+	case DeleteStrategy:
+		return i.deleteResource
+	case DeleteOldInferenceServicesStrategy:
+		return i.idleServingRuntime
+	default:
+		return nil
+	}
+}
+
+// isCompleted returns true if the given run (eg. a Tekton PipelineRun) has already completed, ie. it has the completion time set,
+// or its Succeeded condition is either True or False (it's Unknown while the run is running)
+func isCompleted(object *unstructured.Unstructured) bool {
+	if completionTime, found, _ := unstructured.NestedString(object.Object, "status", "completionTime"); found && completionTime != "" {
+		return true
+	}
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]any)
+		if ok && condition["type"] == "Succeeded" && (condition["status"] == string(metav1.ConditionTrue) || condition["status"] == string(metav1.ConditionFalse)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *ownerIdler) scaleToZeroWithSubresource(ctx context.Context, objectWithGVR *objectWithGVR) error {
+	object := objectWithGVR.object
+	logger := log.FromContext(ctx).WithValues("kind", object.GetKind(), "name", object.GetName())
+	logger.Info("Scaling controller owner to zero using the scale subresource")
+	return i.scaleWithSubresource(ctx, objectWithGVR, 0)
+}
+
+func (i *ownerIdler) scaleWithSubresource(ctx context.Context, objectWithGVR *objectWithGVR, replicas int64) error {
+	object := objectWithGVR.object
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	_, err := i.scalesClient.Scales(object.GetNamespace()).Patch(ctx, *objectWithGVR.gvr, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Controller owner scaled using the scale subresource", "kind", object.GetKind(), "name", object.GetName(), "replicas", replicas)
+	return nil
+}
+
+// patch applies the patch of the strategy to the owner if not already applied
+func (i *ownerIdler) patch(ctx context.Context, objectWithGVR *objectWithGVR, strategy IdlingStrategy) error {
+	object := objectWithGVR.object
+	logger := log.FromContext(ctx).WithValues("kind", object.GetKind(), "name", object.GetName())
+	content, err := strategy.patchContent()
+	if err != nil {
+		return err
+	}
+	if isPatched(object.UnstructuredContent(), content) {
+		logger.Info("Controller owner is already idled")
+		return nil
+	}
+	logger.Info("Idling controller owner by patching it", "patch", strategy.Patch)
+	_, err = i.dynamicClient.
+		Resource(*objectWithGVR.gvr).
+		Namespace(object.GetNamespace()).
+		Patch(ctx, object.GetName(), types.MergePatchType, []byte(strategy.Patch), metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	logger.Info("Controller owner idled")
+	return nil
+}
+
+// isPatched returns true if the object already contains all values set by the given merge patch
+func isPatched(object, patch map[string]any) bool {
+	for key, patchValue := range patch {
+		objectValue, found := object[key]
+		if nestedPatch, ok := patchValue.(map[string]any); ok {
+			nestedObject, ok := objectValue.(map[string]any)
+			if !ok || !isPatched(nestedObject, nestedPatch) {
+				return false
+			}
+			continue
+		}
+		if patchValue == nil {
+			if found {
+				return false
+			}
+			continue
+		}
+		if !found || !equalValues(objectValue, patchValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalValues compares the values from the unstructured objects where the numbers can be either int64 or float64
+func equalValues(objectValue, patchValue any) bool {
+	if patchNumber, ok := patchValue.(float64); ok {
+		switch objectNumber := objectValue.(type) {
+		case int64:
+			return float64(objectNumber) == patchNumber
+		case float64:
+			return objectNumber == patchNumber
+		}
+	}
+	return reflect.DeepEqual(objectValue, patchValue)
+}
+
+// revertPatch returns a merge patch setting all fields changed by the given merge patch back to the values they have in the object.
+// The fields which are not present in the object are removed by the returned patch.
+func revertPatch(object, patch map[string]any) map[string]any {
+	revert := map[string]any{}
+	for key, value := range patch {
+		if nested, ok := value.(map[string]any); ok {
+			nestedObject, _ := object[key].(map[string]any)
+			revert[key] = revertPatch(nestedObject, nested)
+			continue
+		}
+		revert[key] = object[key] // nil if not present
+	}
+	return revert
+}
+
+// mergePatches merges the source merge patch into the destination one
+func mergePatches(destination, source map[string]any) {
+	for key, value := range source {
+		nestedSource, sourceIsMap := value.(map[string]any)
+		nestedDestination, destinationIsMap := destination[key].(map[string]any)
+		if sourceIsMap && destinationIsMap {
+			mergePatches(nestedDestination, nestedSource)
+			continue
+		}
+		destination[key] = value
+	}
+}
+
+// annotateStopped sets the given annotation to the current time if the owner doesn't have it yet
+func (i *ownerIdler) annotateStopped(ctx context.Context, objectWithGVR *objectWithGVR, annotation string) error {
+	object := objectWithGVR.object
+	logger := log.FromContext(ctx).WithValues("kind", object.GetKind(), "name", object.GetName())
+	if _, stopped := object.GetAnnotations()[annotation]; stopped {
+		logger.Info("Controller owner is already stopped")
+		return nil
+	}
+	logger.Info("Stopping controller owner by annotating it", "annotation", annotation)
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				annotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = i.dynamicClient.
+		Resource(*objectWithGVR.gvr).
+		Namespace(object.GetNamespace()).
+		Patch(ctx, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	logger.Info("Controller owner stopped")
+	return nil
+}
+
+func (i *ownerIdler) stop(ctx context.Context, objectWithGVR *objectWithGVR) error {
+	return i.callRunSubresource(ctx, objectWithGVR, "stop")
+}
+
+func (i *ownerIdler) start(ctx context.Context, objectWithGVR *objectWithGVR) error {
+	return i.callRunSubresource(ctx, objectWithGVR, "start")
+}
+
+func (i *ownerIdler) callRunSubresource(ctx context.Context, objectWithGVR *objectWithGVR, subresource string) error {
+	logger := log.FromContext(ctx)
+	object := objectWithGVR.object
+	logger.Info("Calling the subresource of the controller owner", "kind", object.GetKind(), "name", object.GetName(), "subresource", subresource)
+	err := i.restClient.Put().
+		AbsPath(fmt.Sprintf(subresourcesURLFmt, objectWithGVR.gvr.Group, objectWithGVR.gvr.Version)).
+		Namespace(object.GetNamespace()).
+		Resource(objectWithGVR.gvr.Resource).
+		Name(object.GetName()).
+		SubResource(subresource).
+		Do(ctx).
+		Error()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Subresource of the controller owner called", "kind", object.GetKind(), "name", object.GetName(), "subresource", subresource)
+	return nil
+}
+
+func (i *ownerIdler) deleteResource(ctx context.Context, objectWithGVR *objectWithGVR) error {
+	logger := log.FromContext(ctx)
+	object := objectWithGVR.object
+	logger.Info("Deleting controller owner",
+		"kind", object.GetObjectKind().GroupVersionKind().Kind, "name", object.GetName())
+	// see https://github.com/kubernetes/kubernetes/issues/20902#issuecomment-321484735
+	// also, this may be needed for the e2e tests if the call to `client.Delete` comes too quickly after creating the job,
+	// which may leave the job's pod running but orphan, hence causing a test failure (and making the test flaky)
+	propagationPolicy := metav1.DeletePropagationBackground
+
+	err := i.dynamicClient.
+		Resource(*objectWithGVR.gvr).
+		Namespace(object.GetNamespace()).
+		Delete(ctx, object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Controller owner deleted",
+		"kind", object.GetObjectKind().GroupVersionKind().Kind, "name", object.GetName())
+	return nil
+}
+
+// idleServingRuntime idles ServingRuntime by deleting InferenceService objects that exist for longer than the timeout
+func (i *ownerIdler) idleServingRuntime(ctx context.Context, objectWithGVR *objectWithGVR) error {
+	logger := log.FromContext(ctx)
+	namespace := objectWithGVR.object.GetNamespace()
+
+	logger.Info("Idling ServingRuntime by deleting old InferenceService objects", "name", objectWithGVR.object.GetName())
+
+	// Construct GVR for InferenceService objects
+	inferenceServiceGVR := schema.GroupVersionResource{
+		Group:    "serving.kserve.io",
+		Version:  "v1beta1",
+		Resource: "inferenceservices",
+	}
+
+	// List all InferenceService objects in the namespace
+	inferenceServiceList, err := i.dynamicClient.
+		Resource(inferenceServiceGVR).
+		Namespace(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list InferenceService objects: %w", err)
+	}
+
+	cutoffTime := time.Now().Add(-time.Duration(i.idler.Spec.TimeoutSeconds) * time.Second)
+	var deletionErrors []error
+
+	// Delete InferenceService objects that are older than the timeout
+	for _, inferenceService := range inferenceServiceList.Items {
+		creationTime := inferenceService.GetCreationTimestamp().Time
+		if creationTime.Before(cutoffTime) {
+			logger.Info("Deleting old InferenceService", "name", inferenceService.GetName(), "age", time.Since(creationTime))
+
+			err := i.dynamicClient.
+				Resource(inferenceServiceGVR).
+				Namespace(namespace).
+				Delete(ctx, inferenceService.GetName(), metav1.DeleteOptions{})
+			if err != nil {
+				deletionErrors = append(deletionErrors, err)
+			} else {
+				logger.Info("InferenceService deleted", "name", inferenceService.GetName())
+			}
+		}
+	}
+
+	return errors.Join(deletionErrors...)
+}
@@ -0,0 +1,790 @@
+package idler
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	fakescale "k8s.io/client-go/scale/fake"
+	clienttest "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var workspaceGVK = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Workspace"}
+
+func TestIdlingStrategies(t *testing.T) {
+	t.Run("built-in strategies are used by default", func(t *testing.T) {
+		// given
+		var strategies idlingStrategies
+
+		// when
+		strategy, found := strategies.get(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+
+		// then
+		require.True(t, found)
+		assert.Equal(t, PatchStrategy, strategy.Type)
+		assert.True(t, strategy.setsReplicas())
+		_, found = strategies.get(workspaceGVK)
+		assert.False(t, found)
+		assert.Len(t, strategies.all(), len(builtInIdlingStrategies))
+	})
+
+	t.Run("configured strategies extend and override the built-in ones", func(t *testing.T) {
+		// given
+		strategies := NewConfig(ConfigSpec{IdlingStrategies: []IdlingStrategy{
+			{Group: "example.com", Version: "v1", Kind: "Workspace", Type: PatchStrategy, Patch: `{"spec":{"started":false}}`},
+			{Group: "apps", Version: "v1", Kind: "Deployment", Type: ScaleSubresourceStrategy},
+		}}).IdlingStrategies()
+
+		// when
+		workspaceStrategy, workspaceFound := strategies.get(workspaceGVK)
+		deploymentStrategy, deploymentFound := strategies.get(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+
+		// then
+		require.True(t, workspaceFound)
+		assert.Equal(t, PatchStrategy, workspaceStrategy.Type)
+		assert.False(t, workspaceStrategy.setsReplicas())
+		require.True(t, deploymentFound)
+		assert.Equal(t, ScaleSubresourceStrategy, deploymentStrategy.Type)
+		assert.Len(t, strategies.all(), len(builtInIdlingStrategies)+1)
+	})
+
+	t.Run("strategy of another version of the same kind is used", func(t *testing.T) {
+		// given
+		strategies := NewConfig(ConfigSpec{IdlingStrategies: []IdlingStrategy{
+			{Group: "example.com", Version: "v1", Kind: "Workspace", Type: PatchStrategy, Patch: `{"spec":{"started":false}}`},
+		}}).IdlingStrategies()
+
+		for name, tc := range map[string]struct {
+			gvk          schema.GroupVersionKind
+			expectedType IdlingStrategyType
+		}{
+			"virtual machine":     {gvk: schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1alpha3", Kind: "VirtualMachine"}, expectedType: StopSubresourceStrategy},
+			"deployment config":   {gvk: schema.GroupVersionKind{Group: "apps.openshift.io", Version: "v1beta1", Kind: "DeploymentConfig"}, expectedType: PatchStrategy},
+			"AAP":                 {gvk: schema.GroupVersionKind{Group: "aap.ansible.com", Version: "v1beta1", Kind: "AnsibleAutomationPlatform"}, expectedType: PatchStrategy},
+			"configured strategy": {gvk: schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Workspace"}, expectedType: PatchStrategy},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// when
+				strategy, found := strategies.get(tc.gvk)
+
+				// then
+				require.True(t, found)
+				assert.Equal(t, tc.expectedType, strategy.Type)
+				assert.Equal(t, tc.gvk, strategy.groupVersionKind())
+			})
+		}
+
+		t.Run("kind of another group is not matched", func(t *testing.T) {
+			// when
+			_, found := strategies.get(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Job"})
+
+			// then
+			assert.False(t, found)
+		})
+	})
+
+	t.Run("invalid strategies are ignored", func(t *testing.T) {
+		// given
+		strategies := NewConfig(ConfigSpec{IdlingStrategies: []IdlingStrategy{
+			{Group: "example.com", Version: "v1", Kind: "Workspace", Type: PatchStrategy, Patch: `not a json`},
+			{Group: "example.com", Version: "v1", Kind: "Notebook", Type: PatchStrategy},
+			{Group: "example.com", Version: "v1", Kind: "Pipeline", Type: "Pause"},
+			{Group: "example.com", Kind: "Model", Type: DeleteStrategy},
+			{Group: "example.com", Version: "v1", Kind: "Runner", Type: StopAnnotationStrategy},
+		}}).IdlingStrategies()
+
+		// then
+		assert.Empty(t, strategies)
+	})
+}
+
+func TestIsPatched(t *testing.T) {
+	object := map[string]any{
+		"metadata": map[string]any{"name": "test"},
+		"spec":     map[string]any{"replicas": int64(0), "started": false},
+	}
+
+	for name, tc := range map[string]struct {
+		patch    map[string]any
+		expected bool
+	}{
+		"same number":          {patch: map[string]any{"spec": map[string]any{"replicas": float64(0)}}, expected: true},
+		"different number":     {patch: map[string]any{"spec": map[string]any{"replicas": float64(1)}}, expected: false},
+		"same bool":            {patch: map[string]any{"spec": map[string]any{"started": false}}, expected: true},
+		"missing field":        {patch: map[string]any{"spec": map[string]any{"paused": false}}, expected: false},
+		"missing object":       {patch: map[string]any{"status": map[string]any{"phase": "Stopped"}}, expected: false},
+		"removed field":        {patch: map[string]any{"spec": map[string]any{"paused": nil}}, expected: true},
+		"not removed field":    {patch: map[string]any{"spec": map[string]any{"started": nil}}, expected: false},
+		"multiple same fields": {patch: map[string]any{"spec": map[string]any{"replicas": float64(0), "started": false}}, expected: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isPatched(object, tc.patch))
+		})
+	}
+}
+
+func TestConfiguredIdlingStrategy(t *testing.T) {
+	// given
+	workspace := &unstructured.Unstructured{}
+	workspace.SetGroupVersionKind(workspaceGVK)
+	workspace.SetName("my-workspace")
+	workspace.SetNamespace("john-dev")
+	require.NoError(t, unstructured.SetNestedField(workspace.Object, true, "spec", "started"))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-workspace-pod",
+			Namespace: "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: workspaceGVK.GroupVersion().String(),
+				Kind:       workspaceGVK.Kind,
+				Name:       workspace.GetName(),
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}},
+	}
+	workspaceGVR := workspaceGVK.GroupVersion().WithResource("workspaces")
+	resources := append(allResourcesList(t), &metav1.APIResourceList{
+		GroupVersion: workspaceGVK.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: workspaceGVR.Resource, Namespaced: true, Kind: workspaceGVK.Kind}},
+	})
+	listKinds := maps.Clone(customListKinds)
+	listKinds[workspaceGVR] = "WorkspaceList"
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds, workspace)
+	ownerIdler := &ownerIdler{
+		idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+		ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+		dynamicClient: dynamicClient,
+		scalesClient:  &fakescale.FakeScaleClient{},
+		strategies: NewConfig(ConfigSpec{IdlingStrategies: []IdlingStrategy{
+			{Group: workspaceGVK.Group, Version: workspaceGVK.Version, Kind: workspaceGVK.Kind, Type: PatchStrategy, Patch: `{"spec":{"started":false}}`},
+		}}).IdlingStrategies(),
+	}
+
+	// when
+	kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "Workspace", kind)
+	assert.Equal(t, "my-workspace", name)
+	idledWorkspace, err := dynamicClient.Resource(workspaceGVR).Namespace("john-dev").Get(context.TODO(), "my-workspace", metav1.GetOptions{})
+	require.NoError(t, err)
+	started, _, err := unstructured.NestedBool(idledWorkspace.Object, "spec", "started")
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, `{"spec":{"started":true}}`, idledWorkspace.GetAnnotations()[IdledRevertPatchAnnotationKey])
+
+	t.Run("workspace is restored when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredWorkspace, err := dynamicClient.Resource(workspaceGVR).Namespace("john-dev").Get(context.TODO(), "my-workspace", metav1.GetOptions{})
+		require.NoError(t, err)
+		started, _, err := unstructured.NestedBool(restoredWorkspace.Object, "spec", "started")
+		require.NoError(t, err)
+		assert.True(t, started)
+		assertIdledAnnotationsRemoved(t, restoredWorkspace)
+	})
+}
+
+func TestIdlingOwnerOfAnotherVersion(t *testing.T) {
+	// given
+	aapGVK := schema.GroupVersionKind{Group: "aap.ansible.com", Version: "v1beta1", Kind: "AnsibleAutomationPlatform"}
+	aap := &unstructured.Unstructured{}
+	aap.SetGroupVersionKind(aapGVK)
+	aap.SetName("my-aap")
+	aap.SetNamespace("john-dev")
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-aap-pod",
+			Namespace: "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: aapGVK.GroupVersion().String(),
+				Kind:       aapGVK.Kind,
+				Name:       aap.GetName(),
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}},
+	}
+	aapGVR := aapGVK.GroupVersion().WithResource("ansibleautomationplatforms")
+	resources := append(noAAPResourceList(t), &metav1.APIResourceList{
+		GroupVersion: aapGVK.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: aapGVR.Resource, Namespaced: true, Kind: aapGVK.Kind}},
+	})
+	listKinds := maps.Clone(customListKinds)
+	listKinds[aapGVR] = "AnsibleAutomationPlatformList"
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds, aap)
+	ownerIdler := &ownerIdler{
+		idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+		ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+		dynamicClient: dynamicClient,
+		scalesClient:  &fakescale.FakeScaleClient{},
+	}
+
+	// when
+	kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "AnsibleAutomationPlatform", kind)
+	assert.Equal(t, "my-aap", name)
+	idledAAP, err := dynamicClient.Resource(aapGVR).Namespace("john-dev").Get(context.TODO(), "my-aap", metav1.GetOptions{})
+	require.NoError(t, err)
+	idle, _, err := unstructured.NestedBool(idledAAP.Object, "spec", "idle_aap")
+	require.NoError(t, err)
+	assert.True(t, idle)
+
+	t.Run("AAP is restored when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredAAP, err := dynamicClient.Resource(aapGVR).Namespace("john-dev").Get(context.TODO(), "my-aap", metav1.GetOptions{})
+		require.NoError(t, err)
+		_, found, err := unstructured.NestedBool(restoredAAP.Object, "spec", "idle_aap")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assertIdledAnnotationsRemoved(t, restoredAAP)
+	})
+}
+
+func TestDevWorkspaceIdling(t *testing.T) {
+	// given
+	devWorkspaceGVK := schema.GroupVersionKind{Group: "workspace.devfile.io", Version: "v1alpha2", Kind: "DevWorkspace"}
+	devWorkspaceGVR := devWorkspaceGVK.GroupVersion().WithResource("devworkspaces")
+	devWorkspace := &unstructured.Unstructured{}
+	devWorkspace.SetGroupVersionKind(devWorkspaceGVK)
+	devWorkspace.SetName("my-workspace")
+	devWorkspace.SetNamespace("john-dev")
+	require.NoError(t, unstructured.SetNestedField(devWorkspace.Object, true, "spec", "started"))
+	deployment := &unstructured.Unstructured{}
+	deployment.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	deployment.SetName("my-workspace-deployment")
+	deployment.SetNamespace("john-dev")
+	deployment.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: devWorkspaceGVK.GroupVersion().String(),
+		Kind:       devWorkspaceGVK.Kind,
+		Name:       devWorkspace.GetName(),
+		Controller: ptr.To(true),
+	}})
+	require.NoError(t, unstructured.SetNestedField(deployment.Object, int64(1), "spec", "replicas"))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-workspace-pod",
+			Namespace: "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       deployment.GetName(),
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-61 * time.Minute)}},
+	}
+	resources := append(allResourcesList(t), &metav1.APIResourceList{
+		GroupVersion: devWorkspaceGVK.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: devWorkspaceGVR.Resource, Namespaced: true, Kind: devWorkspaceGVK.Kind}},
+	})
+	listKinds := maps.Clone(customListKinds)
+	listKinds[devWorkspaceGVR] = "DevWorkspaceList"
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds, devWorkspace, deployment)
+	ownerIdler := &ownerIdler{
+		idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+		ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+		dynamicClient: dynamicClient,
+		scalesClient:  &fakescale.FakeScaleClient{},
+	}
+
+	// when
+	kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "DevWorkspace", kind)
+	assert.Equal(t, "my-workspace", name)
+	idledWorkspace, err := dynamicClient.Resource(devWorkspaceGVR).Namespace("john-dev").Get(context.TODO(), "my-workspace", metav1.GetOptions{})
+	require.NoError(t, err)
+	started, _, err := unstructured.NestedBool(idledWorkspace.Object, "spec", "started")
+	require.NoError(t, err)
+	assert.False(t, started)
+	// the Deployment is left to the DevWorkspace operator
+	notScaledDeployment, err := dynamicClient.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).
+		Namespace("john-dev").Get(context.TODO(), deployment.GetName(), metav1.GetOptions{})
+	require.NoError(t, err)
+	assertReplicas(t, notScaledDeployment, 1)
+
+	t.Run("workspace is started when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredWorkspace, err := dynamicClient.Resource(devWorkspaceGVR).Namespace("john-dev").Get(context.TODO(), "my-workspace", metav1.GetOptions{})
+		require.NoError(t, err)
+		started, _, err := unstructured.NestedBool(restoredWorkspace.Object, "spec", "started")
+		require.NoError(t, err)
+		assert.True(t, started)
+		assertIdledAnnotationsRemoved(t, restoredWorkspace)
+	})
+}
+
+func TestNotebookIdling(t *testing.T) {
+	// given
+	notebookGVK := schema.GroupVersionKind{Group: "kubeflow.org", Version: "v1", Kind: "Notebook"}
+	notebookGVR := notebookGVK.GroupVersion().WithResource("notebooks")
+	notebook := &unstructured.Unstructured{}
+	notebook.SetGroupVersionKind(notebookGVK)
+	notebook.SetName("my-notebook")
+	notebook.SetNamespace("john-dev")
+	notebook.SetAnnotations(map[string]string{"notebooks.opendatahub.io/inject-oauth": "true"})
+	statefulSet := &unstructured.Unstructured{}
+	statefulSet.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"})
+	statefulSet.SetName("my-notebook")
+	statefulSet.SetNamespace("john-dev")
+	statefulSet.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: notebookGVK.GroupVersion().String(),
+		Kind:       notebookGVK.Kind,
+		Name:       notebook.GetName(),
+		Controller: ptr.To(true),
+	}})
+	require.NoError(t, unstructured.SetNestedField(statefulSet.Object, int64(1), "spec", "replicas"))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-notebook-0",
+			Namespace: "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "StatefulSet",
+				Name:       statefulSet.GetName(),
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-61 * time.Minute)}},
+	}
+	resources := append(allResourcesList(t), &metav1.APIResourceList{
+		GroupVersion: notebookGVK.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: notebookGVR.Resource, Namespaced: true, Kind: notebookGVK.Kind}},
+	})
+	listKinds := maps.Clone(customListKinds)
+	listKinds[notebookGVR] = "NotebookList"
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds, notebook, statefulSet)
+	ownerIdler := &ownerIdler{
+		idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+		ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+		dynamicClient: dynamicClient,
+		scalesClient:  &fakescale.FakeScaleClient{},
+	}
+
+	// when
+	kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	// the Notebook is reported instead of the StatefulSet
+	assert.Equal(t, "Notebook", kind)
+	assert.Equal(t, "my-notebook", name)
+	stoppedNotebook, err := dynamicClient.Resource(notebookGVR).Namespace("john-dev").Get(context.TODO(), "my-notebook", metav1.GetOptions{})
+	require.NoError(t, err)
+	stoppedAt, err := time.Parse(time.RFC3339, stoppedNotebook.GetAnnotations()[kubeflowResourceStoppedAnnotation])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), stoppedAt, time.Minute)
+	// the StatefulSet is left to the notebook controller
+	notScaledStatefulSet, err := dynamicClient.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}).
+		Namespace("john-dev").Get(context.TODO(), statefulSet.GetName(), metav1.GetOptions{})
+	require.NoError(t, err)
+	assertReplicas(t, notScaledStatefulSet, 1)
+
+	t.Run("stopped notebook is not annotated again", func(t *testing.T) {
+		// given
+		dynamicClient.ClearActions()
+		ownerIdler.processed = nil
+		ownerIdler.ownerFetcher = newOwnerFetcher(ownerIdler.ownerFetcher.discoveryClient, dynamicClient)
+
+		// when
+		_, _, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.NoError(t, err)
+		for _, action := range dynamicClient.Actions() {
+			assert.NotEqual(t, "patch", action.GetVerb())
+		}
+	})
+
+	t.Run("notebook is started when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredNotebook, err := dynamicClient.Resource(notebookGVR).Namespace("john-dev").Get(context.TODO(), "my-notebook", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, restoredNotebook.GetAnnotations(), kubeflowResourceStoppedAnnotation)
+		assert.Equal(t, "true", restoredNotebook.GetAnnotations()["notebooks.opendatahub.io/inject-oauth"])
+		assertIdledAnnotationsRemoved(t, restoredNotebook)
+	})
+}
+
+func TestCronJobIdling(t *testing.T) {
+	// given
+	cronJobGVR := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+	jobGVR := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	cronJob := &unstructured.Unstructured{}
+	cronJob.SetGroupVersionKind(cronJobGVK)
+	cronJob.SetName("backup")
+	cronJob.SetNamespace("john-dev")
+	require.NoError(t, unstructured.SetNestedField(cronJob.Object, "*/5 * * * *", "spec", "schedule"))
+	job := &unstructured.Unstructured{}
+	job.SetGroupVersionKind(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"})
+	job.SetName("backup-28934120")
+	job.SetNamespace("john-dev")
+	job.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: cronJob.GetName(), Controller: ptr.To(true)}})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "backup-28934120-abcde",
+			Namespace:       "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: job.GetName(), Controller: ptr.To(true)}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-61 * time.Minute)}},
+	}
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), customListKinds, cronJob, job)
+	ownerIdler := &ownerIdler{
+		idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+		ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(allResourcesList(t)...), dynamicClient),
+		dynamicClient: dynamicClient,
+		scalesClient:  &fakescale.FakeScaleClient{},
+	}
+
+	// when
+	kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+	// then
+	require.NoError(t, err)
+	// the CronJob is reported instead of the Job
+	assert.Equal(t, "CronJob", kind)
+	assert.Equal(t, "backup", name)
+	suspendedCronJob, err := dynamicClient.Resource(cronJobGVR).Namespace("john-dev").Get(context.TODO(), "backup", metav1.GetOptions{})
+	require.NoError(t, err)
+	suspended, _, err := unstructured.NestedBool(suspendedCronJob.Object, "spec", "suspend")
+	require.NoError(t, err)
+	assert.True(t, suspended)
+	// the running Job is deleted even if the pod doesn't run for longer than the escalation percentage of the timeout
+	_, err = dynamicClient.Resource(jobGVR).Namespace("john-dev").Get(context.TODO(), job.GetName(), metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	t.Run("suspend flag is cleared when un-idled", func(t *testing.T) {
+		// when
+		err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+		// then
+		require.NoError(t, err)
+		restoredCronJob, err := dynamicClient.Resource(cronJobGVR).Namespace("john-dev").Get(context.TODO(), "backup", metav1.GetOptions{})
+		require.NoError(t, err)
+		_, found, err := unstructured.NestedFieldNoCopy(restoredCronJob.Object, "spec", "suspend")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assertIdledAnnotationsRemoved(t, restoredCronJob)
+	})
+}
+
+func TestTektonRunsAreCancelled(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "alex-stage",
+			Labels: map[string]string{toolchainv1alpha1.SpaceLabelKey: "alex"},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	pipelineRunGVR := schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "pipelineruns"}
+	taskRunGVR := schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "taskruns"}
+	newRun := func(kind, name string, owner *metav1.OwnerReference) *unstructured.Unstructured {
+		run := &unstructured.Unstructured{}
+		run.SetGroupVersionKind(schema.GroupVersionKind{Group: "tekton.dev", Version: "v1", Kind: kind})
+		run.SetName(name)
+		run.SetNamespace(idler.Name)
+		if owner != nil {
+			run.SetOwnerReferences([]metav1.OwnerReference{*owner})
+		}
+		return run
+	}
+	tektonResources := &metav1.APIResourceList{
+		GroupVersion: "tekton.dev/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pipelineruns", Namespaced: true, Kind: "PipelineRun"},
+			{Name: "taskruns", Namespaced: true, Kind: "TaskRun"},
+		},
+	}
+	prepare := func(t *testing.T) (*Reconciler, reconcile.Request, *memberoperatortest.FakeClientSet, *corev1.Pod) {
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.DiscoveryClient = newFakeDiscoveryClient(append(allResourcesList(t), tektonResources)...)
+		pipelineRun := newRun("PipelineRun", "build", nil)
+		taskRun := newRun("TaskRun", "build-compile", &metav1.OwnerReference{APIVersion: "tekton.dev/v1", Kind: "PipelineRun", Name: "build", Controller: ptr.To(true)})
+		for gvr, run := range map[schema.GroupVersionResource]*unstructured.Unstructured{pipelineRunGVR: pipelineRun, taskRunGVR: taskRun} {
+			_, err := fakeClients.DynamicClient.Resource(gvr).Namespace(idler.Name).Create(context.TODO(), run, metav1.CreateOptions{})
+			require.NoError(t, err)
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "build-compile-pod",
+				Namespace:       idler.Name,
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "tekton.dev/v1", Kind: "TaskRun", Name: "build-compile", Controller: ptr.To(true)}},
+			},
+			Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-61 * time.Minute)}},
+		}
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), pod))
+		return reconciler, req, fakeClients, pod
+	}
+	runStatus := func(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, gvr schema.GroupVersionResource, name string) string {
+		run, err := fakeClients.DynamicClient.Resource(gvr).Namespace(idler.Name).Get(context.TODO(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		status, _, err := unstructured.NestedString(run.Object, "spec", "status")
+		require.NoError(t, err)
+		return status
+	}
+
+	t.Run("pipeline run is cancelled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, pod := prepare(t)
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "Cancelled", runStatus(t, fakeClients, pipelineRunGVR, "build"))
+		// the TaskRun is cancelled by Tekton together with the PipelineRun
+		assert.Empty(t, runStatus(t, fakeClients, taskRunGVR, "build-compile"))
+		// the pod is left to Tekton
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			PodsExist([]*corev1.Pod{pod})
+		notification := &toolchainv1alpha1.Notification{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: "alex-stage-idled", Namespace: test.HostOperatorNs}, notification))
+		assert.Equal(t, pipelineRunCancelledTemplate, notification.Spec.Template)
+		assert.Equal(t, "PipelineRun", notification.Spec.Context["AppType"])
+		assert.Equal(t, "build", notification.Spec.Context["AppName"])
+
+		t.Run("cancelled runs are not touched when un-idled", func(t *testing.T) {
+			// given
+			requestUnidle(t, fakeClients.DefaultClient, types.NamespacedName{Name: idler.Name}, &toolchainv1alpha1.Idler{})
+			require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), pod))
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, "Cancelled", runStatus(t, fakeClients, pipelineRunGVR, "build"))
+		})
+	})
+
+	t.Run("completed pipeline run is not cancelled", func(t *testing.T) {
+		for name, status := range map[string]map[string]any{
+			"succeeded condition": {"conditions": []any{map[string]any{"type": "Succeeded", "status": "True"}}},
+			"failed condition":    {"conditions": []any{map[string]any{"type": "Succeeded", "status": "False"}}},
+			"completion time":     {"completionTime": "2026-10-17T10:00:00Z"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// given
+				reconciler, req, fakeClients, pod := prepare(t)
+				for gvr, name := range map[schema.GroupVersionResource]string{pipelineRunGVR: "build", taskRunGVR: "build-compile"} {
+					run, err := fakeClients.DynamicClient.Resource(gvr).Namespace(idler.Name).Get(context.TODO(), name, metav1.GetOptions{})
+					require.NoError(t, err)
+					run.Object["status"] = status
+					_, err = fakeClients.DynamicClient.Resource(gvr).Namespace(idler.Name).Update(context.TODO(), run, metav1.UpdateOptions{})
+					require.NoError(t, err)
+				}
+
+				// when
+				_, err := reconciler.Reconcile(context.TODO(), req)
+
+				// then
+				require.NoError(t, err)
+				assert.Empty(t, runStatus(t, fakeClients, pipelineRunGVR, "build"))
+				assert.Empty(t, runStatus(t, fakeClients, taskRunGVR, "build-compile"))
+				// the pod left running by the completed run is deleted
+				memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+					PodsDoNotExist([]*corev1.Pod{pod})
+				notification := &toolchainv1alpha1.Notification{}
+				require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: "alex-stage-idled", Namespace: test.HostOperatorNs}, notification))
+				assert.Equal(t, idlerTriggeredTemplate, notification.Spec.Template)
+				assert.Equal(t, "Pod", notification.Spec.Context["AppType"])
+			})
+		}
+	})
+
+	t.Run("running pipeline run is cancelled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, _ := prepare(t)
+		run, err := fakeClients.DynamicClient.Resource(pipelineRunGVR).Namespace(idler.Name).Get(context.TODO(), "build", metav1.GetOptions{})
+		require.NoError(t, err)
+		run.Object["status"] = map[string]any{"conditions": []any{map[string]any{"type": "Succeeded", "status": "Unknown"}}}
+		_, err = fakeClients.DynamicClient.Resource(pipelineRunGVR).Namespace(idler.Name).Update(context.TODO(), run, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "Cancelled", runStatus(t, fakeClients, pipelineRunGVR, "build"))
+	})
+
+	t.Run("task run without pipeline run is cancelled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients, _ := prepare(t)
+		require.NoError(t, fakeClients.DynamicClient.Resource(pipelineRunGVR).Namespace(idler.Name).Delete(context.TODO(), "build", metav1.DeleteOptions{}))
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "TaskRunCancelled", runStatus(t, fakeClients, taskRunGVR, "build-compile"))
+	})
+}
+
+func TestScaleSubresourceFallback(t *testing.T) {
+	// given
+	runnerGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Runner"}
+	runnerGVR := runnerGVK.GroupVersion().WithResource("runners")
+	runner := &unstructured.Unstructured{}
+	runner.SetGroupVersionKind(runnerGVK)
+	runner.SetName("my-runner")
+	runner.SetNamespace("john-dev")
+	require.NoError(t, unstructured.SetNestedField(runner.Object, int64(2), "spec", "replicas"))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-runner-pod",
+			Namespace: "john-dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: runnerGVK.GroupVersion().String(),
+				Kind:       runnerGVK.Kind,
+				Name:       runner.GetName(),
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: corev1.PodStatus{StartTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}},
+	}
+	listKinds := maps.Clone(customListKinds)
+	listKinds[runnerGVR] = "RunnerList"
+
+	prepareOwnerIdler := func(t *testing.T, runnerResources ...metav1.APIResource) (*ownerIdler, *fakedynamic.FakeDynamicClient, *fakescale.FakeScaleClient) {
+		resources := append(allResourcesList(t), &metav1.APIResourceList{
+			GroupVersion: runnerGVK.GroupVersion().String(),
+			APIResources: runnerResources,
+		})
+		dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(unstructuredScheme(scheme.Scheme), listKinds, runner.DeepCopy())
+		scalesClient := &fakescale.FakeScaleClient{}
+		return &ownerIdler{
+			idler:         &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}, Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: 3600}},
+			ownerFetcher:  newOwnerFetcher(newFakeDiscoveryClient(resources...), dynamicClient),
+			dynamicClient: dynamicClient,
+			scalesClient:  scalesClient,
+		}, dynamicClient, scalesClient
+	}
+
+	t.Run("unknown owner with the scale subresource is scaled to zero", func(t *testing.T) {
+		// given
+		ownerIdler, dynamicClient, scalesClient := prepareOwnerIdler(t,
+			metav1.APIResource{Name: "runners", Namespaced: true, Kind: runnerGVK.Kind},
+			metav1.APIResource{Name: "runners/scale", Namespaced: true, Kind: "Scale", Group: "autoscaling", Version: "v1"})
+
+		// when
+		kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "Runner", kind)
+		assert.Equal(t, "my-runner", name)
+		assertScalePatches(t, scalesClient, `{"spec":{"replicas":0}}`)
+		idledRunner, err := dynamicClient.Resource(runnerGVR).Namespace("john-dev").Get(context.TODO(), "my-runner", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "2", idledRunner.GetAnnotations()[IdledReplicasAnnotationKey])
+		assert.Equal(t, map[schema.GroupVersionKind]schema.GroupVersionResource{runnerGVK: runnerGVR}, ownerIdler.idledResources)
+
+		t.Run("runners are not listed when not recorded", func(t *testing.T) {
+			// given
+			idledResources := ownerIdler.idledResources
+			ownerIdler.idledResources = nil
+			defer func() {
+				ownerIdler.idledResources = idledResources
+			}()
+			scalesClient.ClearActions()
+
+			// when
+			err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+			// then
+			require.NoError(t, err)
+			assert.Empty(t, scalesClient.Actions())
+		})
+
+		t.Run("runner is scaled up when un-idled", func(t *testing.T) {
+			// given
+			// the idled resources are recorded on the Idler by the previous reconcile
+			ownerIdler.idledResources = nil
+			ownerIdler.idler.Annotations = map[string]string{
+				IdledResourcesAnnotationKey: `[{"group":"example.com","version":"v1","kind":"Runner","resource":"runners"}]`,
+			}
+			scalesClient.ClearActions()
+
+			// when
+			err := ownerIdler.unidle(context.TODO(), "john-dev")
+
+			// then
+			require.NoError(t, err)
+			assertScalePatches(t, scalesClient, `{"spec":{"replicas":2}}`)
+			restoredRunner, err := dynamicClient.Resource(runnerGVR).Namespace("john-dev").Get(context.TODO(), "my-runner", metav1.GetOptions{})
+			require.NoError(t, err)
+			assertIdledAnnotationsRemoved(t, restoredRunner)
+		})
+	})
+
+	t.Run("unknown owner without the scale subresource is skipped", func(t *testing.T) {
+		// given
+		ownerIdler, _, scalesClient := prepareOwnerIdler(t,
+			metav1.APIResource{Name: "runners", Namespaced: true, Kind: runnerGVK.Kind})
+
+		// when
+		kind, name, err := ownerIdler.scaleOwnerToZero(context.TODO(), pod, idleReasonTimeout)
+
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, kind)
+		assert.Empty(t, name)
+		assert.Empty(t, scalesClient.Actions())
+	})
+}
+
+func assertScalePatches(t *testing.T, scalesClient *fakescale.FakeScaleClient, expectedPatches ...string) {
+	var patches []string
+	for _, action := range scalesClient.Actions() {
+		if patchAction, ok := action.(clienttest.PatchActionImpl); ok {
+			patches = append(patches, string(patchAction.GetPatch()))
+		}
+	}
+	assert.Equal(t, expectedPatches, patches)
+}
@@ -0,0 +1,382 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// IdledAtAnnotationKey is set on the idled owners and contains the time when the owner was idled
+	IdledAtAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-at"
+	// IdledReasonAnnotationKey is set on the idled owners and contains the reason why the owner was idled
+	IdledReasonAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-reason"
+	// IdledReplicasAnnotationKey is set on the idled owners which were scaled down and contains the number of replicas before idling
+	IdledReplicasAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-replicas"
+	// IdledRunStateAnnotationKey is set on the idled owners which were stopped and contains the run state before idling
+	IdledRunStateAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-run-state"
+	// IdledRevertPatchAnnotationKey is set on the idled owners which were patched and contains the merge patch restoring the original values
+	IdledRevertPatchAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-revert-patch"
+
+	// UnidleAnnotationKey can be set on the Idler or on the namespace to restore all idled owners in the namespace
+	UnidleAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "unidle"
+	// IdledResourcesAnnotationKey is set on the Idler and contains the resources of the unknown kinds which were idled using
+	// the scale subresource, so only these resources are listed when the namespace is un-idled
+	IdledResourcesAnnotationKey = toolchainv1alpha1.LabelKeyPrefix + "idled-resources"
+
+	runStateRunning = "Running"
+)
+
+type idleReason string
+
+const (
+	idleReasonTimeout          idleReason = "timeout"
+	idleReasonRestartThreshold idleReason = "restart_threshold"
+	idleReasonRestartRate      idleReason = "restart_rate"
+	idleReasonEvicted          idleReason = "evicted"
+	idleReasonCompleted        idleReason = "completed"
+	idleReasonScheduled        idleReason = "scheduled"
+	idleReasonPending          idleReason = "pending"
+	idleReasonSpaceBudget      idleReason = "space_budget"
+	idleReasonKeptScaledDown   idleReason = "kept_scaled_down"
+)
+
+// stateBeforeIdling returns the annotation key and value describing the state of the owner before idling by the given strategy.
+// Returns empty strings if the owner can't be restored (eg. it's deleted when idled) or if it's already idled.
+func stateBeforeIdling(owner *unstructured.Unstructured, strategy IdlingStrategy) (string, string) {
+	if !strategy.restorable() {
+		return "", ""
+	}
+	if strategy.setsReplicas() {
+		replicas, found, err := unstructured.NestedInt64(owner.UnstructuredContent(), "spec", "replicas")
+		if err != nil || !found {
+			replicas = 1 // default number of replicas
+		}
+		if replicas == 0 {
+			return "", ""
+		}
+		return IdledReplicasAnnotationKey, strconv.FormatInt(replicas, 10)
+	}
+	if strategy.Type == PatchStrategy {
+		content, err := strategy.patchContent()
+		if err != nil || isPatched(owner.UnstructuredContent(), content) {
+			return "", ""
+		}
+		revert, err := json.Marshal(revertPatch(owner.UnstructuredContent(), content))
+		if err != nil {
+			return "", ""
+		}
+		return IdledRevertPatchAnnotationKey, string(revert)
+	}
+	if strategy.Type == StopAnnotationStrategy {
+		if _, stopped := owner.GetAnnotations()[strategy.Annotation]; stopped {
+			return "", ""
+		}
+		// removing the annotation starts the owner again
+		revert, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]any{strategy.Annotation: nil}}})
+		if err != nil {
+			return "", ""
+		}
+		return IdledRevertPatchAnnotationKey, string(revert)
+	}
+	// stopped via the subresource, the fields are the ones used by KubeVirt VirtualMachines
+	running, found, _ := unstructured.NestedBool(owner.UnstructuredContent(), "spec", "running")
+	runStrategy, _, _ := unstructured.NestedString(owner.UnstructuredContent(), "spec", "runStrategy")
+	if (found && !running) || runStrategy == "Halted" {
+		return "", ""
+	}
+	return IdledRunStateAnnotationKey, runStateRunning
+}
+
+// recordStateBeforeIdling annotates the owner with the state it had before idling, the reason, and the time of idling.
+// The owner object is expected to contain the state before idling.
+func (i *ownerIdler) recordStateBeforeIdling(ctx context.Context, objectWithGVR *objectWithGVR, reason idleReason) error {
+	strategy, found := i.strategyFor(objectWithGVR)
+	if !found {
+		return nil
+	}
+	return i.recordStateBeforeIdlingBy(ctx, objectWithGVR, strategy, reason)
+}
+
+// recordStateBeforeIdlingBy annotates the object with the state it had before it was idled by the given strategy, the reason, and the time of idling.
+// The owners idled because of crash-looping pods are annotated with the number of such idlings in a row as well.
+func (i *ownerIdler) recordStateBeforeIdlingBy(ctx context.Context, objectWithGVR *objectWithGVR, strategy IdlingStrategy, reason idleReason) error {
+	object := objectWithGVR.object
+	stateKey, stateValue := stateBeforeIdling(object, strategy)
+	if stateKey == "" {
+		return nil
+	}
+	log.FromContext(ctx).Info("Recording the state of the owner before idling", "kind", object.GetKind(), "name", object.GetName(), stateKey, stateValue)
+	annotations := i.crashLoopAnnotations(ctx, object, reason)
+	annotations[IdledAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+	annotations[IdledReasonAnnotationKey] = string(reason)
+	annotations[stateKey] = stateValue
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = i.dynamicClient.
+		Resource(*objectWithGVR.gvr).
+		Namespace(object.GetNamespace()).
+		Patch(ctx, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// unidle restores all owners in the namespace which were idled and which have the state before idling recorded.
+// The owners marked as crash-looping are kept scaled down.
+func (i *ownerIdler) unidle(ctx context.Context, namespace string) error {
+	if err := i.ownerFetcher.loadResourceLists(); err != nil {
+		return err
+	}
+	var unidleErrors []error
+	// the owners of the kinds with a restorable strategy, some of them might be served in another version of the API than the one of the strategy
+	restorable := map[schema.GroupVersionResource]IdlingStrategy{}
+	for _, strategy := range i.strategies.all() {
+		if !strategy.restorable() {
+			continue
+		}
+		gvk := strategy.groupVersionKind()
+		gvr, err := findGVRForKind(gvk.Kind, gvk.GroupVersion().String(), i.ownerFetcher.resourceLists)
+		if err != nil {
+			unidleErrors = append(unidleErrors, err)
+			continue
+		}
+		if gvr == nil {
+			gvr = findGVRForGroupKind(gvk.GroupKind(), i.ownerFetcher.resourceLists)
+		}
+		if gvr == nil {
+			continue // the API is not available in the cluster
+		}
+		// the same strategy as when the owners were idled
+		if strategy, found := i.strategies.get(gvr.GroupVersion().WithKind(gvk.Kind)); found && strategy.restorable() {
+			restorable[*gvr] = strategy
+		}
+	}
+	for gvr, strategy := range restorable {
+		if err := i.restoreIdledOwners(ctx, namespace, gvr, strategy); err != nil {
+			unidleErrors = append(unidleErrors, err)
+		}
+	}
+	if err := i.resumeScaledObjects(ctx, namespace); err != nil {
+		unidleErrors = append(unidleErrors, err)
+	}
+	// the owners of the unknown kinds were idled using the scale subresource, their resources are recorded on the Idler
+	idledResources, err := recordedIdledResources(i.idler.GetAnnotations()[IdledResourcesAnnotationKey])
+	if err != nil {
+		unidleErrors = append(unidleErrors, err)
+	}
+	maps.Copy(idledResources, i.idledResources)
+	for gvk, gvr := range idledResources {
+		if _, found := i.strategies.get(gvk); found {
+			continue
+		}
+		err := i.restoreIdledOwners(ctx, namespace, gvr, withStrategy(gvk, ScaleSubresourceStrategy))
+		// the operator doesn't have to be allowed to list all the scalable resources in the cluster
+		if err != nil && !apierrors.IsForbidden(err) {
+			unidleErrors = append(unidleErrors, err)
+		}
+	}
+	return errors.Join(unidleErrors...)
+}
+
+// idledResource is a resource of an unknown kind idled using the scale subresource, as it's recorded on the Idler
+type idledResource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+}
+
+// recordedIdledResources returns the resources recorded in the given value of the IdledResourcesAnnotationKey annotation
+func recordedIdledResources(value string) (map[schema.GroupVersionKind]schema.GroupVersionResource, error) {
+	resources := map[schema.GroupVersionKind]schema.GroupVersionResource{}
+	if value == "" {
+		return resources, nil
+	}
+	var recorded []idledResource
+	if err := json.Unmarshal([]byte(value), &recorded); err != nil {
+		return resources, fmt.Errorf("invalid idled resources '%s': %w", value, err)
+	}
+	for _, resource := range recorded {
+		gv := schema.GroupVersion{Group: resource.Group, Version: resource.Version}
+		resources[gv.WithKind(resource.Kind)] = gv.WithResource(resource.Resource)
+	}
+	return resources, nil
+}
+
+// recordIdledResources records the resources of the unknown kinds idled in this reconcile on the Idler, together with the ones recorded before
+func (r *Reconciler) recordIdledResources(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler) error {
+	resources, err := recordedIdledResources(idler.GetAnnotations()[IdledResourcesAnnotationKey])
+	if err != nil {
+		log.FromContext(ctx).Error(err, "dropping the invalid idled resources of the Idler")
+	}
+	recorded := len(resources)
+	maps.Copy(resources, ownerIdler.idledResources)
+	if len(resources) == recorded {
+		return nil
+	}
+	value := make([]idledResource, 0, len(resources))
+	for gvk, gvr := range resources {
+		value = append(value, idledResource{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Resource: gvr.Resource})
+	}
+	// sorted, so the value is stable between the reconciles
+	slices.SortFunc(value, func(a, b idledResource) int {
+		return strings.Compare(a.Group+"/"+a.Version+"/"+a.Kind, b.Group+"/"+b.Version+"/"+b.Kind)
+	})
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{IdledResourcesAnnotationKey: string(content)}}})
+	if err != nil {
+		return err
+	}
+	return r.Client.Patch(ctx, idler, client.RawPatch(types.MergePatchType, patch))
+}
+
+// restoreIdledOwners restores all owners of the given resource in the namespace which were idled using the given strategy
+func (i *ownerIdler) restoreIdledOwners(ctx context.Context, namespace string, gvr schema.GroupVersionResource, strategy IdlingStrategy) error {
+	owners, err := i.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var restoreErrors []error
+	for index := range owners.Items {
+		owner := &owners.Items[index]
+		if _, idled := owner.GetAnnotations()[IdledAtAnnotationKey]; !idled {
+			continue
+		}
+		if _, crashLooping := owner.GetAnnotations()[CrashLoopingAnnotationKey]; crashLooping {
+			log.FromContext(ctx).Info("Idled owner is crash-looping, keeping it scaled down", "kind", strategy.Kind, "name", owner.GetName())
+			continue
+		}
+		log.FromContext(ctx).Info("Restoring idled owner", "kind", strategy.Kind, "name", owner.GetName())
+		if err := i.restore(ctx, &objectWithGVR{object: owner, gvr: &gvr}, strategy); err != nil {
+			restoreErrors = append(restoreErrors, fmt.Errorf("failed to restore %s %s: %w", strategy.Kind, owner.GetName(), err))
+			continue
+		}
+		i.recordEvent(owner, corev1.EventTypeNormal, UnidledEventReason, "%s %s was restored to its state before idling", strategy.Kind, owner.GetName())
+	}
+	return errors.Join(restoreErrors...)
+}
+
+// restore restores the state of the owner recorded before idling by the given strategy and removes the idler annotations
+func (i *ownerIdler) restore(ctx context.Context, objectWithGVR *objectWithGVR, strategy IdlingStrategy) error {
+	object := objectWithGVR.object
+	annotations := object.GetAnnotations()
+	patch := map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				IdledAtAnnotationKey:          nil,
+				IdledReasonAnnotationKey:      nil,
+				IdledReplicasAnnotationKey:    nil,
+				IdledRunStateAnnotationKey:    nil,
+				IdledRevertPatchAnnotationKey: nil,
+				IdledHPAsAnnotationKey:        nil,
+			},
+		},
+	}
+	if value, found := annotations[IdledReplicasAnnotationKey]; found {
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid number of replicas '%s': %w", value, err)
+		}
+		if strategy.Type == ScaleSubresourceStrategy {
+			if err := i.scaleWithSubresource(ctx, objectWithGVR, replicas); err != nil {
+				return err
+			}
+		} else {
+			patch["spec"] = map[string]any{"replicas": replicas}
+		}
+	}
+	if _, found := annotations[IdledRunStateAnnotationKey]; found && strategy.Type == StopSubresourceStrategy {
+		if err := i.start(ctx, objectWithGVR); err != nil {
+			return err
+		}
+	}
+	if value, found := annotations[IdledRevertPatchAnnotationKey]; found {
+		revert := map[string]any{}
+		if err := json.Unmarshal([]byte(value), &revert); err != nil {
+			return fmt.Errorf("invalid revert patch '%s': %w", value, err)
+		}
+		mergePatches(patch, revert)
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = i.dynamicClient.
+		Resource(*objectWithGVR.gvr).
+		Namespace(object.GetNamespace()).
+		Patch(ctx, object.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	// create the autoscalers again only when the owner is scaled up, so they don't start with zero replicas
+	if value, found := annotations[IdledHPAsAnnotationKey]; found {
+		return i.recreateHPAs(ctx, object.GetNamespace(), value)
+	}
+	return nil
+}
+
+// unidleIfRequested restores all idled owners in the namespace if the un-idling was requested via the annotation on the Idler or on the namespace.
+// The annotation is removed when all owners are restored.
+func (r *Reconciler) unidleIfRequested(ctx context.Context, idler *toolchainv1alpha1.Idler) error {
+	namespace := &corev1.Namespace{}
+	if err := r.AllNamespacesClient.Get(ctx, types.NamespacedName{Name: idler.Name}, namespace); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		namespace = nil
+	}
+	_, requestedOnIdler := idler.GetAnnotations()[UnidleAnnotationKey]
+	requestedOnNamespace := false
+	if namespace != nil {
+		_, requestedOnNamespace = namespace.GetAnnotations()[UnidleAnnotationKey]
+	}
+	if !requestedOnIdler && !requestedOnNamespace {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Un-idling the namespace", "requested_on_idler", requestedOnIdler, "requested_on_namespace", requestedOnNamespace)
+	if err := newOwnerIdler(idler, r).unidle(ctx, idler.Name); err != nil {
+		return err
+	}
+	// the owners are scaled up by the idler itself, so it doesn't count as fighting the idler
+	r.idleActions.prune(idler.Name, time.Now())
+	removeAnnotation := client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":null}}}`, UnidleAnnotationKey)))
+	// the resources idled using the scale subresource are restored, so they don't have to be listed anymore
+	_, idledResources := idler.GetAnnotations()[IdledResourcesAnnotationKey]
+	if requestedOnIdler || idledResources {
+		removeAnnotations := client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":null,"%s":null}}}`, UnidleAnnotationKey, IdledResourcesAnnotationKey)))
+		if err := r.Client.Patch(ctx, idler, removeAnnotations); err != nil {
+			return err
+		}
+	}
+	if requestedOnNamespace {
+		if err := r.AllNamespacesClient.Patch(ctx, namespace, removeAnnotation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
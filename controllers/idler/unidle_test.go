@@ -0,0 +1,305 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestStateBeforeIdling(t *testing.T) {
+	newOwner := func(apiVersion, kind string, spec map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{"apiVersion": apiVersion, "kind": kind, "spec": spec}}
+	}
+
+	for name, tc := range map[string]struct {
+		owner         *unstructured.Unstructured
+		expectedKey   string
+		expectedValue string
+	}{
+		"scaled deployment": {
+			owner:         newOwner("apps/v1", "Deployment", map[string]any{"replicas": int64(3)}),
+			expectedKey:   IdledReplicasAnnotationKey,
+			expectedValue: "3",
+		},
+		"deployment without replicas": {
+			owner:         newOwner("apps/v1", "Deployment", map[string]any{}),
+			expectedKey:   IdledReplicasAnnotationKey,
+			expectedValue: "1",
+		},
+		"deployment scaled to zero": {
+			owner: newOwner("apps/v1", "Deployment", map[string]any{"replicas": int64(0)}),
+		},
+		"running VM": {
+			owner:         newOwner("kubevirt.io/v1", "VirtualMachine", map[string]any{"running": true}),
+			expectedKey:   IdledRunStateAnnotationKey,
+			expectedValue: runStateRunning,
+		},
+		"halted VM": {
+			owner: newOwner("kubevirt.io/v1", "VirtualMachine", map[string]any{"runStrategy": "Halted"}),
+		},
+		"running AAP": {
+			owner:         newOwner("aap.ansible.com/v1alpha1", "AnsibleAutomationPlatform", map[string]any{}),
+			expectedKey:   IdledRevertPatchAnnotationKey,
+			expectedValue: `{"spec":{"idle_aap":null}}`,
+		},
+		"running AAP with the field set": {
+			owner:         newOwner("aap.ansible.com/v1alpha1", "AnsibleAutomationPlatform", map[string]any{"idle_aap": false}),
+			expectedKey:   IdledRevertPatchAnnotationKey,
+			expectedValue: `{"spec":{"idle_aap":false}}`,
+		},
+		"idled AAP": {
+			owner: newOwner("aap.ansible.com/v1alpha1", "AnsibleAutomationPlatform", map[string]any{"idle_aap": true}),
+		},
+		"running CronJob": {
+			owner:         newOwner("batch/v1", "CronJob", map[string]any{"suspend": false}),
+			expectedKey:   IdledRevertPatchAnnotationKey,
+			expectedValue: `{"spec":{"suspend":false}}`,
+		},
+		"suspended CronJob": {
+			owner: newOwner("batch/v1", "CronJob", map[string]any{"suspend": true}),
+		},
+		"running notebook": {
+			owner:         newOwner("kubeflow.org/v1", "Notebook", map[string]any{}),
+			expectedKey:   IdledRevertPatchAnnotationKey,
+			expectedValue: `{"metadata":{"annotations":{"kubeflow-resource-stopped":null}}}`,
+		},
+		"stopped notebook": {
+			owner: func() *unstructured.Unstructured {
+				notebook := newOwner("kubeflow.org/v1", "Notebook", map[string]any{})
+				notebook.SetAnnotations(map[string]string{kubeflowResourceStoppedAnnotation: "2025-01-15T10:00:00Z"})
+				return notebook
+			}(),
+		},
+		"deleted owner": {
+			owner: newOwner("batch/v1", "Job", map[string]any{}),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// given
+			strategy, found := builtInIdlingStrategies.get(tc.owner.GroupVersionKind())
+			require.True(t, found)
+
+			// when
+			key, value := stateBeforeIdling(tc.owner, strategy)
+
+			// then
+			assert.Equal(t, tc.expectedKey, key)
+			assert.Equal(t, tc.expectedValue, value)
+		})
+	}
+}
+
+func TestUnidle(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "john-dev",
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: TestIdlerTimeOutSeconds},
+	}
+	expiredStartTime := &metav1.Time{Time: time.Now().Add(-time.Duration(TestIdlerTimeOutSeconds+1) * time.Second)}
+
+	t.Run("state before idling is recorded", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		annotations := getDeployment(t, fakeClients, deployment).GetAnnotations()
+		assert.Equal(t, "3", annotations[IdledReplicasAnnotationKey])
+		assert.Equal(t, string(idleReasonTimeout), annotations[IdledReasonAnnotationKey])
+		idledAt, err := time.Parse(time.RFC3339, annotations[IdledAtAnnotationKey])
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), idledAt, time.Minute)
+	})
+
+	t.Run("un-idle requested on the Idler", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		deletePods(t, fakeClients, pods) // the pods are deleted by the controllers when scaled down
+		requestUnidle(t, fakeClients.DefaultClient, types.NamespacedName{Name: idler.Name}, &toolchainv1alpha1.Idler{},
+			IdledResourcesAnnotationKey, `[{"group":"apps","version":"v1","kind":"Deployment","resource":"deployments"}]`)
+		recordedEvents(recorder) // drop the event of the idling
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		assertIdledAnnotationsRemoved(t, getDeployment(t, fakeClients, deployment))
+		updatedIdler := &toolchainv1alpha1.Idler{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: idler.Name}, updatedIdler))
+		assert.NotContains(t, updatedIdler.GetAnnotations(), UnidleAnnotationKey)
+		assert.NotContains(t, updatedIdler.GetAnnotations(), IdledResourcesAnnotationKey)
+		assert.Equal(t, []string{fmt.Sprintf("Normal Unidled Deployment %s was restored to its state before idling", deployment.Name)}, recordedEvents(recorder))
+	})
+
+	t.Run("un-idle requested on the namespace", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, expiredStartTime, nil, noRestart())
+		require.NoError(t, fakeClients.AllNamespacesClient.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: idler.Name}}))
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledDown(deployment)
+		deletePods(t, fakeClients, pods)
+		requestUnidle(t, fakeClients.AllNamespacesClient, types.NamespacedName{Name: idler.Name}, &corev1.Namespace{})
+
+		// when
+		_, err = reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		assertIdledAnnotationsRemoved(t, getDeployment(t, fakeClients, deployment))
+		namespace := &corev1.Namespace{}
+		require.NoError(t, fakeClients.AllNamespacesClient.Get(context.TODO(), types.NamespacedName{Name: idler.Name}, namespace))
+		assert.NotContains(t, namespace.GetAnnotations(), UnidleAnnotationKey)
+	})
+
+	t.Run("owners which weren't idled are not touched", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		deployment, _ := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		requestUnidle(t, fakeClients.DefaultClient, types.NamespacedName{Name: idler.Name}, &toolchainv1alpha1.Idler{})
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+	})
+}
+
+func TestIdledResources(t *testing.T) {
+	runnerGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Runner"}
+	runnerGVR := runnerGVK.GroupVersion().WithResource("runners")
+	builderGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Builder"}
+	builderGVR := builderGVK.GroupVersion().WithResource("builders")
+
+	t.Run("recorded on the Idler", func(t *testing.T) {
+		// given
+		idler := &toolchainv1alpha1.Idler{ObjectMeta: metav1.ObjectMeta{Name: "john-dev"}}
+		reconciler, _, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		ownerIdler := &ownerIdler{idledResources: map[schema.GroupVersionKind]schema.GroupVersionResource{runnerGVK: runnerGVR}}
+
+		// when
+		err := reconciler.recordIdledResources(context.TODO(), idler, ownerIdler)
+
+		// then
+		require.NoError(t, err)
+		updatedIdler := &toolchainv1alpha1.Idler{}
+		require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: idler.Name}, updatedIdler))
+		assert.JSONEq(t, `[{"group":"example.com","version":"v1","kind":"Runner","resource":"runners"}]`, updatedIdler.GetAnnotations()[IdledResourcesAnnotationKey])
+
+		t.Run("merged with the resources recorded before", func(t *testing.T) {
+			// given
+			ownerIdler.idledResources = map[schema.GroupVersionKind]schema.GroupVersionResource{builderGVK: builderGVR}
+
+			// when
+			err := reconciler.recordIdledResources(context.TODO(), updatedIdler, ownerIdler)
+
+			// then
+			require.NoError(t, err)
+			require.NoError(t, fakeClients.DefaultClient.Get(context.TODO(), types.NamespacedName{Name: idler.Name}, updatedIdler))
+			resources, err := recordedIdledResources(updatedIdler.GetAnnotations()[IdledResourcesAnnotationKey])
+			require.NoError(t, err)
+			assert.Equal(t, map[schema.GroupVersionKind]schema.GroupVersionResource{runnerGVK: runnerGVR, builderGVK: builderGVR}, resources)
+		})
+
+		t.Run("not patched when nothing new was idled", func(t *testing.T) {
+			// given
+			fakeClients.DefaultClient.MockPatch = func(_ context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+				return fmt.Errorf("unexpected patch")
+			}
+			defer func() {
+				fakeClients.DefaultClient.MockPatch = nil
+			}()
+
+			// when
+			err := reconciler.recordIdledResources(context.TODO(), updatedIdler, ownerIdler)
+
+			// then
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		// when
+		resources, err := recordedIdledResources("not-a-json")
+
+		// then
+		require.ErrorContains(t, err, "invalid idled resources 'not-a-json'")
+		assert.Empty(t, resources)
+	})
+}
+
+func requestUnidle(t *testing.T, cl client.Client, name types.NamespacedName, obj client.Object, otherAnnotations ...string) {
+	require.NoError(t, cl.Get(context.TODO(), name, obj))
+	annotations := map[string]string{UnidleAnnotationKey: "true"}
+	for i := 0; i+1 < len(otherAnnotations); i += 2 {
+		annotations[otherAnnotations[i]] = otherAnnotations[i+1]
+	}
+	obj.SetAnnotations(annotations)
+	require.NoError(t, cl.Update(context.TODO(), obj))
+}
+
+func deletePods(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, pods []*corev1.Pod) {
+	for _, pod := range pods {
+		require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), pod))
+	}
+}
+
+func getDeployment(t *testing.T, fakeClients *memberoperatortest.FakeClientSet, deployment *appsv1.Deployment) *unstructured.Unstructured {
+	d, err := fakeClients.DynamicClient.
+		Resource(appsv1.SchemeGroupVersion.WithResource("deployments")).
+		Namespace(deployment.Namespace).
+		Get(context.TODO(), deployment.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return d
+}
+
+func assertIdledAnnotationsRemoved(t *testing.T, owner *unstructured.Unstructured) {
+	annotations := owner.GetAnnotations()
+	assert.NotContains(t, annotations, IdledAtAnnotationKey)
+	assert.NotContains(t, annotations, IdledReasonAnnotationKey)
+	assert.NotContains(t, annotations, IdledReplicasAnnotationKey)
+	assert.NotContains(t, annotations, IdledRunStateAnnotationKey)
+	assert.NotContains(t, annotations, IdledRevertPatchAnnotationKey)
+}
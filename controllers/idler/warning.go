@@ -0,0 +1,81 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	"github.com/codeready-toolchain/member-operator/pkg/metrics"
+	"github.com/codeready-toolchain/toolchain-common/pkg/condition"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// IdlerWarningNotificationCreated is used to track the status of the notification warning the users that their workloads are about to be idled
+	IdlerWarningNotificationCreated toolchainv1alpha1.ConditionType = "IdlerWarningNotificationCreated"
+
+	IdlerWorkloadsAboutToBeIdledReason           = "WorkloadsAboutToBeIdled"
+	IdlerNoWorkloadsAboutToBeIdledReason         = "NoWorkloadsAboutToBeIdled"
+	IdlerWarningNotificationCreationFailedReason = "UnableToCreateIdlerWarningNotification"
+
+	notificationTypeIdlerWarning = "idlerwarning"
+	// warningTemplate is the template of the notification warning the users that their workloads are about to be idled
+	warningTemplate = "idlerwarning"
+)
+
+// warnAboutIdling sends a notification listing the workloads of the given pods which are about to be idled at the given time
+// (the earliest projected idle time of the pods). Only one warning is sent per idling cycle - the cycle ends when there is no workload about to be idled anymore.
+// Errors are only logged and stored in the Idler status so the processing of the pods is not affected.
+func (r *Reconciler) warnAboutIdling(ctx context.Context, idler *toolchainv1alpha1.Idler, ownerIdler *ownerIdler, pods []corev1.Pod, idleAt time.Time) {
+	logger := log.FromContext(ctx)
+	if len(pods) == 0 {
+		// reset the condition, so the users are warned again in the next idling cycle
+		if cond, found := condition.FindConditionByType(idler.Status.Conditions, IdlerWarningNotificationCreated); found && cond.Reason != IdlerNoWorkloadsAboutToBeIdledReason {
+			if err := r.setStatusNoWorkloadsAboutToBeIdled(ctx, idler); err != nil {
+				logger.Error(err, "failed to reset status IdlerWarningNotificationCreated")
+			}
+		}
+		return
+	}
+	if condition.IsTrue(idler.Status.Conditions, IdlerWarningNotificationCreated) {
+		// the users were already warned in this cycle
+		return
+	}
+
+	var workloads []string
+	for i := range pods {
+		kind, name := ownerIdler.topOwner(ctx, &pods[i])
+		if workload := fmt.Sprintf("%s/%s", kind, name); !slices.Contains(workloads, workload) {
+			workloads = append(workloads, workload)
+		}
+	}
+	logger.Info("Creating idler warning Notification", "workloads", workloads)
+	if err := r.createWarningNotification(ctx, idler, workloads, idleAt); err != nil {
+		logger.Error(err, "failed to create idler warning Notification")
+		metrics.IdlerNotificationFailuresCounterVec.WithLabelValues(notificationTypeIdlerWarning).Inc()
+		if err := r.setStatusIdlerWarningNotificationCreationFailed(ctx, idler, err.Error()); err != nil {
+			logger.Error(err, "failed to set status IdlerWarningNotificationCreationFailed")
+		}
+		return
+	}
+	if err := r.setStatusIdlerWarningNotificationCreated(ctx, idler); err != nil {
+		logger.Error(err, "failed to set status IdlerWarningNotificationCreated")
+	}
+}
+
+func (r *Reconciler) createWarningNotification(ctx context.Context, idler *toolchainv1alpha1.Idler, workloads []string, idleAt time.Time) error {
+	// the name contains the timestamp, so a new notification is created in every idling cycle
+	notificationName := fmt.Sprintf("%s-%s-%d", idler.Name, notificationTypeIdlerWarning, time.Now().Unix())
+	keysAndVals := map[string]string{
+		"Namespace": idler.Name,
+		"Workloads": strings.Join(workloads, ", "),
+		"IdleAt":    idleAt.UTC().Truncate(time.Second).Format(time.RFC3339),
+	}
+	// the warning is sent once per idling cycle, so the queued one is not sent again while the outbox delivers it
+	_, err := r.sendNotification(ctx, idler, notificationName, notificationTypeIdlerWarning, warningTemplate, keysAndVals)
+	return err
+}
@@ -0,0 +1,165 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	toolchainv1alpha1 "github.com/codeready-toolchain/api/api/v1alpha1"
+	memberoperatortest "github.com/codeready-toolchain/member-operator/test"
+	"github.com/codeready-toolchain/toolchain-common/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPreIdleWarning(t *testing.T) {
+	// given
+	idler := &toolchainv1alpha1.Idler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "alex-stage",
+			Labels: map[string]string{
+				toolchainv1alpha1.SpaceLabelKey: "alex",
+			},
+		},
+		Spec: toolchainv1alpha1.IdlerSpec{TimeoutSeconds: TestIdlerTimeOutSeconds},
+	}
+	nsTmplSet := newNSTmplSet(test.MemberOperatorNs, "alex", "advanced", "abcde11", []string{"dev", "stage"}, []string{"alex"})
+	mur := newMUR("alex")
+	// started 95% of the timeout ago
+	almostExpired := &metav1.Time{Time: time.Now().Add(-time.Duration(TestIdlerTimeOutSeconds*95/100) * time.Second)}
+	// started 50% of the timeout ago
+	fresh := &metav1.Time{Time: time.Now().Add(-time.Duration(TestIdlerTimeOutSeconds/2) * time.Second)}
+
+	t.Run("warning is sent once per idling cycle", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{PreIdleWarningPercentage: ptr.To(90)})
+		deployment, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		pods := createPods(t, fakeClients.AllNamespacesClient, replicaSet, almostExpired, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatInIdleableCluster(t, fakeClients).
+			DeploymentScaledUp(deployment)
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			HasConditions(memberoperatortest.Running(), warningNotificationCreated())
+		notifications := warningNotifications(t, fakeClients)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "alex@test.com", notifications[0].Spec.Recipient)
+		assert.Equal(t, warningTemplate, notifications[0].Spec.Template)
+		assert.Equal(t, "Deployment/"+deployment.Name, notifications[0].Spec.Context["Workloads"])
+		idleAt, err := time.Parse(time.RFC3339, notifications[0].Spec.Context["IdleAt"])
+		require.NoError(t, err)
+		assert.WithinDuration(t, almostExpired.Add(TestIdlerTimeOutSeconds*time.Second), idleAt, time.Second)
+		assert.Equal(t, idler.Name, notifications[0].Spec.Context["Namespace"])
+
+		t.Run("no other warning is sent in the same cycle", func(t *testing.T) {
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				HasConditions(memberoperatortest.Running(), warningNotificationCreated())
+			require.Len(t, warningNotifications(t, fakeClients), 1)
+		})
+
+		t.Run("cycle is reset when there is no workload about to be idled", func(t *testing.T) {
+			// given
+			for _, pod := range pods {
+				require.NoError(t, fakeClients.AllNamespacesClient.Delete(context.TODO(), pod))
+			}
+
+			// when
+			_, err := reconciler.Reconcile(context.TODO(), req)
+
+			// then
+			require.NoError(t, err)
+			memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+				HasConditions(memberoperatortest.Running(), noWorkloadsAboutToBeIdled())
+		})
+	})
+
+	t.Run("requeue at the warning time", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		reconciler.GetConfig = configFor(ConfigSpec{PreIdleWarningPercentage: ptr.To(90)})
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, fresh, nil, noRestart())
+
+		// when
+		res, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		assertRequeueTimeInDelta(t, res.RequeueAfter, TestIdlerTimeOutSeconds*40/100)
+		require.Empty(t, warningNotifications(t, fakeClients))
+	})
+
+	t.Run("no warning when disabled", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler, nsTmplSet, mur)
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, almostExpired, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			HasConditions(memberoperatortest.Running())
+		require.Empty(t, warningNotifications(t, fakeClients))
+	})
+
+	t.Run("failure to send the warning doesn't fail the reconcile", func(t *testing.T) {
+		// given
+		reconciler, req, fakeClients := prepareReconcile(t, idler.Name, getHostCluster, idler)
+		reconciler.GetConfig = configFor(ConfigSpec{PreIdleWarningPercentage: ptr.To(90)})
+		_, replicaSet := createDeployment(t, fakeClients, idler.Name, "", "-deployment", nil)
+		createPods(t, fakeClients.AllNamespacesClient, replicaSet, almostExpired, nil, noRestart())
+
+		// when
+		_, err := reconciler.Reconcile(context.TODO(), req)
+
+		// then
+		require.NoError(t, err)
+		memberoperatortest.AssertThatIdler(t, idler.Name, fakeClients).
+			HasConditions(memberoperatortest.Running(), toolchainv1alpha1.Condition{
+				Type:    IdlerWarningNotificationCreated,
+				Status:  corev1.ConditionFalse,
+				Reason:  IdlerWarningNotificationCreationFailedReason,
+				Message: `nstemplatesets.toolchain.dev.openshift.com "alex" not found`,
+			})
+	})
+}
+
+func warningNotifications(t *testing.T, fakeClients *memberoperatortest.FakeClientSet) []toolchainv1alpha1.Notification {
+	notifications := &toolchainv1alpha1.NotificationList{}
+	require.NoError(t, fakeClients.DefaultClient.List(context.TODO(), notifications,
+		client.MatchingLabels{toolchainv1alpha1.NotificationTypeLabelKey: notificationTypeIdlerWarning}))
+	return notifications.Items
+}
+
+func warningNotificationCreated() toolchainv1alpha1.Condition {
+	return toolchainv1alpha1.Condition{
+		Type:   IdlerWarningNotificationCreated,
+		Status: corev1.ConditionTrue,
+		Reason: IdlerWorkloadsAboutToBeIdledReason,
+	}
+}
+
+func noWorkloadsAboutToBeIdled() toolchainv1alpha1.Condition {
+	return toolchainv1alpha1.Condition{
+		Type:   IdlerWarningNotificationCreated,
+		Status: corev1.ConditionFalse,
+		Reason: IdlerNoWorkloadsAboutToBeIdledReason,
+	}
+}
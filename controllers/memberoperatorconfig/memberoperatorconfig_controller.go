@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/codeready-toolchain/member-operator/controllers/idler"
 	"github.com/codeready-toolchain/member-operator/pkg/autoscaler"
 	"github.com/codeready-toolchain/member-operator/pkg/webhook/deploy"
 	"github.com/go-logr/logr"
@@ -15,6 +16,9 @@ import (
 	membercfg "github.com/codeready-toolchain/toolchain-common/pkg/configuration/memberoperatorconfig"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -55,6 +59,11 @@ func (r *Reconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.
 		return reconcile.Result{}, err
 	}
 
+	// the idler settings don't depend on the deployments below, so they are loaded even when the deployments fail
+	if err := LoadIdlerConfig(ctx, r.Client, request.NamespacedName); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	if err := r.handleAutoscalerDeploy(ctx, crtConfig, request.Namespace); err != nil {
 		return reconcile.Result{}, err
 	}
@@ -66,6 +75,22 @@ func (r *Reconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.
 	return reconcile.Result{}, nil
 }
 
+// LoadIdlerConfig caches the idler settings from the idler section of the MemberOperatorConfig, so the Idler controller uses them
+// on its next reconcile. The MemberOperatorConfig is read as unstructured since the section is not part of its type in the API module.
+// It's also called before the manager is started, so the Idlers are never reconciled with the default settings.
+func LoadIdlerConfig(ctx context.Context, cl client.Reader, name types.NamespacedName) error {
+	memberOperatorConfig := &unstructured.Unstructured{}
+	memberOperatorConfig.SetGroupVersionKind(toolchainv1alpha1.GroupVersion.WithKind("MemberOperatorConfig"))
+	if err := cl.Get(ctx, name, memberOperatorConfig); err != nil {
+		if apierrors.IsNotFound(err) {
+			return idler.LoadConfig(nil)
+		}
+		return err
+	}
+	log.FromContext(ctx).Info("Loading the idler settings")
+	return idler.LoadConfig(memberOperatorConfig)
+}
+
 func (r *Reconciler) handleAutoscalerDeploy(ctx context.Context, cfg membercfg.Configuration, namespace string) error {
 	logger := log.FromContext(ctx)
 	if cfg.Autoscaler().Deploy() {
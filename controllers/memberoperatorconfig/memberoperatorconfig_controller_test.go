@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/codeready-toolchain/member-operator/controllers/idler"
 	"github.com/codeready-toolchain/member-operator/pkg/apis"
 	"github.com/codeready-toolchain/member-operator/pkg/webhook/deploy"
 	commonconfig "github.com/codeready-toolchain/toolchain-common/pkg/configuration"
@@ -117,6 +118,83 @@ func TestReconcileWhenMemberOperatorConfigIsNotPresent(t *testing.T) {
 	matchesDefaultConfig(t, actual)
 }
 
+func TestReconcileLoadsIdlerConfig(t *testing.T) {
+	// given
+	t.Cleanup(func() {
+		require.NoError(t, idler.LoadConfig(nil))
+	})
+	config := commonconfig.NewMemberOperatorConfigWithReset(t)
+	controller, cl := prepareReconcile(t, config)
+	fakeClient := cl.(*test.FakeClient)
+	idlerSection := map[string]any{"dryRun": true}
+	// the idler section is not part of the MemberOperatorConfig type, so the fake client would drop it
+	fakeClient.MockGet = func(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+		if err := fakeClient.Client.Get(ctx, key, obj, opts...); err != nil {
+			return err
+		}
+		if memberOperatorConfig, ok := obj.(*unstructured.Unstructured); ok {
+			return unstructured.SetNestedMap(memberOperatorConfig.Object, idlerSection, "spec", "idler")
+		}
+		return nil
+	}
+
+	// when
+	_, err := controller.Reconcile(context.TODO(), newRequest())
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, idler.GetCachedConfig().DryRun())
+
+	t.Run("settings are updated", func(t *testing.T) {
+		// given
+		idlerSection = map[string]any{"dryRun": false}
+
+		// when
+		_, err := controller.Reconcile(context.TODO(), newRequest())
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, idler.GetCachedConfig().DryRun())
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		// given
+		idlerSection = map[string]any{"dryRun": "yes"}
+
+		// when
+		_, err := controller.Reconcile(context.TODO(), newRequest())
+
+		// then
+		require.ErrorContains(t, err, "invalid idler section of the MemberOperatorConfig")
+	})
+
+	t.Run("settings are loaded before the deployments", func(t *testing.T) {
+		// given
+		idlerSection = map[string]any{"dryRun": true}
+		fakeClient.MockCreate = func(_ context.Context, _ client.Object, _ ...client.CreateOption) error {
+			return fmt.Errorf("client error")
+		}
+		fakeClient.MockUpdate = func(_ context.Context, _ client.Object, _ ...client.UpdateOption) error {
+			return fmt.Errorf("client error")
+		}
+		fakeClient.MockPatch = func(_ context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+			return fmt.Errorf("client error")
+		}
+		t.Cleanup(func() {
+			fakeClient.MockCreate = nil
+			fakeClient.MockUpdate = nil
+			fakeClient.MockPatch = nil
+		})
+
+		// when
+		_, err := controller.Reconcile(context.TODO(), newRequest())
+
+		// then
+		require.Error(t, err)
+		assert.True(t, idler.GetCachedConfig().DryRun())
+	})
+}
+
 func TestHandleAutoscalerDeploy(t *testing.T) {
 	t.Run("deploy false", func(t *testing.T) {
 		// given
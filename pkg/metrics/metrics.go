@@ -15,9 +15,31 @@ var (
 	MemberOperatorVersionGaugeVec *prometheus.GaugeVec
 )
 
+// counters with labels
+var (
+	// IdlerDryRunActionsCounterVec counts the actions the idler would have taken if it wasn't running in the dry-run mode (via the `owner_kind`, `action` and `reason` labels)
+	IdlerDryRunActionsCounterVec *prometheus.CounterVec
+	// IdlerWorkloadsIdledCounterVec counts the workloads idled by the idler (via the `owner_kind` and `reason` labels)
+	IdlerWorkloadsIdledCounterVec *prometheus.CounterVec
+	// IdlerIdlingFailuresCounterVec counts the failed attempts to idle a workload (via the `owner_kind` label)
+	IdlerIdlingFailuresCounterVec *prometheus.CounterVec
+	// IdlerNotificationFailuresCounterVec counts the notifications the idler failed to create (via the `notification_type` label)
+	IdlerNotificationFailuresCounterVec *prometheus.CounterVec
+	// IdlerNotificationsDroppedCounterVec counts the notifications the idler dropped without delivering them (via the `notification_type` and `reason` labels)
+	IdlerNotificationsDroppedCounterVec *prometheus.CounterVec
+)
+
+// histograms with labels
+var (
+	// IdlerPodRunTimeHistogramVec observes how long the pods were running before they were idled, in seconds (via the `reason` label)
+	IdlerPodRunTimeHistogramVec *prometheus.HistogramVec
+)
+
 // collections
 var (
-	allGaugeVecs = []*prometheus.GaugeVec{}
+	allGaugeVecs     = []*prometheus.GaugeVec{}
+	allCounterVecs   = []*prometheus.CounterVec{}
+	allHistogramVecs = []*prometheus.HistogramVec{}
 )
 
 func init() {
@@ -29,6 +51,13 @@ const metricsPrefix = "sandbox_"
 func initMetrics() {
 	log.Info("initializing custom metrics")
 	MemberOperatorVersionGaugeVec = newGaugeVec("member_operator_version", "Current version of the member operator", "commit")
+	IdlerDryRunActionsCounterVec = newCounterVec("idler_dry_run_actions_total", "Number of actions the idler would have taken when not running in the dry-run mode", "owner_kind", "action", "reason")
+	IdlerWorkloadsIdledCounterVec = newCounterVec("idler_workloads_idled_total", "Number of workloads idled by the idler", "owner_kind", "reason")
+	IdlerIdlingFailuresCounterVec = newCounterVec("idler_idling_failures_total", "Number of failed attempts to idle a workload", "owner_kind")
+	IdlerNotificationFailuresCounterVec = newCounterVec("idler_notification_failures_total", "Number of notifications the idler failed to create", "notification_type")
+	IdlerNotificationsDroppedCounterVec = newCounterVec("idler_notifications_dropped_total", "Number of notifications the idler dropped without delivering them", "notification_type", "reason")
+	// from 5 minutes to ~2 days
+	IdlerPodRunTimeHistogramVec = newHistogramVec("idler_pod_run_time_seconds", "Time the pods were running before they were idled", prometheus.ExponentialBuckets(300, 2, 10), "reason")
 	log.Info("custom metrics initialized")
 }
 
@@ -47,12 +76,37 @@ func newGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
 	return v
 }
 
+func newCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	v := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricsPrefix + name,
+		Help: help,
+	}, labels)
+	allCounterVecs = append(allCounterVecs, v)
+	return v
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	v := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    metricsPrefix + name,
+		Help:    help,
+		Buckets: buckets,
+	}, labels)
+	allHistogramVecs = append(allHistogramVecs, v)
+	return v
+}
+
 // RegisterCustomMetrics registers the custom metrics
 func RegisterCustomMetrics() {
 	// register metrics
 	for _, v := range allGaugeVecs {
 		k8smetrics.Registry.MustRegister(v)
 	}
+	for _, v := range allCounterVecs {
+		k8smetrics.Registry.MustRegister(v)
+	}
+	for _, v := range allHistogramVecs {
+		k8smetrics.Registry.MustRegister(v)
+	}
 
 	// expose the MemberOperatorVersionGaugeVec metric (static ie, 1 value per build/deployment)
 	MemberOperatorVersionGaugeVec.WithLabelValues(version.Commit[0:7]).Set(1)
@@ -21,6 +21,33 @@ func TestInitGaugeVec(t *testing.T) {
 	assert.InDelta(t, float64(2), promtestutil.ToFloat64(m.WithLabelValues("member-2")), 0.01)
 }
 
+func TestInitCounterVec(t *testing.T) {
+	// given
+	m := newCounterVec("test_counter_vec", "test counter description", "kind")
+
+	// when
+	m.WithLabelValues("Deployment").Inc()
+	m.WithLabelValues("Deployment").Inc()
+	m.WithLabelValues("Job").Inc()
+
+	// then
+	assert.InDelta(t, float64(2), promtestutil.ToFloat64(m.WithLabelValues("Deployment")), 0.01)
+	assert.InDelta(t, float64(1), promtestutil.ToFloat64(m.WithLabelValues("Job")), 0.01)
+}
+
+func TestInitHistogramVec(t *testing.T) {
+	// given
+	m := newHistogramVec("test_histogram_vec", "test histogram description", []float64{10, 100}, "reason")
+
+	// when
+	m.WithLabelValues("timeout").Observe(5)
+	m.WithLabelValues("timeout").Observe(50)
+	m.WithLabelValues("evicted").Observe(500)
+
+	// then
+	assert.Equal(t, 2, promtestutil.CollectAndCount(m, metricsPrefix+"test_histogram_vec"))
+}
+
 func TestRegisterCustomMetrics(t *testing.T) {
 	// when
 	RegisterCustomMetrics()
@@ -30,4 +57,10 @@ func TestRegisterCustomMetrics(t *testing.T) {
 	for _, m := range allGaugeVecs {
 		assert.True(t, k8smetrics.Registry.Unregister(m))
 	}
+	for _, m := range allCounterVecs {
+		assert.True(t, k8smetrics.Registry.Unregister(m))
+	}
+	for _, m := range allHistogramVecs {
+		assert.True(t, k8smetrics.Registry.Unregister(m))
+	}
 }